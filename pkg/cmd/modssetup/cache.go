@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	urlToHashFile = "urlToHash.json"
+	// prefix of files being downloaded, left over ones are garbage collected
+	downloadTmpPrefix = "download-"
+)
+
+// Mods are cached by sha1, urlToHash remembers which file each url resolved to.
+// Safe for concurrent use
+type modCache struct {
+	path string
+	// retries of a failed download, hash mismatches are not retried
+	retries int
+	backoff time.Duration
+	// a stalled download fails after the timeout and is retried
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	urlToHash map[string]string
+}
+
+func newModCache(cachePath string) *modCache {
+	return &modCache{
+		path:       cachePath,
+		retries:    3,
+		backoff:    time.Second,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		urlToHash:  map[string]string{},
+	}
+}
+
+// broken url map is discarded, the mods are then downloaded again
+func readModCache(cachePath string) *modCache {
+	cache := newModCache(cachePath)
+	mapPath := path.Join(cachePath, urlToHashFile)
+	mapContents, err := os.ReadFile(mapPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println(err.Error())
+		}
+		return cache
+	}
+	err = json.Unmarshal(mapContents, &cache.urlToHash)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Remove(mapPath)
+		cache.urlToHash = map[string]string{}
+	}
+	return cache
+}
+
+func (c *modCache) getHash(modUrl string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.urlToHash[modUrl]
+	return hash, ok
+}
+
+func (c *modCache) setHash(modUrl string, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urlToHash[modUrl] = hash
+}
+
+type loadOutcome int
+
+const (
+	loadCached loadOutcome = iota
+	loadDownloaded
+)
+
+// Returns path of the cached mod, downloading it if it is missing or does not match expected hashes
+func (c *modCache) load(modUrl string, expected fileHashes) (string, loadOutcome, error) {
+	doDownload := false
+	hash, ok := c.getHash(modUrl)
+	if !ok {
+		doDownload = true
+	} else {
+		if _, err := os.Stat(path.Join(c.path, hash)); err != nil {
+			doDownload = true
+		} else if expected.Sha1 != "" && !strings.EqualFold(expected.Sha1, hash) {
+			// url now points to another file than expected
+			doDownload = true
+		} else if err := verifyCachedFile(path.Join(c.path, hash), hash, expected); err != nil {
+			fmt.Println("Discarding corrupted cached mod: " + err.Error())
+			os.Remove(path.Join(c.path, hash))
+			doDownload = true
+		}
+	}
+	if !doDownload {
+		fmt.Println("Using cached mod: " + path.Join(c.path, hash))
+		return path.Join(c.path, hash), loadCached, nil
+	}
+	hash, err := c.downloadWithRetries(modUrl, expected)
+	if err != nil {
+		return "", loadDownloaded, err
+	}
+	c.setHash(modUrl, hash)
+	return path.Join(c.path, hash), loadDownloaded, nil
+}
+
+func (c *modCache) downloadWithRetries(modUrl string, expected fileHashes) (string, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		hash, err := c.download(modUrl, expected)
+		if err == nil || attempt >= c.retries || errors.Is(err, ErrorHashMismatch{}) {
+			return hash, err
+		}
+		fmt.Printf("Retrying download of %s in %s: %s\n", modUrl, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Downloads the mod into the cache and returns its sha1, which is the name of the cached file.
+// The download is discarded if it does not match the expected hashes
+func (c *modCache) download(modUrl string, expected fileHashes) (string, error) {
+	fmt.Println("Downloading mod: " + modUrl)
+	resp, err := c.httpClient.Get(modUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("failed to download mod: %s", resp.Status)
+	}
+	tmpFile, err := os.CreateTemp(c.path, downloadTmpPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	// removing is a no-op once the file is renamed
+	defer os.Remove(tmpPath)
+	defer tmpFile.Close()
+	_, err = io.Copy(tmpFile, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return "", err
+	}
+	hashes, err := hashFile(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	err = verifyHashes(modUrl, hashes, expected)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmpPath, path.Join(c.path, hashes.Sha1))
+	if err != nil {
+		return "", err
+	}
+	return hashes.Sha1, nil
+}
+
+// cached file is valid if it still matches its name and the expected hashes
+func verifyCachedFile(cachedPath string, hash string, expected fileHashes) error {
+	actual, err := hashFile(cachedPath)
+	if err != nil {
+		return err
+	}
+	return verifyHashes(cachedPath, actual, fileHashes{Sha1: hash, Sha512: expected.Sha512})
+}
+
+// forgets urls which are not used anymore, their files are then garbage collected
+func (c *modCache) retainUrls(urls []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	used := map[string]bool{}
+	for _, modUrl := range urls {
+		used[modUrl] = true
+	}
+	for modUrl := range c.urlToHash {
+		if !used[modUrl] {
+			delete(c.urlToHash, modUrl)
+		}
+	}
+}
+
+func (c *modCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	urlToHashJson, err := json.Marshal(c.urlToHash)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(c.path, urlToHashFile), urlToHashJson, 0644)
+}
+
+// Removes cached files not referenced by the url map, returns their names
+func (c *modCache) collectGarbage() ([]string, error) {
+	c.mu.Lock()
+	referenced := map[string]bool{urlToHashFile: true}
+	for _, hash := range c.urlToHash {
+		referenced[hash] = true
+	}
+	c.mu.Unlock()
+	entries, err := os.ReadDir(c.path)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || referenced[entry.Name()] {
+			continue
+		}
+		err = os.Remove(path.Join(c.path, entry.Name()))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
+
+func copyFile(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, srcFile)
+	if err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadModsReportsAndRetries(t *testing.T) {
+	jar := buildJar(t, map[string][]byte{
+		"fabric.mod.json": []byte(`{"id": "flaky", "version": "1.0.0"}`),
+	})
+	mu := sync.Mutex{}
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/missing.jar":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/flaky.jar" && count < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write(jar)
+		}
+	}))
+	defer server.Close()
+	cachePath := t.TempDir()
+	cache := newModCache(cachePath)
+	cache.backoff = 0
+
+	jarSha512 := fmt.Sprintf("%x", sha512.Sum512(jar))
+	mods := []ModDescription{
+		{File: "flaky.jar", Sha512: jarSha512},
+		{File: "stable.jar", Sha512: jarSha512},
+		{File: "missing.jar", Sha512: jarSha512},
+	}
+	urls := []string{server.URL + "/flaky.jar", server.URL + "/stable.jar", server.URL + "/missing.jar"}
+	loaded, report := loadMods(mods, urls, Lockfile{}, cache, 2, false)
+	if len(loaded) != 2 || loaded[0].Fabric == nil || loaded[0].Fabric.Id != "flaky" {
+		t.Fatalf("Unexpected loaded mods %+v", loaded)
+	}
+	if len(report.Downloaded) != 2 || len(report.Cached) != 0 || len(report.Failed) != 1 || report.Failed["missing.jar"] == nil {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if requests["/flaky.jar"] != 3 || requests["/missing.jar"] != 4 {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	loaded, report = loadMods(mods[:2], urls[:2], Lockfile{}, cache, 2, false)
+	if len(loaded) != 2 || len(report.Cached) != 2 || len(report.Downloaded) != 0 {
+		t.Fatalf("Mods should be cached: %+v", report)
+	}
+	entries, _ := os.ReadDir(cachePath)
+	if len(entries) != 1 {
+		t.Errorf("Downloads should not leave temp files, got %d files", len(entries))
+	}
+}
+
+func TestStalledDownloadTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	cache := newModCache(t.TempDir())
+	cache.retries = 0
+	cache.httpClient.Timeout = 50 * time.Millisecond
+
+	_, err := cache.downloadWithRetries(server.URL+"/stalled.jar", fileHashes{Sha512: "00"})
+	if err == nil || errors.Is(err, ErrorHashMismatch{}) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	cachePath := t.TempDir()
+	for _, name := range []string{"aaa", "bbb", "ccc", downloadTmpPrefix + "123", "tmpmod"} {
+		os.WriteFile(path.Join(cachePath, name), []byte(name), 0644)
+	}
+	cache := newModCache(cachePath)
+	cache.urlToHash = map[string]string{"https://a": "aaa", "https://b": "bbb", "https://b2": "bbb"}
+	cache.retainUrls([]string{"https://a", "https://b2"})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := cache.collectGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if len(removed) != 3 || removed[0] != "ccc" || removed[1] != downloadTmpPrefix+"123" || removed[2] != "tmpmod" {
+		t.Fatalf("Unexpected removed files %v", removed)
+	}
+	saved := readModCache(cachePath)
+	if len(saved.urlToHash) != 2 || saved.urlToHash["https://b2"] != "bbb" {
+		t.Errorf("Unexpected saved map %v", saved.urlToHash)
+	}
+	for _, name := range []string{"aaa", "bbb", urlToHashFile} {
+		if _, err := os.Stat(path.Join(cachePath, name)); err != nil {
+			t.Errorf("%s should be kept", name)
+		}
+	}
+}
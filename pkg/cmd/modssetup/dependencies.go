@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type loadedMod struct {
+	Description ModDescription
+	Url         string
+	// path of the cached jar
+	Path string
+	// nil if the jar is not a fabric mod
+	Fabric *fabricMod
+}
+
+type compatibilityProblem struct {
+	File        string
+	Environment string
+	Message     string
+}
+
+func (p compatibilityProblem) String() string {
+	return fmt.Sprintf("%s (%s): %s", p.File, p.Environment, p.Message)
+}
+
+// versions of the game, loader and java, fabric loader provides them as mods
+type platformVersions struct {
+	Minecraft string
+	Loader    string
+	Java      string
+}
+
+// Checks depends and breaks of server mods and of client mods separately
+func checkCompatibility(mods []loadedMod, platform platformVersions) []compatibilityProblem {
+	problems := []compatibilityProblem{}
+	for _, environment := range []string{"server", "client"} {
+		problems = append(problems, checkEnvironment(environment, mods, platform)...)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].String() < problems[j].String()
+	})
+	return problems
+}
+
+func checkEnvironment(environment string, mods []loadedMod, platform platformVersions) []compatibilityProblem {
+	installed := []loadedMod{}
+	for _, mod := range mods {
+		if environment == "server" && mod.Description.NoServer ||
+			environment == "client" && !mod.Description.AddToClient {
+			continue
+		}
+		if mod.Fabric == nil || mod.Fabric.Environment != "" && mod.Fabric.Environment != "*" && mod.Fabric.Environment != environment {
+			// not loaded in this environment
+			continue
+		}
+		installed = append(installed, mod)
+	}
+	provided := map[string]string{}
+	// empty platform versions are not checked
+	unknown := map[string]bool{}
+	for id, version := range map[string]string{
+		"minecraft":    platform.Minecraft,
+		"fabricloader": platform.Loader,
+		"java":         platform.Java,
+	} {
+		if version == "" {
+			unknown[id] = true
+		} else {
+			provided[id] = version
+		}
+	}
+	for _, mod := range installed {
+		mod.Fabric.providedIds(provided)
+	}
+	problems := []compatibilityProblem{}
+	for _, mod := range installed {
+		for _, fabric := range mod.Fabric.allMods() {
+			for id, predicates := range fabric.Depends {
+				if unknown[id] {
+					continue
+				}
+				version, ok := provided[id]
+				if !ok {
+					problems = append(problems, compatibilityProblem{
+						File:        mod.Description.File,
+						Environment: environment,
+						Message:     fmt.Sprintf("%s requires missing %s %s", fabric.Id, id, strings.Join(predicates, " || ")),
+					})
+					continue
+				}
+				matches, err := matchesAnyPredicate(version, predicates)
+				if err != nil || !matches {
+					problems = append(problems, compatibilityProblem{
+						File:        mod.Description.File,
+						Environment: environment,
+						Message: fmt.Sprintf("%s requires %s %s, found %s%s",
+							fabric.Id, id, strings.Join(predicates, " || "), version, describePredicateError(err)),
+					})
+				}
+			}
+			for id, predicates := range fabric.Breaks {
+				version, ok := provided[id]
+				if !ok {
+					continue
+				}
+				matches, err := matchesAnyPredicate(version, predicates)
+				if err != nil || matches {
+					problems = append(problems, compatibilityProblem{
+						File:        mod.Description.File,
+						Environment: environment,
+						Message: fmt.Sprintf("%s breaks with %s %s, found %s%s",
+							fabric.Id, id, strings.Join(predicates, " || "), version, describePredicateError(err)),
+					})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func matchesAnyPredicate(version string, predicates versionPredicates) (bool, error) {
+	for _, predicate := range predicates {
+		matches, err := matchesVersionPredicate(version, predicate)
+		if err != nil {
+			return false, err
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func describePredicateError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return " (" + err.Error() + ")"
+}
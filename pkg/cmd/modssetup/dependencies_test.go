@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMatchesVersionPredicate(t *testing.T) {
+	cases := []struct {
+		version   string
+		predicate string
+		matches   bool
+	}{
+		{"1.21.4", "*", true},
+		{"1.21.4", "1.21.4", true},
+		{"1.21.4", "=1.21.3", false},
+		{"1.21.4", ">=1.21", true},
+		{"1.21.4", ">=1.21.2 <1.21.5", true},
+		{"1.21.5", ">=1.21.2 <1.21.5", false},
+		{"1.21.4", "~1.21.2", true},
+		{"1.22", "~1.21.2", false},
+		{"1.22", "^1.21.2", true},
+		{"2.0.0", "^1.21.2", false},
+		{"1.21.4", "1.21.x", true},
+		{"1.20.6", "1.21.x", false},
+		{"0.16.10", ">=0.15.0", true},
+		{"1.0.0-beta.2", ">=1.0.0-beta.10", false},
+		{"1.0.0", ">1.0.0-rc.1", true},
+		{"1.0.0+build.5", "1.0.0", true},
+		{"21", ">=17", true},
+		{"snapshot", "snapshot", true},
+	}
+	for _, c := range cases {
+		matches, err := matchesVersionPredicate(c.version, c.predicate)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.version, c.predicate, err)
+		}
+		if matches != c.matches {
+			t.Errorf("%s %s: expected %v", c.version, c.predicate, c.matches)
+		}
+	}
+	if _, err := matchesVersionPredicate("1.21.4", ">=1.21.x"); err == nil {
+		t.Error("Wildcard with operator should be rejected")
+	}
+}
+
+func buildJar(t *testing.T, files map[string][]byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(content)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func writeJar(t *testing.T, files map[string][]byte) string {
+	jarPath := path.Join(t.TempDir(), "mod.jar")
+	if err := os.WriteFile(jarPath, buildJar(t, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return jarPath
+}
+
+func loadTestMod(t *testing.T, description ModDescription, files map[string][]byte) loadedMod {
+	fabric, err := readFabricModFile(writeJar(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadedMod{Description: description, Fabric: fabric}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	platform := platformVersions{Minecraft: "1.21.4", Loader: "0.16.10", Java: "21"}
+	api := loadTestMod(t, ModDescription{File: "api.jar", AddToClient: true}, map[string][]byte{
+		"fabric.mod.json": []byte(`{"id": "fabric-api", "version": "0.110.0",
+			"depends": {"fabricloader": ">=0.16.0", "minecraft": "~1.21.4"},
+			"jars": [{"file": "META-INF/jars/fabric-api-base.jar"}]}`),
+		"META-INF/jars/fabric-api-base.jar": buildJar(t, map[string][]byte{
+			"fabric.mod.json": []byte(`{"id": "fabric-api-base", "version": "0.4.50",
+				"description": "multi
+line"}`),
+		}),
+	})
+	if len(api.Fabric.Nested) != 1 || api.Fabric.Nested[0].Id != "fabric-api-base" {
+		t.Fatalf("Nested jar was not read: %+v", api.Fabric.Nested)
+	}
+	plain := loadTestMod(t, ModDescription{File: "plain.jar"}, map[string][]byte{
+		"plugin.yml": []byte("name: plain"),
+	})
+	if plain.Fabric != nil {
+		t.Fatal("Jar without fabric.mod.json should not be a fabric mod")
+	}
+	dependent := loadTestMod(t, ModDescription{File: "dependent.jar", AddToClient: true}, map[string][]byte{
+		"fabric.mod.json": []byte(`{"id": "dependent", "version": "1.0.0",
+			"depends": {"fabric-api-base": "*", "java": ">=17"}}`),
+	})
+	if problems := checkCompatibility([]loadedMod{api, plain, dependent}, platform); len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+
+	clientOnly := loadTestMod(t, ModDescription{File: "client.jar", NoServer: true, AddToClient: true}, map[string][]byte{
+		"fabric.mod.json": []byte(`{"id": "client", "version": "1.0.0", "environment": "client",
+			"depends": {"sodium": ["0.6.x", "0.7.x"]}, "breaks": {"dependent": "<2"}}`),
+	})
+	problems := checkCompatibility([]loadedMod{api, dependent, clientOnly}, platform)
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	for _, problem := range problems {
+		if problem.File != "client.jar" || problem.Environment != "client" {
+			t.Errorf("Unexpected problem %v", problem)
+		}
+	}
+	if !strings.Contains(problems[0].Message, "breaks with dependent") ||
+		!strings.Contains(problems[1].Message, "requires missing sodium 0.6.x || 0.7.x") {
+		t.Errorf("Unexpected messages %v", problems)
+	}
+
+	// server does not have the client copy of the api
+	problems = checkCompatibility([]loadedMod{
+		{Description: ModDescription{File: "api.jar", NoServer: true, AddToClient: true}, Fabric: api.Fabric},
+		dependent,
+	}, platformVersions{Minecraft: "1.21.4"})
+	if len(problems) != 1 || problems[0].Environment != "server" ||
+		!strings.Contains(problems[0].Message, "requires missing fabric-api-base") {
+		t.Fatalf("Expected missing dependency on server, got %v", problems)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// version predicates of a dependency, any of them must match
+type versionPredicates []string
+
+func (p *versionPredicates) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = versionPredicates{single}
+		return nil
+	}
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return err
+	}
+	*p = multiple
+	return nil
+}
+
+// fields of fabric.mod.json used for compatibility checks
+type fabricModJson struct {
+	Id          string                       `json:"id"`
+	Name        string                       `json:"name"`
+	Version     string                       `json:"version"`
+	Environment string                       `json:"environment"`
+	Provides    []string                     `json:"provides"`
+	Depends     map[string]versionPredicates `json:"depends"`
+	Breaks      map[string]versionPredicates `json:"breaks"`
+	Jars        []struct {
+		File string `json:"file"`
+	} `json:"jars"`
+}
+
+// mod with the mods nested in its jar
+type fabricMod struct {
+	fabricModJson
+	Nested []fabricMod
+}
+
+var controlCharsReplacer = strings.NewReplacer("\n", " ", "\r", " ", "\t", " ")
+
+// nested jars deeper than this are ignored
+const maxNestedJarsDepth = 4
+
+func readFabricModFile(jarPath string) (*fabricMod, error) {
+	reader, err := zip.OpenReader(jarPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open jar %s: %w", jarPath, err)
+	}
+	defer reader.Close()
+	return readFabricMod(&reader.Reader, 0)
+}
+
+// returns nil if the jar is not a fabric mod
+func readFabricMod(jar *zip.Reader, depth int) (*fabricMod, error) {
+	modJson, err := readZipEntry(jar, "fabric.mod.json")
+	if err != nil || modJson == nil {
+		return nil, err
+	}
+	mod := &fabricMod{}
+	// json strings in the wild contain raw newlines and tabs which encoding/json rejects
+	err = json.Unmarshal([]byte(controlCharsReplacer.Replace(string(modJson))), &mod.fabricModJson)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse fabric.mod.json: %w", err)
+	}
+	if depth >= maxNestedJarsDepth {
+		return mod, nil
+	}
+	for _, nestedJar := range mod.Jars {
+		content, err := readZipEntry(jar, nestedJar.File)
+		if err != nil {
+			return nil, err
+		}
+		if content == nil {
+			continue
+		}
+		nestedReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot open nested jar %s: %w", nestedJar.File, err)
+		}
+		nested, err := readFabricMod(nestedReader, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("nested jar %s: %w", nestedJar.File, err)
+		}
+		if nested != nil {
+			mod.Nested = append(mod.Nested, *nested)
+		}
+	}
+	return mod, nil
+}
+
+// nil if there is no such entry
+func readZipEntry(jar *zip.Reader, name string) ([]byte, error) {
+	name = strings.TrimPrefix(name, "/")
+	for _, file := range jar.File {
+		if file.Name != name {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("cannot open %s: %w", name, err)
+		}
+		defer entry.Close()
+		return io.ReadAll(entry)
+	}
+	return nil, nil
+}
+
+// ids with versions provided by the mod and its nested jars
+func (mod *fabricMod) providedIds(result map[string]string) {
+	result[mod.Id] = mod.Version
+	for _, id := range mod.Provides {
+		result[id] = mod.Version
+	}
+	for i := range mod.Nested {
+		mod.Nested[i].providedIds(result)
+	}
+}
+
+// nested mods declare dependencies too
+func (mod *fabricMod) allMods() []*fabricMod {
+	mods := []*fabricMod{mod}
+	for i := range mod.Nested {
+		mods = append(mods, mod.Nested[i].allMods()...)
+	}
+	return mods
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version as compared by fabric loader: semver-like with any number of numeric
+// components, optional -prerelease and ignored +build metadata.
+// Versions which are not semver only compare equal to themselves.
+type fabricVersion struct {
+	raw        string
+	components []int
+	prerelease []string
+	isSemver   bool
+}
+
+func parseFabricVersion(s string) fabricVersion {
+	v := fabricVersion{raw: s}
+	core := s
+	if i := strings.IndexByte(core, '+'); i >= 0 {
+		core = core[:i]
+	}
+	if i := strings.IndexByte(core, '-'); i >= 0 {
+		v.prerelease = strings.Split(core[i+1:], ".")
+		core = core[:i]
+	}
+	for _, part := range strings.Split(core, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return fabricVersion{raw: s}
+		}
+		v.components = append(v.components, n)
+	}
+	v.isSemver = true
+	return v
+}
+
+func (v fabricVersion) component(i int) int {
+	if i < len(v.components) {
+		return v.components[i]
+	}
+	return 0
+}
+
+// -1, 0 or 1. Non semver versions are compared as strings
+func compareFabricVersions(a fabricVersion, b fabricVersion) int {
+	if !a.isSemver || !b.isSemver {
+		return strings.Compare(a.raw, b.raw)
+	}
+	for i := 0; i < max(len(a.components), len(b.components)); i++ {
+		if a.component(i) != b.component(i) {
+			if a.component(i) < b.component(i) {
+				return -1
+			}
+			return 1
+		}
+	}
+	// release is newer than its prereleases
+	if len(a.prerelease) == 0 || len(b.prerelease) == 0 {
+		return compareInts(len(b.prerelease), len(a.prerelease))
+	}
+	for i := 0; i < min(len(a.prerelease), len(b.prerelease)); i++ {
+		if c := comparePrereleaseIdentifiers(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a.prerelease), len(b.prerelease))
+}
+
+func comparePrereleaseIdentifiers(a string, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(an, bn)
+	case aErr == nil:
+		// numeric identifiers have lower precedence
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Checks version against a fabric.mod.json version predicate like "*", ">=1.2 <2",
+// "~1.2.3", "^1.2.3" or "1.21.x". Space separated predicates must all match
+func matchesVersionPredicate(version string, predicate string) (bool, error) {
+	v := parseFabricVersion(version)
+	for _, term := range strings.Fields(predicate) {
+		ok, err := matchesVersionTerm(v, term)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchesVersionTerm(v fabricVersion, term string) (bool, error) {
+	if term == "*" {
+		return true, nil
+	}
+	operator := ""
+	for _, op := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(term, op) {
+			operator = op
+			term = strings.TrimPrefix(term, op)
+			break
+		}
+	}
+	// 1.21.x is the range of all patches
+	if strings.HasSuffix(term, ".x") || strings.HasSuffix(term, ".X") || strings.HasSuffix(term, ".*") {
+		if operator != "" && operator != "=" {
+			return false, fmt.Errorf("wildcard with operator %s in %s", operator, term)
+		}
+		base := parseFabricVersion(term[:len(term)-2])
+		if !base.isSemver {
+			return false, fmt.Errorf("invalid version %s", term)
+		}
+		next := append([]int{}, base.components...)
+		next[len(next)-1]++
+		return compareCore(v, base.components) >= 0 && compareCore(v, next) < 0, nil
+	}
+	target := parseFabricVersion(term)
+	c := compareFabricVersions(v, target)
+	switch operator {
+	case ">=":
+		return c >= 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case "<":
+		return c < 0, nil
+	case "~":
+		// same minor
+		return c >= 0 && v.isSemver && v.component(0) == target.component(0) && v.component(1) == target.component(1), nil
+	case "^":
+		// same major
+		return c >= 0 && v.isSemver && v.component(0) == target.component(0), nil
+	default:
+		return c == 0, nil
+	}
+}
+
+func compareCore(v fabricVersion, components []int) int {
+	if !v.isSemver {
+		return -1
+	}
+	return compareFabricVersions(fabricVersion{components: v.components, isSemver: true},
+		fabricVersion{components: components, isSemver: true})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// hex encoded, empty hashes are not checked
+type fileHashes struct {
+	Sha1   string
+	Sha512 string
+}
+
+func (h fileHashes) isEmpty() bool {
+	return h.Sha1 == "" && h.Sha512 == ""
+}
+
+type ErrorHashMismatch struct {
+	Source    string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e ErrorHashMismatch) Error() string {
+	return fmt.Sprintf("%s mismatch for %s: expected %s, got %s", e.Algorithm, e.Source, e.Expected, e.Actual)
+}
+
+func (e ErrorHashMismatch) Is(target error) bool {
+	_, ok := target.(ErrorHashMismatch)
+	return ok
+}
+
+type ErrorMissingHash struct {
+	File string
+}
+
+func (e ErrorMissingHash) Error() string {
+	return fmt.Sprintf("no expected sha512 or sha1 for mod %s, pin it with modssetup pin or pass -allow-unverified", e.File)
+}
+
+func (e ErrorMissingHash) Is(target error) bool {
+	_, ok := target.(ErrorMissingHash)
+	return ok
+}
+
+// manifest hashes take precedence over the ones locked from modrinth
+func expectedHashes(mod ModDescription, lock Lockfile) fileHashes {
+	expected := fileHashes{Sha1: mod.Sha1, Sha512: mod.Sha512}
+	if locked, ok := lock[mod.File]; ok && mod.Modrinth != "" {
+		if expected.Sha1 == "" {
+			expected.Sha1 = locked.Sha1
+		}
+		if expected.Sha512 == "" {
+			expected.Sha512 = locked.Sha512
+		}
+	}
+	return expected
+}
+
+func hashFile(filePath string) (fileHashes, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fileHashes{}, err
+	}
+	defer file.Close()
+	sha1Hash := sha1.New()
+	sha512Hash := sha512.New()
+	_, err = io.Copy(io.MultiWriter(sha1Hash, sha512Hash), file)
+	if err != nil {
+		return fileHashes{}, err
+	}
+	return fileHashes{
+		Sha1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		Sha512: hex.EncodeToString(sha512Hash.Sum(nil)),
+	}, nil
+}
+
+func verifyHashes(source string, actual fileHashes, expected fileHashes) error {
+	if expected.Sha512 != "" && !strings.EqualFold(expected.Sha512, actual.Sha512) {
+		return ErrorHashMismatch{Source: source, Algorithm: "sha512", Expected: expected.Sha512, Actual: actual.Sha512}
+	}
+	if expected.Sha1 != "" && !strings.EqualFold(expected.Sha1, actual.Sha1) {
+		return ErrorHashMismatch{Source: source, Algorithm: "sha1", Expected: expected.Sha1, Actual: actual.Sha1}
+	}
+	return nil
+}
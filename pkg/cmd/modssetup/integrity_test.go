@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadHashedFileVerifiesHashes(t *testing.T) {
+	content := "mod jar contents"
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	cachePath := t.TempDir()
+	contentPath := path.Join(t.TempDir(), "mod.jar")
+	os.WriteFile(contentPath, []byte(content), 0644)
+	expected, err := hashFile(contentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newModCache(cachePath)
+
+	_, _, err = cache.load(server.URL, fileHashes{Sha512: "00"})
+	if !errors.Is(err, ErrorHashMismatch{}) {
+		t.Fatalf("Expected hash mismatch, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(cachePath, expected.Sha1)); !os.IsNotExist(err) {
+		t.Fatal("Mismatched download should not be cached")
+	}
+
+	cachedPath, _, err := cache.load(server.URL, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cachedPath != path.Join(cachePath, expected.Sha1) {
+		t.Fatalf("Wrong cached path %s", cachedPath)
+	}
+	_, _, err = cache.load(server.URL, expected)
+	if err != nil || downloads != 2 {
+		t.Fatalf("Cached mod should be reused: %d downloads, %v", downloads, err)
+	}
+
+	// corrupted cache is downloaded again
+	os.WriteFile(cachedPath, []byte("truncated"), 0644)
+	_, _, err = cache.load(server.URL, expected)
+	if err != nil || downloads != 3 {
+		t.Fatalf("Corrupted cache should be downloaded again: %d downloads, %v", downloads, err)
+	}
+	actual, _ := hashFile(cachedPath)
+	if actual != expected {
+		t.Fatal("Cached mod was not restored")
+	}
+}
+
+func TestMissingHashFailsSetup(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("unverified"))
+	}))
+	defer server.Close()
+	cache := newModCache(t.TempDir())
+
+	mods := []ModDescription{{Url: server.URL + "/mod.jar", File: "mod.jar"}}
+	loaded, report := loadMods(mods, []string{mods[0].Url}, Lockfile{}, cache, 1, false)
+	if len(loaded) != 0 || !errors.Is(report.Failed["mod.jar"], ErrorMissingHash{}) {
+		t.Fatalf("Mod without hash should fail, got %v", report.Failed)
+	}
+	if downloads != 0 {
+		t.Fatal("Mod without hash should not be downloaded")
+	}
+}
+
+func TestPinHashes(t *testing.T) {
+	content := "mod jar contents"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	contentPath := path.Join(t.TempDir(), "mod.jar")
+	os.WriteFile(contentPath, []byte(content), 0644)
+	expected, err := hashFile(contentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newModCache(t.TempDir())
+	mods := []ModDescription{
+		{Url: server.URL + "/a.jar", File: "a.jar", AddToClient: true},
+		{Url: server.URL + "/b.jar", File: "b.jar", Sha512: "kept"},
+		{Modrinth: "lithium", File: "lithium.jar"},
+	}
+	pinned, err := pinHashes(mods, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 1 || pinned[0] != "a.jar" || mods[0].Sha512 != expected.Sha512 {
+		t.Fatalf("Unexpected pinned mods %v %+v", pinned, mods)
+	}
+	if mods[1].Sha512 != "kept" || mods[2].Sha512 != "" {
+		t.Fatalf("Only url mods without sha512 should be pinned %+v", mods)
+	}
+
+	manifestPath := path.Join(t.TempDir(), "mods.json")
+	err = writeManifest(manifestPath, mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := readManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 3 || written[0] != mods[0] || written[2] != mods[2] {
+		t.Fatalf("Manifest is not preserved %+v", written)
+	}
+}
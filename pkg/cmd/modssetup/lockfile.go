@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// mod resolved from modrinth, pinned so that builds are reproducible
+type LockedMod struct {
+	Modrinth      string `json:"modrinth"`
+	GameVersion   string `json:"game_version"`
+	Loader        string `json:"loader"`
+	ProjectId     string `json:"project_id"`
+	VersionId     string `json:"version_id"`
+	VersionNumber string `json:"version_number"`
+	Url           string `json:"url"`
+	Size          int64  `json:"size"`
+	Sha1          string `json:"sha1"`
+	Sha512        string `json:"sha512"`
+}
+
+// by mod file
+type Lockfile map[string]LockedMod
+
+func readLockfile(path string) (Lockfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Lockfile{}, nil
+		}
+		return nil, err
+	}
+	lock := Lockfile{}
+	err = json.Unmarshal(content, &lock)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse lockfile %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+func writeLockfile(path string, lock Lockfile) error {
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+func lockVersion(mod ModDescription, target modTarget, version *ModrinthVersion) LockedMod {
+	file := version.primaryFile()
+	return LockedMod{
+		Modrinth:      mod.Modrinth,
+		GameVersion:   target.GameVersion,
+		Loader:        target.Loader,
+		ProjectId:     version.ProjectId,
+		VersionId:     version.Id,
+		VersionNumber: version.VersionNumber,
+		Url:           file.Url,
+		Size:          file.Size,
+		Sha1:          file.Hashes["sha1"],
+		Sha512:        file.Hashes["sha512"],
+	}
+}
+
+// locked entry is stale if the manifest entry was changed since
+func (locked LockedMod) matches(mod ModDescription, target modTarget) bool {
+	if locked.Modrinth != mod.Modrinth || locked.GameVersion != target.GameVersion || locked.Loader != target.Loader {
+		return false
+	}
+	return mod.Version == "" || locked.VersionNumber == mod.Version
+}
+
+// drops mods removed from the manifest
+func (lock Lockfile) prune(mods []ModDescription) {
+	files := make(map[string]struct{}, len(mods))
+	for _, mod := range mods {
+		if mod.Modrinth != "" {
+			files[mod.File] = struct{}{}
+		}
+	}
+	for file := range lock {
+		if _, ok := files[file]; !ok {
+			delete(lock, file)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/imobulus/subchat-mc-server/src/modsindex"
+)
+
+// Modrinth modpack format, see https://support.modrinth.com/en/articles/8802351-modrinth-modpack-format-mrpack
+type mrpackIndex struct {
+	FormatVersion int               `json:"formatVersion"`
+	Game          string            `json:"game"`
+	VersionId     string            `json:"versionId"`
+	Name          string            `json:"name"`
+	Files         []mrpackFile      `json:"files"`
+	Dependencies  map[string]string `json:"dependencies"`
+}
+
+type mrpackFile struct {
+	Path      string       `json:"path"`
+	Hashes    mrpackHashes `json:"hashes"`
+	Env       mrpackEnv    `json:"env"`
+	Downloads []string     `json:"downloads"`
+	FileSize  int64        `json:"fileSize"`
+}
+
+type mrpackHashes struct {
+	Sha1   string `json:"sha1"`
+	Sha512 string `json:"sha512"`
+}
+
+// "required", "optional" or "unsupported"
+type mrpackEnv struct {
+	Client string `json:"client"`
+	Server string `json:"server"`
+}
+
+// launchers refuse to download files from other hosts
+var mrpackDownloadHosts = map[string]bool{
+	"cdn.modrinth.com":          true,
+	"github.com":                true,
+	"raw.githubusercontent.com": true,
+	"gitlab.com":                true,
+}
+
+func isMrpackDownloadAllowed(modUrl string) bool {
+	parsed, err := url.Parse(modUrl)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "https" && mrpackDownloadHosts[parsed.Host]
+}
+
+func clientSide(mod ModDescription) string {
+	switch {
+	case !mod.AddToClient:
+		return modsindex.SideUnsupported
+	case mod.Optional:
+		return modsindex.SideOptional
+	default:
+		return modsindex.SideRequired
+	}
+}
+
+func serverSide(mod ModDescription) string {
+	if mod.NoServer {
+		return modsindex.SideUnsupported
+	}
+	return modsindex.SideRequired
+}
+
+type modpackSpec struct {
+	Name        string
+	GameVersion string
+	// fabric loader version, omitted from dependencies if empty
+	LoaderVersion string
+	// contents are added to the modpack overrides, ignored if the directory does not exist
+	OverridesPath string
+	// rendered client config files by target, added to the client overrides
+	ClientConfigs map[string][]byte
+}
+
+// Writes a .mrpack with the mods as downloads. Client mods from hosts not allowed
+// in modpacks are bundled into client overrides, such server mods are skipped
+func writeModpack(packPath string, spec modpackSpec, mods []loadedMod) error {
+	index := mrpackIndex{
+		FormatVersion: 1,
+		Game:          "minecraft",
+		Name:          spec.Name,
+		Files:         []mrpackFile{},
+		Dependencies:  map[string]string{"minecraft": spec.GameVersion},
+	}
+	if spec.LoaderVersion != "" {
+		index.Dependencies["fabric-loader"] = spec.LoaderVersion
+	}
+	bundled := map[string]string{}
+	for _, mod := range mods {
+		if !isMrpackDownloadAllowed(mod.Url) {
+			if mod.Description.AddToClient {
+				bundled[path.Join("client-overrides", "mods", mod.Description.File)] = mod.Path
+			} else {
+				fmt.Println("Skipping server mod in modpack, download host is not allowed: " + mod.Description.File)
+			}
+			continue
+		}
+		hashes, err := hashFile(mod.Path)
+		if err != nil {
+			return err
+		}
+		stat, err := os.Stat(mod.Path)
+		if err != nil {
+			return err
+		}
+		index.Files = append(index.Files, mrpackFile{
+			Path:   path.Join("mods", mod.Description.File),
+			Hashes: mrpackHashes{Sha1: hashes.Sha1, Sha512: hashes.Sha512},
+			Env: mrpackEnv{
+				Client: clientSide(mod.Description),
+				Server: serverSide(mod.Description),
+			},
+			Downloads: []string{mod.Url},
+			FileSize:  stat.Size(),
+		})
+	}
+	if spec.OverridesPath != "" {
+		err := filepath.WalkDir(spec.OverridesPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			relative, err := filepath.Rel(spec.OverridesPath, filePath)
+			if err != nil {
+				return err
+			}
+			bundled[path.Join("overrides", filepath.ToSlash(relative))] = filePath
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	configs := map[string][]byte{}
+	for target, contents := range spec.ClientConfigs {
+		configs[path.Join("client-overrides", filepath.ToSlash(target))] = contents
+	}
+	index.VersionId = modpackVersion(index, bundled, configs)
+
+	tmpPath := packPath + ".tmp"
+	packFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer packFile.Close()
+	writer := zip.NewWriter(packFile)
+	indexJson, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	entry, err := writer.Create("modrinth.index.json")
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(indexJson)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(bundled) {
+		err = addFileToZip(writer, name, bundled[name])
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(configs) {
+		entry, err := writer.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = entry.Write(configs[name])
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	err = packFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, packPath)
+}
+
+// changes whenever the files of the modpack change, so launchers notice updates
+func modpackVersion(index mrpackIndex, bundled map[string]string, configs map[string][]byte) string {
+	hash := sha1.New()
+	fmt.Fprintln(hash, index.Dependencies["minecraft"], index.Dependencies["fabric-loader"])
+	for _, file := range index.Files {
+		fmt.Fprintln(hash, file.Path, file.Hashes.Sha1, file.Env.Client, file.Env.Server)
+	}
+	for _, name := range sortedKeys(bundled) {
+		hashes, err := hashFile(bundled[name])
+		fmt.Fprintln(hash, name, hashes.Sha1, err)
+	}
+	for _, name := range sortedKeys(configs) {
+		fmt.Fprintln(hash, name, sha1.Sum(configs[name]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func addFileToZip(writer *zip.Writer, name string, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entry, err := writer.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
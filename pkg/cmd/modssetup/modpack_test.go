@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWriteModpack(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, content string) string {
+		filePath := path.Join(dir, name)
+		os.MkdirAll(path.Dir(filePath), 0755)
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	mods := []loadedMod{
+		{
+			Description: ModDescription{File: "api.jar", AddToClient: true},
+			Url:         "https://cdn.modrinth.com/data/api.jar",
+			Path:        writeFile("cache/api", "api"),
+		},
+		{
+			Description: ModDescription{File: "auth.jar"},
+			Url:         "https://cdn.modrinth.com/data/auth.jar",
+			Path:        writeFile("cache/auth", "auth"),
+		},
+		{
+			Description: ModDescription{File: "private.jar", AddToClient: true, NoServer: true},
+			Url:         "https://example.com/private.jar",
+			Path:        writeFile("cache/private", "private"),
+		},
+		{
+			Description: ModDescription{File: "private-server.jar"},
+			Url:         "https://example.com/private-server.jar",
+			Path:        writeFile("cache/private-server", "private server"),
+		},
+	}
+	writeFile("overrides/config/mod.json", "{}")
+	spec := modpackSpec{
+		Name:          "test",
+		GameVersion:   "1.21.4",
+		LoaderVersion: "0.16.10",
+		OverridesPath: path.Join(dir, "overrides"),
+		ClientConfigs: map[string][]byte{"config/client.json": []byte(`{"client": true}`)},
+	}
+	packPath := path.Join(dir, "test.mrpack")
+	if err := writeModpack(packPath, spec, mods); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.OpenReader(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entries := map[string]string{}
+	for _, file := range reader.File {
+		entry, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(entry)
+		entry.Close()
+		entries[file.Name] = string(content)
+	}
+	if len(entries) != 4 ||
+		entries["client-overrides/config/client.json"] != `{"client": true}` ||
+		entries["client-overrides/mods/private.jar"] != "private" ||
+		entries["overrides/config/mod.json"] != "{}" {
+		t.Fatalf("Unexpected modpack entries %v", entries)
+	}
+	var index mrpackIndex
+	if err := json.Unmarshal([]byte(entries["modrinth.index.json"]), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Dependencies["minecraft"] != "1.21.4" || index.Dependencies["fabric-loader"] != "0.16.10" {
+		t.Errorf("Unexpected dependencies %v", index.Dependencies)
+	}
+	if len(index.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %+v", index.Files)
+	}
+	apiHashes, _ := hashFile(mods[0].Path)
+	api := index.Files[0]
+	if api.Path != "mods/api.jar" || api.Hashes.Sha1 != apiHashes.Sha1 || api.Hashes.Sha512 != apiHashes.Sha512 ||
+		api.FileSize != 3 || api.Env != (mrpackEnv{Client: "required", Server: "required"}) ||
+		len(api.Downloads) != 1 || api.Downloads[0] != mods[0].Url {
+		t.Errorf("Unexpected api file %+v", api)
+	}
+	if index.Files[1].Env != (mrpackEnv{Client: "unsupported", Server: "required"}) {
+		t.Errorf("Server mod should not be installed on client %+v", index.Files[1])
+	}
+
+	if len(index.VersionId) != 12 {
+		t.Errorf("Unexpected version %s", index.VersionId)
+	}
+	changed := index
+	changed.Dependencies = map[string]string{"minecraft": "1.21.5"}
+	if modpackVersion(changed, nil, nil) == modpackVersion(index, nil, nil) {
+		t.Error("Version should change with the game version")
+	}
+}
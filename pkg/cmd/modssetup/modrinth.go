@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultModrinthApi = "https://api.modrinth.com/v2"
+
+// modrinth asks api users to identify themselves
+const modrinthUserAgent = "imobulus/subchat-mc-server modssetup"
+
+type ModrinthFile struct {
+	Url      string            `json:"url"`
+	Filename string            `json:"filename"`
+	Primary  bool              `json:"primary"`
+	Size     int64             `json:"size"`
+	Hashes   map[string]string `json:"hashes"`
+}
+
+type ModrinthVersion struct {
+	Id            string         `json:"id"`
+	ProjectId     string         `json:"project_id"`
+	VersionNumber string         `json:"version_number"`
+	VersionType   string         `json:"version_type"`
+	GameVersions  []string       `json:"game_versions"`
+	Loaders       []string       `json:"loaders"`
+	DatePublished time.Time      `json:"date_published"`
+	Files         []ModrinthFile `json:"files"`
+}
+
+// primary file is the mod jar, others are sources and such
+func (v *ModrinthVersion) primaryFile() *ModrinthFile {
+	for i := range v.Files {
+		if v.Files[i].Primary {
+			return &v.Files[i]
+		}
+	}
+	if len(v.Files) > 0 {
+		return &v.Files[0]
+	}
+	return nil
+}
+
+type ModrinthClient struct {
+	apiUrl     string
+	httpClient *http.Client
+}
+
+func NewModrinthClient(apiUrl string) *ModrinthClient {
+	return &ModrinthClient{
+		apiUrl:     apiUrl,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (c *ModrinthClient) get(path string, query url.Values, result interface{}) error {
+	reqUrl := c.apiUrl + path
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", reqUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", modrinthUserAgent)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("modrinth request %s failed: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// versions of the project compatible with the game version and loader, newest first
+func (c *ModrinthClient) ListVersions(slug string, gameVersion string, loader string) ([]ModrinthVersion, error) {
+	query := url.Values{}
+	if loader != "" {
+		query.Set("loaders", fmt.Sprintf("[%q]", loader))
+	}
+	if gameVersion != "" {
+		query.Set("game_versions", fmt.Sprintf("[%q]", gameVersion))
+	}
+	var versions []ModrinthVersion
+	err := c.get("/project/"+url.PathEscape(slug)+"/version", query, &versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Pinned version is looked up by version number. Otherwise the newest release is chosen,
+// or the newest version if the project has no releases
+func selectVersion(versions []ModrinthVersion, pin string) (*ModrinthVersion, error) {
+	var newest, newestRelease *ModrinthVersion
+	for i := range versions {
+		version := &versions[i]
+		if version.primaryFile() == nil {
+			continue
+		}
+		if pin != "" {
+			if version.VersionNumber == pin {
+				return version, nil
+			}
+			continue
+		}
+		if newest == nil || version.DatePublished.After(newest.DatePublished) {
+			newest = version
+		}
+		if version.VersionType == "release" &&
+			(newestRelease == nil || version.DatePublished.After(newestRelease.DatePublished)) {
+			newestRelease = version
+		}
+	}
+	if pin != "" {
+		return nil, fmt.Errorf("version %s not found", pin)
+	}
+	if newestRelease != nil {
+		return newestRelease, nil
+	}
+	if newest != nil {
+		return newest, nil
+	}
+	return nil, fmt.Errorf("no compatible versions")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockModrinth struct {
+	// by slug
+	versions map[string][]ModrinthVersion
+	requests int
+}
+
+func (m *mockModrinth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.requests++
+	if r.Header.Get("User-Agent") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var slug string
+	for s := range m.versions {
+		if r.URL.Path == "/project/"+s+"/version" {
+			slug = s
+		}
+	}
+	if slug == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var loaders, gameVersions []string
+	json.Unmarshal([]byte(r.URL.Query().Get("loaders")), &loaders)
+	json.Unmarshal([]byte(r.URL.Query().Get("game_versions")), &gameVersions)
+	result := []ModrinthVersion{}
+	for _, version := range m.versions[slug] {
+		if contains(version.Loaders, loaders) && contains(version.GameVersions, gameVersions) {
+			result = append(result, version)
+		}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func contains(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, v := range values {
+			found = found || v == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func mockVersion(id string, number string, versionType string, gameVersion string, published time.Time) ModrinthVersion {
+	return ModrinthVersion{
+		Id:            id,
+		ProjectId:     "P7dR8mSH",
+		VersionNumber: number,
+		VersionType:   versionType,
+		GameVersions:  []string{gameVersion},
+		Loaders:       []string{"fabric"},
+		DatePublished: published,
+		Files: []ModrinthFile{
+			{Url: "https://cdn.example/" + id + "-sources.jar", Filename: id + "-sources.jar"},
+			{Url: "https://cdn.example/" + id + ".jar", Filename: id + ".jar", Primary: true, Size: 10,
+				Hashes: map[string]string{"sha1": id + "-sha1", "sha512": id + "-sha512"}},
+		},
+	}
+}
+
+func newMockModrinth(t *testing.T) (*mockModrinth, *ModrinthClient) {
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock := &mockModrinth{versions: map[string][]ModrinthVersion{
+		"fabric-api": {
+			mockVersion("v3", "0.117.0-beta", "beta", "1.21.4", day.Add(72*time.Hour)),
+			mockVersion("v2", "0.116.1", "release", "1.21.4", day.Add(48*time.Hour)),
+			mockVersion("v1", "0.116.0", "release", "1.21.4", day),
+			mockVersion("v0", "0.120.0", "release", "1.21.5", day.Add(96*time.Hour)),
+		},
+	}}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	return mock, NewModrinthClient(server.URL)
+}
+
+func TestResolveMod(t *testing.T) {
+	mock, client := newMockModrinth(t)
+	defaults := modTarget{GameVersion: "1.21.4", Loader: "fabric"}
+	lock := Lockfile{}
+	mod := ModDescription{Modrinth: "fabric-api", File: "fabric-api.jar"}
+
+	modUrl, err := resolveMod(mod, defaults, lock, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newest release, not the beta or other game version
+	if modUrl != "https://cdn.example/v2.jar" {
+		t.Fatalf("Wrong url %s", modUrl)
+	}
+	locked := lock["fabric-api.jar"]
+	if locked.VersionNumber != "0.116.1" || locked.Sha512 != "v2-sha512" || locked.GameVersion != "1.21.4" {
+		t.Fatalf("Wrong locked mod %+v", locked)
+	}
+
+	// locked mods are not resolved again
+	_, err = resolveMod(mod, defaults, lock, client)
+	if err != nil || mock.requests != 1 {
+		t.Fatalf("Locked mod should not be resolved: %d requests, %v", mock.requests, err)
+	}
+
+	mod.Version = "0.116.0"
+	modUrl, err = resolveMod(mod, defaults, lock, client)
+	if err != nil || modUrl != "https://cdn.example/v1.jar" {
+		t.Fatalf("Pinned version should be resolved: %s %v", modUrl, err)
+	}
+
+	mod.Version = "0.1"
+	_, err = resolveMod(mod, defaults, lock, client)
+	if err == nil {
+		t.Fatal("Missing pinned version should fail")
+	}
+
+	_, err = resolveMod(ModDescription{Modrinth: "missing", File: "missing.jar"}, defaults, lock, client)
+	if err == nil {
+		t.Fatal("Missing project should fail")
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	_, client := newMockModrinth(t)
+	defaults := modTarget{GameVersion: "1.21.4", Loader: "fabric"}
+	mods := []ModDescription{
+		{Modrinth: "fabric-api", File: "fabric-api.jar"},
+		{Modrinth: "fabric-api", File: "pinned.jar", Version: "0.116.0"},
+		{Url: "https://cdn.example/raw.jar", File: "raw.jar"},
+	}
+	lock := Lockfile{}
+	for _, mod := range mods[:2] {
+		_, err := resolveMod(mod, defaults, lock, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	updates, newLocks, err := checkUpdates(mods, defaults, lock, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || !updates[0].Pinned || updates[0].Current != "0.116.0" || updates[0].Latest != "0.116.1" {
+		t.Fatalf("Wrong updates %+v", updates)
+	}
+	if len(newLocks) != 0 {
+		t.Fatalf("Pinned mod should not be bumped: %+v", newLocks)
+	}
+
+	// the lock is outdated after a new release
+	lock["fabric-api.jar"] = lockVersion(mods[0], defaults, &ModrinthVersion{Id: "v1", VersionNumber: "0.116.0", Files: []ModrinthFile{{Primary: true}}})
+	updates, newLocks, err = checkUpdates(mods, defaults, lock, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || newLocks["fabric-api.jar"].VersionId != "v2" {
+		t.Fatalf("Wrong updates %+v %+v", updates, newLocks)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/imobulus/subchat-mc-server/src/modsindex"
+)
+
+const modrinthProjectUrl = "https://modrinth.com/mod/"
+
+// project page of mods from modrinth, including the ones downloaded by url from its cdn
+func modrinthUrl(mod loadedMod) string {
+	if mod.Description.Modrinth != "" {
+		return modrinthProjectUrl + mod.Description.Modrinth
+	}
+	parsed, err := url.Parse(mod.Url)
+	if err != nil || parsed.Host != "cdn.modrinth.com" {
+		return ""
+	}
+	// /data/<project id>/versions/<version id>/<file>
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "data" {
+		return ""
+	}
+	return modrinthProjectUrl + parts[1]
+}
+
+func modVersion(mod loadedMod, lock Lockfile) string {
+	if mod.Fabric != nil && mod.Fabric.Version != "" {
+		return mod.Fabric.Version
+	}
+	if locked, ok := lock[mod.Description.File]; ok && mod.Description.Modrinth != "" {
+		return locked.VersionNumber
+	}
+	return mod.Description.Version
+}
+
+// Index of the client directory served as the mods download page. Client mods
+// are downloadable from the mods directory, server ones are only listed
+func buildModsIndex(spec modpackSpec, archive string, modpack string, mods []loadedMod, lock Lockfile) (*modsindex.Index, error) {
+	index := &modsindex.Index{
+		GameVersion:   spec.GameVersion,
+		LoaderVersion: spec.LoaderVersion,
+		Archive:       archive,
+		Modpack:       modpack,
+		Mods:          []modsindex.Mod{},
+	}
+	for _, mod := range mods {
+		hashes, err := hashFile(mod.Path)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Stat(mod.Path)
+		if err != nil {
+			return nil, err
+		}
+		entry := modsindex.Mod{
+			File:        mod.Description.File,
+			Version:     modVersion(mod, lock),
+			Client:      clientSide(mod.Description),
+			Server:      serverSide(mod.Description),
+			Size:        stat.Size(),
+			Sha1:        hashes.Sha1,
+			Sha512:      hashes.Sha512,
+			ModrinthUrl: modrinthUrl(mod),
+		}
+		if mod.Fabric != nil {
+			entry.Name = mod.Fabric.Name
+		}
+		if mod.Description.AddToClient {
+			entry.Path = path.Join("mods", url.PathEscape(mod.Description.File))
+		}
+		index.Mods = append(index.Mods, entry)
+	}
+	return index, nil
+}
@@ -1,171 +1,357 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"sort"
+	"sync"
+
+	"github.com/imobulus/subchat-mc-server/src/modconfigs"
+	"github.com/imobulus/subchat-mc-server/src/modsindex"
 )
 
+// Mod is downloaded either from Url or from the modrinth project with the slug Modrinth.
+// Modrinth mods are resolved into the lockfile, Version pins the version number.
 type ModDescription struct {
-	Url         string `json:"url"`
+	Url         string `json:"url,omitempty"`
+	Modrinth    string `json:"modrinth,omitempty"`
+	GameVersion string `json:"game_version,omitempty"`
+	Loader      string `json:"loader,omitempty"`
+	Version     string `json:"version,omitempty"`
+	// expected hex encoded hashes of the file, checked on download and on cache reuse.
+	// Url mods must have one, modrinth mods get them from the lockfile
+	Sha512      string `json:"sha512,omitempty"`
+	Sha1        string `json:"sha1,omitempty"`
 	File        string `json:"file"`
-	AddToClient bool   `json:"add_to_client"`
-	NoServer    bool   `json:"no_server"`
+	AddToClient bool   `json:"add_to_client,omitempty"`
+	NoServer    bool   `json:"no_server,omitempty"`
+	// client mod players may go without
+	Optional bool `json:"optional,omitempty"`
 }
 
-func runCmd(cmdArgs ...string) {
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		panic(err)
+// game version and loader the mod must support
+type modTarget struct {
+	GameVersion string
+	Loader      string
+}
+
+func (mod ModDescription) target(defaults modTarget) modTarget {
+	target := defaults
+	if mod.GameVersion != "" {
+		target.GameVersion = mod.GameVersion
+	}
+	if mod.Loader != "" {
+		target.Loader = mod.Loader
 	}
+	return target
 }
 
-func hashFile(filePath string) ([]byte, error) {
-	content, err := os.ReadFile(filePath)
+func readManifest(path string) ([]ModDescription, error) {
+	modsContents, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	sha1Hash := sha1.New()
-	_, err = sha1Hash.Write(content)
+	var mods []ModDescription
+	err = json.Unmarshal(modsContents, &mods)
 	if err != nil {
 		return nil, err
 	}
-	return sha1Hash.Sum(nil), nil
+	for _, mod := range mods {
+		if (mod.Url == "") == (mod.Modrinth == "") {
+			return nil, fmt.Errorf("mod %s must have either url or modrinth", mod.File)
+		}
+	}
+	return mods, nil
 }
 
-func downloadMod(modUrl string, cachePath string) (string, error) {
-	fmt.Println("Downloading mod: " + modUrl)
-	// download file to cachePath/tmpmod
-	req, err := http.NewRequest("GET", modUrl, nil)
+// Returns download url of the mod. Modrinth mods missing from the lockfile
+// or changed in the manifest are resolved and locked
+func resolveMod(mod ModDescription, defaults modTarget, lock Lockfile, client *ModrinthClient) (string, error) {
+	if mod.Modrinth == "" {
+		return mod.Url, nil
+	}
+	target := mod.target(defaults)
+	if locked, ok := lock[mod.File]; ok && locked.matches(mod, target) {
+		return locked.Url, nil
+	}
+	fmt.Printf("Resolving mod %s for %s %s\n", mod.Modrinth, target.Loader, target.GameVersion)
+	versions, err := client.ListVersions(mod.Modrinth, target.GameVersion, target.Loader)
 	if err != nil {
 		return "", err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	version, err := selectVersion(versions, mod.Version)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("cannot resolve mod %s: %w", mod.Modrinth, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("failed to download mod: %s", resp.Status)
+	lock[mod.File] = lockVersion(mod, target, version)
+	return lock[mod.File].Url, nil
+}
+
+type commonFlags struct {
+	modsJson    *string
+	lockfile    *string
+	modrinthApi *string
+	gameVersion *string
+	loader      *string
+}
+
+func addCommonFlags(flags *flag.FlagSet) commonFlags {
+	return commonFlags{
+		modsJson:    flags.String("mods-json", "mods.json", "Path to mods.json file"),
+		lockfile:    flags.String("lockfile", "mods.lock.json", "Path to lockfile with resolved modrinth mods"),
+		modrinthApi: flags.String("modrinth-api", defaultModrinthApi, "Modrinth api url"),
+		gameVersion: flags.String("game-version", "1.21.4", "Default minecraft version of modrinth mods"),
+		loader:      flags.String("loader", "fabric", "Default loader of modrinth mods"),
 	}
-	tmpPath := path.Join(cachePath, "tmpmod")
-	tmpFile, err := os.Create(tmpPath)
+}
+
+func (f commonFlags) defaults() modTarget {
+	return modTarget{GameVersion: *f.gameVersion, Loader: *f.loader}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "update":
+			runUpdate(os.Args[2:])
+			return
+		case "pin":
+			runPin(os.Args[2:])
+			return
+		}
+	}
+	runSetup(os.Args[1:])
+}
+
+// what happened to each mod during setup
+type setupReport struct {
+	Downloaded []string
+	Cached     []string
+	// by mod file
+	Failed map[string]error
+}
+
+func (r setupReport) print() {
+	fmt.Printf("Mods: %d downloaded, %d cached, %d failed\n", len(r.Downloaded), len(r.Cached), len(r.Failed))
+	failed := make([]string, 0, len(r.Failed))
+	for file := range r.Failed {
+		failed = append(failed, file)
+	}
+	sort.Strings(failed)
+	for _, file := range failed {
+		fmt.Printf("  %s: %s\n", file, r.Failed[file].Error())
+	}
+}
+
+// Loads resolved mods into the cache with a pool of jobs workers.
+// Failed mods are reported and left out of the result. Mods without expected hashes fail
+// unless allowUnverified is set
+func loadMods(
+	mods []ModDescription, urls []string, lock Lockfile, cache *modCache, jobs int, allowUnverified bool,
+) ([]loadedMod, setupReport) {
+	results := make([]*loadedMod, len(mods))
+	outcomes := make([]loadOutcome, len(mods))
+	errs := make([]error, len(mods))
+	indices := make(chan int)
+	wg := sync.WaitGroup{}
+	for range max(jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i], outcomes[i], errs[i] = loadMod(mods[i], urls[i], expectedHashes(mods[i], lock), cache, allowUnverified)
+			}
+		}()
+	}
+	for i := range mods {
+		if urls[i] != "" {
+			indices <- i
+		}
+	}
+	close(indices)
+	wg.Wait()
+	loaded := make([]loadedMod, 0, len(mods))
+	report := setupReport{Failed: map[string]error{}}
+	for i, mod := range mods {
+		switch {
+		case errs[i] != nil:
+			report.Failed[mod.File] = errs[i]
+		case results[i] == nil:
+			// not resolved
+		case outcomes[i] == loadDownloaded:
+			report.Downloaded = append(report.Downloaded, mod.File)
+		default:
+			report.Cached = append(report.Cached, mod.File)
+		}
+		if results[i] != nil {
+			loaded = append(loaded, *results[i])
+		}
+	}
+	return loaded, report
+}
+
+func loadMod(
+	mod ModDescription, modUrl string, expected fileHashes, cache *modCache, allowUnverified bool,
+) (*loadedMod, loadOutcome, error) {
+	if expected.isEmpty() {
+		if !allowUnverified {
+			return nil, loadDownloaded, ErrorMissingHash{File: mod.File}
+		}
+		fmt.Println("WARNING: no expected hash for mod " + mod.File + ", its integrity is not verified")
+	}
+	modHashedFilePath, outcome, err := cache.load(modUrl, expected)
 	if err != nil {
-		return "", err
+		return nil, outcome, err
 	}
-	defer tmpFile.Close()
-	_, err = io.Copy(tmpFile, resp.Body)
+	fabric, err := readFabricModFile(modHashedFilePath)
 	if err != nil {
-		return "", err
+		return nil, outcome, fmt.Errorf("cannot read mod: %w", err)
 	}
-	// hash file
-	hash, err := hashFile(tmpPath)
+	return &loadedMod{
+		Description: mod,
+		Url:         modUrl,
+		Path:        modHashedFilePath,
+		Fabric:      fabric,
+	}, outcome, nil
+}
+
+// Checks all templates, server ones are rendered by the overseer on startup.
+// Returns rendered client configs, none if there is no manifest
+func renderClientConfigs(manifestPath string) (map[string][]byte, error) {
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		fmt.Println("No mod configs manifest: " + manifestPath)
+		return nil, nil
+	}
+	manifest, err := modconfigs.ReadManifest(manifestPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	hashString := hex.EncodeToString(hash)
-	// move file to cachePath/hash
-	hashPath := path.Join(cachePath, hashString)
-	err = os.Rename(tmpPath, hashPath)
+	err = manifest.Check()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return hashString, nil
+	return manifest.RenderClient()
 }
 
-func loadHashedFile(modUrl string, urlToHash map[string]string, cachePath string) (string, error) {
-	doDownload := false
-	hash, ok := urlToHash[modUrl]
-	if !ok {
-		doDownload = true
-	} else {
-		if _, err := os.Stat(path.Join(cachePath, hash)); err != nil {
-			doDownload = true
-		}
+func runSetup(args []string) {
+	flags := flag.NewFlagSet("modssetup", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	modsPath := flags.String("mods-dir", "mods", "Path to mods directory")
+	clientModsPath := flags.String("client-dir", "clientmods", "Path to client directory")
+	cachePath := flags.String("cache-dir", "cache", "Path to cache directory")
+	jobs := flags.Int("jobs", 4, "Number of concurrent downloads")
+	allowUnverified := flags.Bool("allow-unverified", false, "Install mods without expected hashes instead of failing")
+	loaderVersion := flags.String("loader-version", "", "Fabric loader version to check mods against")
+	javaVersion := flags.String("java-version", "", "Java version to check mods against")
+	modpackName := flags.String("modpack-name", "subchat", "Name of the modpack written to the client directory")
+	modpackOverrides := flags.String("modpack-overrides", "modpack-overrides", "Directory with files added to the modpack overrides")
+	clientArchive := flags.String("client-archive", "mods.zip", "Name of the client mods archive listed on the download page")
+	modConfigsPath := flags.String("mod-configs", "mod-configs/mod-configs.json", "Path to manifest of mod config templates")
+	flags.Parse(args)
+	clientModsModsPath := path.Join(*clientModsPath, "mods")
+	mods, err := readManifest(*common.modsJson)
+	if err != nil {
+		panic(err)
+	}
+	lock, err := readLockfile(*common.lockfile)
+	if err != nil {
+		panic(err)
+	}
+	clientConfigs, err := renderClientConfigs(*modConfigsPath)
+	if err != nil {
+		panic(err)
 	}
-	var err error
-	if doDownload {
-		hash, err = downloadMod(modUrl, cachePath)
+	modrinthClient := NewModrinthClient(*common.modrinthApi)
+	cache := readModCache(*cachePath)
+	// resolving is sequential as it updates the lockfile
+	urls := make([]string, len(mods))
+	resolveErrors := map[string]error{}
+	for i, mod := range mods {
+		urls[i], err = resolveMod(mod, common.defaults(), lock, modrinthClient)
 		if err != nil {
-			return "", err
+			resolveErrors[mod.File] = err
 		}
-		urlToHash[modUrl] = hash
-	} else {
-		fmt.Println("Using cached mod: " + path.Join(cachePath, hash))
 	}
-	return path.Join(cachePath, hash), nil
-}
+	loadedMods, report := loadMods(mods, urls, lock, cache, *jobs, *allowUnverified)
+	for file, err := range resolveErrors {
+		report.Failed[file] = err
+	}
 
-func main() {
-	modsJson := flag.String("mods-json", "mods.json", "Path to mods.json file")
-	modsPath := flag.String("mods-dir", "mods", "Path to mods directory")
-	clientModsPath := flag.String("client-dir", "clientmods", "Path to client directory")
-	cachePath := flag.String("cache-dir", "cache", "Path to cache directory")
-	flag.Parse()
-	clientModsModsPath := path.Join(*clientModsPath, "mods")
-	modsContents, err := os.ReadFile(*modsJson)
+	cache.retainUrls(urls)
+	err = cache.save()
 	if err != nil {
 		panic(err)
 	}
-	var mods []ModDescription
-	err = json.Unmarshal(modsContents, &mods)
+	removed, err := cache.collectGarbage()
 	if err != nil {
 		panic(err)
 	}
-	urlToHashMap := make(map[string]string)
-	mapPath := path.Join(*cachePath, "urlToHash.json")
-	func() {
-		success := false
-		defer func() {
-			if !success {
-				os.Remove(mapPath)
-				urlToHashMap = make(map[string]string)
-			}
-		}()
-		if _, err := os.Stat(mapPath); err == nil {
-			mapContents, err := os.ReadFile(mapPath)
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
-			err = json.Unmarshal(mapContents, &urlToHashMap)
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
-		}
-	}()
-	for _, mod := range mods {
-		modHashedFilePath, err := loadHashedFile(mod.Url, urlToHashMap, *cachePath)
-		if err != nil {
-			panic(err)
+	for _, name := range removed {
+		fmt.Println("Removed unused cached file: " + name)
+	}
+	report.print()
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+
+	problems := checkCompatibility(loadedMods, platformVersions{
+		Minecraft: *common.gameVersion,
+		Loader:    *loaderVersion,
+		Java:      *javaVersion,
+	})
+	if len(problems) > 0 {
+		fmt.Println("Mods are not compatible:")
+		for _, problem := range problems {
+			fmt.Println("  " + problem.String())
 		}
-		if !mod.NoServer {
-			modPath := path.Join(*modsPath, mod.File)
+		fmt.Println("Add missing mods to the manifest or change the versions")
+		os.Exit(1)
+	}
+	for _, mod := range loadedMods {
+		if !mod.Description.NoServer {
+			modPath := path.Join(*modsPath, mod.Description.File)
 			fmt.Println("Copying mod to: " + modPath)
-			runCmd("cp", modHashedFilePath, modPath)
+			err = copyFile(mod.Path, modPath)
+			if err != nil {
+				panic(err)
+			}
 		}
-		if mod.AddToClient {
-			clientModPath := path.Join(clientModsModsPath, mod.File)
+		if mod.Description.AddToClient {
+			clientModPath := path.Join(clientModsModsPath, mod.Description.File)
 			fmt.Println("Copying mod to: " + clientModPath)
-			runCmd("cp", modHashedFilePath, clientModPath)
+			err = copyFile(mod.Path, clientModPath)
+			if err != nil {
+				panic(err)
+			}
 		}
 	}
-	urlToHashJson, err := json.Marshal(urlToHashMap)
+	spec := modpackSpec{
+		Name:          *modpackName,
+		GameVersion:   *common.gameVersion,
+		LoaderVersion: *loaderVersion,
+		OverridesPath: *modpackOverrides,
+		ClientConfigs: clientConfigs,
+	}
+	modpackFile := *modpackName + ".mrpack"
+	modpackPath := path.Join(*clientModsPath, modpackFile)
+	fmt.Println("Writing modpack to: " + modpackPath)
+	err = writeModpack(modpackPath, spec, loadedMods)
+	if err != nil {
+		panic(err)
+	}
+	index, err := buildModsIndex(spec, *clientArchive, modpackFile, loadedMods, lock)
+	if err != nil {
+		panic(err)
+	}
+	err = modsindex.Write(path.Join(*clientModsPath, modsindex.IndexFile), index)
 	if err != nil {
 		panic(err)
 	}
-	err = os.WriteFile(mapPath, urlToHashJson, 0644)
+	lock.prune(mods)
+	err = writeLockfile(*common.lockfile, lock)
 	if err != nil {
 		panic(err)
 	}
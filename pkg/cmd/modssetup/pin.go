@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Downloads url mods without sha512 and sets it in the manifest, returns files of pinned mods.
+// The hash pins the file as it is served now, so check the urls before pinning.
+// Modrinth mods are skipped, their hashes are locked from the modrinth api
+func pinHashes(mods []ModDescription, cache *modCache) ([]string, error) {
+	pinned := []string{}
+	for i, mod := range mods {
+		if mod.Url == "" || mod.Sha512 != "" {
+			continue
+		}
+		cachedPath, _, err := cache.load(mod.Url, fileHashes{Sha1: mod.Sha1})
+		if err != nil {
+			return pinned, fmt.Errorf("cannot download mod %s: %w", mod.File, err)
+		}
+		hashes, err := hashFile(cachedPath)
+		if err != nil {
+			return pinned, err
+		}
+		mods[i].Sha512 = hashes.Sha512
+		pinned = append(pinned, mod.File)
+	}
+	return pinned, nil
+}
+
+func writeManifest(path string, mods []ModDescription) error {
+	content, err := json.MarshalIndent(mods, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// modssetup pin writes sha512 of url mods which have none to the manifest
+func runPin(args []string) {
+	flags := flag.NewFlagSet("modssetup pin", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	cachePath := flags.String("cache-dir", "cache", "Path to cache directory")
+	flags.Parse(args)
+	mods, err := readManifest(*common.modsJson)
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(*cachePath, 0755)
+	if err != nil {
+		panic(err)
+	}
+	cache := readModCache(*cachePath)
+	pinned, err := pinHashes(mods, cache)
+	if err != nil {
+		panic(err)
+	}
+	if len(pinned) == 0 {
+		fmt.Println("All url mods have sha512")
+		return
+	}
+	err = cache.save()
+	if err != nil {
+		panic(err)
+	}
+	err = writeManifest(*common.modsJson, mods)
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range pinned {
+		fmt.Println("Pinned " + file)
+	}
+	fmt.Printf("Pinned %d mods in %s\n", len(pinned), *common.modsJson)
+}
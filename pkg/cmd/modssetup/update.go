@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+type modUpdate struct {
+	File    string
+	Slug    string
+	Current string
+	Latest  string
+	// pinned mods are only reported
+	Pinned bool
+}
+
+// newest compatible versions of modrinth mods which differ from the locked ones
+func checkUpdates(mods []ModDescription, defaults modTarget, lock Lockfile, client *ModrinthClient) ([]modUpdate, map[string]LockedMod, error) {
+	updates := []modUpdate{}
+	newLocks := map[string]LockedMod{}
+	for _, mod := range mods {
+		if mod.Modrinth == "" {
+			continue
+		}
+		target := mod.target(defaults)
+		versions, err := client.ListVersions(mod.Modrinth, target.GameVersion, target.Loader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot list versions of %s: %w", mod.Modrinth, err)
+		}
+		latest, err := selectVersion(versions, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot resolve mod %s: %w", mod.Modrinth, err)
+		}
+		locked, ok := lock[mod.File]
+		if ok && locked.matches(mod, target) && locked.VersionId == latest.Id {
+			continue
+		}
+		update := modUpdate{
+			File:   mod.File,
+			Slug:   mod.Modrinth,
+			Latest: latest.VersionNumber,
+			Pinned: mod.Version != "" && mod.Version != latest.VersionNumber,
+		}
+		if ok {
+			update.Current = locked.VersionNumber
+		}
+		updates = append(updates, update)
+		if !update.Pinned {
+			newLocks[mod.File] = lockVersion(mod, target, latest)
+		}
+	}
+	return updates, newLocks, nil
+}
+
+// modssetup update [-apply] reports mods with newer compatible versions and bumps them in the lockfile
+func runUpdate(args []string) {
+	flags := flag.NewFlagSet("modssetup update", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	apply := flags.Bool("apply", false, "Write newer versions to the lockfile")
+	flags.Parse(args)
+	mods, err := readManifest(*common.modsJson)
+	if err != nil {
+		panic(err)
+	}
+	lock, err := readLockfile(*common.lockfile)
+	if err != nil {
+		panic(err)
+	}
+	client := NewModrinthClient(*common.modrinthApi)
+	updates, newLocks, err := checkUpdates(mods, common.defaults(), lock, client)
+	if err != nil {
+		panic(err)
+	}
+	if len(updates) == 0 {
+		fmt.Println("All mods are up to date")
+		return
+	}
+	for _, update := range updates {
+		current := update.Current
+		if current == "" {
+			current = "not locked"
+		}
+		line := fmt.Sprintf("%s (%s): %s -> %s", update.File, update.Slug, current, update.Latest)
+		if update.Pinned {
+			line += " (pinned, not updated)"
+		}
+		fmt.Println(line)
+	}
+	if !*apply {
+		fmt.Println("Run with -apply to update the lockfile")
+		return
+	}
+	for file, locked := range newLocks {
+		lock[file] = locked
+	}
+	err = writeLockfile(*common.lockfile, lock)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Updated %d mods in %s\n", len(newLocks), *common.lockfile)
+}
@@ -9,6 +9,7 @@ import (
 	"path"
 	"syscall"
 
+	"github.com/imobulus/subchat-mc-server/src/mojang"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot"
@@ -25,6 +26,7 @@ type Config struct {
 	AuthDbConfig    authdb.AuthDbExecutorConfig         `yaml:"auth db"`
 	Perms           permsengine.ServerPermsEngineConfig `yaml:"perms"`
 	SqliteLocation  string                              `yaml:"sqlite location"`
+	Mojang          mojang.ClientConfig                 `yaml:"mojang"`
 }
 
 var DefaultConfig = Config{
@@ -33,6 +35,7 @@ var DefaultConfig = Config{
 	AuthDbConfig:    authdb.DefaultAuthDbExecutorConfig,
 	Perms:           permsengine.DefaultServerPermsEngineConfig,
 	SqliteLocation:  "/sqlite/auth.db",
+	Mojang:          mojang.DefaultClientConfig,
 }
 
 func main() {
@@ -54,6 +57,8 @@ func main() {
 	}()
 
 	configPath := flag.String("config", "config.yaml", "path to config file")
+	migrateTo := flag.Int("migrate-to", -1, "migrate the database up or down to the schema version and exit")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "show pending migrations, check them in a rolled back transaction and exit")
 	flag.Parse()
 
 	contents, err := os.ReadFile(*configPath)
@@ -88,16 +93,43 @@ func main() {
 		logger.Fatal("Failed to open db", zap.Error(err))
 	}
 
+	if config.AuthDbConfig.MigrationBackupDir == "" {
+		config.AuthDbConfig.MigrationBackupDir = path.Join(path.Dir(config.SqliteLocation), "backups")
+	}
+	if *migrateTo >= 0 || *migrateDryRun {
+		options := authdb.MigrateOptions{DryRun: *migrateDryRun, BackupDir: config.AuthDbConfig.MigrationBackupDir}
+		if *migrateTo >= 0 {
+			options.Target = migrateTo
+		}
+		steps, err := authdb.Migrate(db, options, logger)
+		if err != nil {
+			logger.Fatal("Failed to migrate db", zap.Error(err))
+		}
+		for _, step := range steps {
+			logger.Info("migration", zap.Stringer("step", step), zap.Bool("dry run", *migrateDryRun))
+		}
+		if len(steps) == 0 {
+			logger.Info("no migrations to apply")
+		}
+		return
+	}
+
 	dbExec, err := authdb.NewAuthDbExecutor(db, config.AuthDbConfig, logger)
 	if err != nil {
 		logger.Fatal("Failed to init db", zap.Error(err))
 	}
 
-	permsEngine, err := permsengine.NewServerPermsEngine(config.Perms, dbExec, tgSecret.AccessPassword)
+	permsEngine, err := permsengine.NewServerPermsEngine(config.Perms, dbExec, tgSecret.AccessPassword, logger)
 	if err != nil {
 		logger.Fatal("Failed to create perms engine", zap.Error(err))
 	}
-	tgBot, err := tgbot.NewTgBot(config.TgBot, tgSecret, permsEngine, logger, ctx)
+	profileCache, err := mojang.NewGormProfileCache(db)
+	if err != nil {
+		logger.Fatal("Failed to init mojang profile cache", zap.Error(err))
+	}
+	mojangClient := mojang.NewClient(config.Mojang, nil, mojang.NewLayeredProfileCache(profileCache))
+
+	tgBot, err := tgbot.NewTgBot(config.TgBot, tgSecret, permsEngine, mojangClient, logger, ctx)
 	if err != nil {
 		logger.Fatal("Failed to create tg bot", zap.Error(err))
 	}
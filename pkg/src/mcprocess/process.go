@@ -1,6 +1,7 @@
 package mcprocess
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -28,36 +29,66 @@ var DefaultMcProcessConfig = McProcessConfig{
 }
 
 type McProcessHolder struct {
-	config  McProcessConfig
-	command *exec.Cmd
+	config McProcessConfig
 
-	commandsPipe io.Writer
+	outputListeners []func(line string)
 
-	cmdMu   *sync.Mutex
-	cmdDone chan struct{}
-	logger  *zap.Logger
-	ctx     context.Context
-	cancel  context.CancelFunc
+	// state of the current run, replaced on restart under both mutexes
+	command      *exec.Cmd
+	commandsPipe io.Writer
+	cmdDone      chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	cmdMu     *sync.Mutex
+	stateMu   *sync.Mutex
+	parentCtx context.Context
+	logger    *zap.Logger
 }
 
 func NewMcProcessHolder(config McProcessConfig, logger *zap.Logger) *McProcessHolder {
 	return &McProcessHolder{
 		config:  config,
 		cmdMu:   &sync.Mutex{},
+		stateMu: &sync.Mutex{},
 		cmdDone: make(chan struct{}),
 		logger:  logger,
 	}
 }
 
 func (m *McProcessHolder) Start(ctx context.Context) error {
-	m.ctx, m.cancel = context.WithCancel(ctx)
 	if m.command != nil {
 		return fmt.Errorf("process already started")
 	}
+	m.parentCtx = ctx
+	return m.start()
+}
+
+// Gracefully stops the server and waits for it to exit. It may be started again with Restart
+func (m *McProcessHolder) Stop() {
+	m.stateMu.Lock()
+	cancel, done := m.cancel, m.cmdDone
+	m.stateMu.Unlock()
+	cancel()
+	<-done
+}
+
+// Starts the stopped server again, Done returns the channel of the new run
+func (m *McProcessHolder) Restart() error {
+	select {
+	case <-m.Done():
+	default:
+		return fmt.Errorf("process is running")
+	}
+	if m.parentCtx.Err() != nil {
+		return m.parentCtx.Err()
+	}
 	return m.start()
 }
 
 func (m *McProcessHolder) Done() <-chan struct{} {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
 	return m.cmdDone
 }
 
@@ -74,36 +105,73 @@ func (m *McProcessHolder) start() error {
 
 	pipeOut, pipeIn := io.Pipe()
 	cmd.Stdin = pipeOut
-	m.commandsPipe = pipeIn
-	cmd.Stdout = os.Stdout
+	outputReader, outputWriter := io.Pipe()
+	cmd.Stdout = io.MultiWriter(os.Stdout, outputWriter)
 	cmd.Stderr = os.Stderr
 
 	err = cmd.Start()
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithCancel(m.parentCtx)
+	done := make(chan struct{})
+	m.cmdMu.Lock()
+	m.stateMu.Lock()
 	m.command = cmd
-	go m.waitEnd()
-	go m.watchContext()
+	m.commandsPipe = pipeIn
+	m.cmdDone = done
+	m.ctx, m.cancel = ctx, cancel
+	m.stateMu.Unlock()
+	m.cmdMu.Unlock()
+
+	go m.readOutput(outputReader)
+	go func() {
+		<-done
+		outputWriter.Close()
+		// commands sent after the exit fail instead of blocking
+		pipeOut.Close()
+	}()
+	go m.waitEnd(cmd, done)
+	go m.watchContext(cmd, done, ctx)
 	m.scheduleStartupCommands(string(startupCommands))
 	return nil
 }
 
-func (m *McProcessHolder) waitEnd() {
-	err := m.command.Wait()
+// Listener is called for every line of server output. Must be added before Start
+func (m *McProcessHolder) AddOutputListener(listener func(line string)) {
+	m.outputListeners = append(m.outputListeners, listener)
+}
+
+func (m *McProcessHolder) readOutput(output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, listener := range m.outputListeners {
+			listener(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		m.logger.Error("cannot read server output", zap.Error(err))
+	}
+	// drain so that the server is never blocked on stdout
+	io.Copy(io.Discard, output)
+}
+
+func (m *McProcessHolder) waitEnd(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
 	if err != nil {
 		m.logger.Error("command finished with error", zap.Error(err))
 	}
-	close(m.cmdDone)
+	close(done)
 }
 
-func (m *McProcessHolder) watchContext() {
+func (m *McProcessHolder) watchContext(cmd *exec.Cmd, done <-chan struct{}, ctx context.Context) {
 	select {
-	case <-m.Done():
+	case <-done:
 		return
-	case <-m.ctx.Done():
+	case <-ctx.Done():
 	}
-	processutil.InterruptAndKill(m.command, m.config.KillJavaTimeout)
+	processutil.InterruptAndKill(cmd, m.config.KillJavaTimeout)
 }
 
 func (m *McProcessHolder) scheduleStartupCommands(startupCommands string) {
@@ -13,35 +13,68 @@ import (
 	"go.uber.org/zap"
 )
 
+type AccountManagerConfig struct {
+	WhitelistPath      string
+	OpsPath            string
+	BannedPlayersPath  string
+	LuckPermsStatePath string
+	LuckPermsGroups    LuckPermsGroupsMapping
+	CheckFrequency     time.Duration
+}
+
 type AccountManager struct {
-	whitelistPath  string
-	checkFrequency time.Duration
-	execFunc       func(string) error
+	whitelistPath      string
+	opsPath            string
+	bannedPlayersPath  string
+	luckPermsStatePath string
+	luckPermsGroups    LuckPermsGroupsMapping
+	checkFrequency     time.Duration
+	execFunc           func(string) error
 
 	// nil means not set
 	neededAccounts        map[MinecraftAccountSpec]struct{}
 	accountPasswordsToSet map[mojang.MinecraftLogin]string
+	// by player id, nil means not set
+	neededOps     map[string]OpSpec
+	neededBans    map[string]PlayerBanSpec
+	neededRoles   map[string]AccountRolesSpec
+	appliedGroups luckPermsState
 
 	allAccountsRequests     chan []MinecraftAccountSpec
 	accountPasswordRequests chan map[mojang.MinecraftLogin]string
+	opsRequests             chan []OpSpec
+	bansRequests            chan []PlayerBanSpec
+	rolesRequests           chan []AccountRolesSpec
+	unappliedRequests       chan chan []LuckPermsGroupsStatus
+	exclusiveRequests       chan func()
+	loopDoneC               chan struct{}
 
 	logger *zap.Logger
 	ctx    context.Context
 }
 
 func NewAccountManager(
-	whitelistPath string,
-	checkFrequency time.Duration,
+	config AccountManagerConfig,
 	execFunc func(string) error,
 	logger *zap.Logger) *AccountManager {
 	return &AccountManager{
-		whitelistPath:           whitelistPath,
-		checkFrequency:          checkFrequency,
+		whitelistPath:           config.WhitelistPath,
+		opsPath:                 config.OpsPath,
+		bannedPlayersPath:       config.BannedPlayersPath,
+		luckPermsStatePath:      config.LuckPermsStatePath,
+		luckPermsGroups:         config.LuckPermsGroups,
+		checkFrequency:          config.CheckFrequency,
 		execFunc:                execFunc,
 		neededAccounts:          nil,
 		accountPasswordsToSet:   make(map[mojang.MinecraftLogin]string),
 		allAccountsRequests:     make(chan []MinecraftAccountSpec),
 		accountPasswordRequests: make(chan map[mojang.MinecraftLogin]string),
+		opsRequests:             make(chan []OpSpec),
+		bansRequests:            make(chan []PlayerBanSpec),
+		rolesRequests:           make(chan []AccountRolesSpec),
+		unappliedRequests:       make(chan chan []LuckPermsGroupsStatus),
+		exclusiveRequests:       make(chan func()),
+		loopDoneC:               make(chan struct{}),
 		logger:                  logger,
 	}
 }
@@ -52,6 +85,7 @@ func (manager *AccountManager) runAccountManager(ctx context.Context) {
 }
 
 func (manager *AccountManager) accountManagerLoop() {
+	defer close(manager.loopDoneC)
 	tk := time.NewTicker(manager.checkFrequency)
 	defer tk.Stop()
 	for {
@@ -67,12 +101,58 @@ func (manager *AccountManager) accountManagerLoop() {
 			for k, v := range newAccountPasswords {
 				manager.accountPasswordsToSet[k] = v
 			}
+		case newOps := <-manager.opsRequests:
+			manager.neededOps = make(map[string]OpSpec, len(newOps))
+			for _, op := range newOps {
+				manager.neededOps[op.PlayerId] = op
+			}
+		case newBans := <-manager.bansRequests:
+			manager.neededBans = make(map[string]PlayerBanSpec, len(newBans))
+			for _, ban := range newBans {
+				manager.neededBans[ban.PlayerId] = ban
+			}
+		case newRoles := <-manager.rolesRequests:
+			manager.neededRoles = make(map[string]AccountRolesSpec, len(newRoles))
+			for _, roles := range newRoles {
+				manager.neededRoles[roles.PlayerId] = roles
+			}
+		case resultC := <-manager.unappliedRequests:
+			resultC <- manager.luckPermsUnapplied()
+		case f := <-manager.exclusiveRequests:
+			f()
 		case <-tk.C:
 			manager.updateAccountState()
 		}
 	}
 }
 
+// runs f in the manager loop, so that player lists are not reconciled concurrently
+func (manager *AccountManager) runExclusive(f func() error) error {
+	resultC := make(chan error, 1)
+	select {
+	case manager.exclusiveRequests <- func() { resultC <- f() }:
+	case <-manager.ctx.Done():
+		return manager.ctx.Err()
+	}
+	return <-resultC
+}
+
+// Writes the player list files which the server only reads on startup.
+// Java must be stopped, otherwise it overwrites them from memory on shutdown.
+func (manager *AccountManager) writePlayerLists() {
+	write := func() error {
+		manager.writeOps()
+		manager.writeBannedPlayers()
+		return nil
+	}
+	err := manager.runExclusive(write)
+	if err != nil {
+		// the loop is stopping, the state is no longer changed after it ends
+		<-manager.loopDoneC
+		write()
+	}
+}
+
 func (manager *AccountManager) updateAccountState() {
 	if manager.neededAccounts == nil {
 		manager.logger.Debug("no accounts needed set")
@@ -80,6 +160,9 @@ func (manager *AccountManager) updateAccountState() {
 	}
 	manager.setPasswords()
 	manager.checkAccounts()
+	manager.checkOps()
+	manager.checkBans()
+	manager.checkLuckPermsGroups()
 }
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
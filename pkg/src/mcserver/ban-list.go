@@ -0,0 +1,201 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"go.uber.org/zap"
+)
+
+type PlayerBanSpec struct {
+	Name     mojang.MinecraftLogin `json:"name"`
+	PlayerId string                `json:"player_id"`
+	Reason   string                `json:"reason"`
+	Source   string                `json:"source"`
+	Created  time.Time             `json:"created"`
+	Expires  *time.Time            `json:"expires,omitempty"` // nil means forever
+}
+
+// format of banned-players.json entries
+type bannedPlayerEntry struct {
+	Uuid    string                `json:"uuid"`
+	Name    mojang.MinecraftLogin `json:"name"`
+	Created string                `json:"created"`
+	Source  string                `json:"source"`
+	Expires string                `json:"expires"`
+	Reason  string                `json:"reason"`
+}
+
+// minecraft ban list date format
+const banDateFormat = "2006-01-02 15:04:05 -0700"
+
+const banExpiresForever = "forever"
+
+// minecraft limits a console command to 256 characters
+const maxBanReasonLength = 200
+
+// The reason becomes a part of a console command, a line break in it would start another command.
+// Control characters and line separators are replaced with spaces
+func sanitizeBanReason(reason string) string {
+	reason = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, reason)
+	reason = strings.Join(strings.Fields(reason), " ")
+	runes := []rune(reason)
+	if len(runes) > maxBanReasonLength {
+		reason = strings.TrimSpace(string(runes[:maxBanReasonLength]))
+	}
+	return reason
+}
+
+func isValidBanName(name mojang.MinecraftLogin) bool {
+	_, err := mojang.MakeMinecraftLogin(string(name))
+	return err == nil
+}
+
+func makeBannedPlayerEntry(spec PlayerBanSpec) bannedPlayerEntry {
+	expires := banExpiresForever
+	if spec.Expires != nil {
+		expires = spec.Expires.Format(banDateFormat)
+	}
+	return bannedPlayerEntry{
+		Uuid:    spec.PlayerId,
+		Name:    spec.Name,
+		Created: spec.Created.Format(banDateFormat),
+		Source:  spec.Source,
+		Expires: expires,
+		Reason:  sanitizeBanReason(spec.Reason),
+	}
+}
+
+func sortBannedPlayers(bans []bannedPlayerEntry) {
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Name != bans[j].Name {
+			return bans[i].Name < bans[j].Name
+		}
+		return bans[i].Uuid < bans[j].Uuid
+	})
+}
+
+func (manager *AccountManager) readBannedPlayers() ([]bannedPlayerEntry, error) {
+	content, err := os.ReadFile(manager.bannedPlayersPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []bannedPlayerEntry{}, nil
+		}
+		return nil, err
+	}
+	var bans []bannedPlayerEntry
+	err = json.Unmarshal(content, &bans)
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+func (manager *AccountManager) neededBannedPlayers() []bannedPlayerEntry {
+	neededBans := make([]bannedPlayerEntry, 0, len(manager.neededBans))
+	for _, ban := range manager.neededBans {
+		if !isValidBanName(ban.Name) {
+			manager.logger.Error("invalid name of banned player", zap.String("name", string(ban.Name)))
+			continue
+		}
+		neededBans = append(neededBans, makeBannedPlayerEntry(ban))
+	}
+	sortBannedPlayers(neededBans)
+	return neededBans
+}
+
+// Same as with ops, running server keeps bans in memory and saves banned-players.json
+// itself. /ban kicks online players and /pardon lifts the ban, source and expiry
+// are written by writeBannedPlayers while the server is stopped.
+func (manager *AccountManager) checkBans() {
+	if manager.neededBans == nil {
+		return
+	}
+	neededBans := manager.neededBannedPlayers()
+	currentBans, err := manager.readBannedPlayers()
+	if err != nil {
+		manager.logger.Error("cannot read banned players", zap.Error(err))
+		return
+	}
+	sortBannedPlayers(currentBans)
+	currentUuids := make(map[string]struct{}, len(currentBans))
+	for _, ban := range currentBans {
+		currentUuids[ban.Uuid] = struct{}{}
+		if _, ok := manager.neededBans[ban.Uuid]; ok {
+			continue
+		}
+		if !isValidBanName(ban.Name) {
+			manager.logger.Error("invalid name in banned players", zap.String("name", string(ban.Name)))
+			continue
+		}
+		err = manager.execFunc(fmt.Sprintf("/pardon %s", ban.Name))
+		if err != nil {
+			manager.logger.Error("cannot pardon player", zap.String("name", string(ban.Name)), zap.Error(err))
+		}
+	}
+	for _, ban := range neededBans {
+		if _, ok := currentUuids[ban.Uuid]; ok {
+			continue
+		}
+		err = manager.execFunc(fmt.Sprintf("/ban %s %s", ban.Name, ban.Reason))
+		if err != nil {
+			manager.logger.Error("cannot ban player", zap.String("name", string(ban.Name)), zap.Error(err))
+		}
+	}
+}
+
+// Rewrites banned-players.json with source and expiry. Must only be called with
+// the server stopped, a running server overwrites the file from memory.
+func (manager *AccountManager) writeBannedPlayers() {
+	if manager.neededBans == nil {
+		return
+	}
+	neededBans := manager.neededBannedPlayers()
+	currentBans, err := manager.readBannedPlayers()
+	if err != nil {
+		manager.logger.Error("cannot read banned players", zap.Error(err))
+		return
+	}
+	sortBannedPlayers(currentBans)
+	if len(currentBans) == len(neededBans) {
+		equal := true
+		for i := range currentBans {
+			if currentBans[i] != neededBans[i] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return
+		}
+	}
+	bansContent, err := json.Marshal(neededBans)
+	if err != nil {
+		manager.logger.Error("cannot marshal banned players", zap.Error(err))
+		return
+	}
+	err = os.WriteFile(manager.bannedPlayersPath, bansContent, 0664)
+	if err != nil {
+		manager.logger.Error("cannot write banned players", zap.Error(err))
+	}
+}
+
+func (manager *AccountManager) SetNeededBans(bans []PlayerBanSpec) error {
+	select {
+	case manager.bansRequests <- bans:
+		return nil
+	case <-manager.ctx.Done():
+		return manager.ctx.Err()
+	}
+}
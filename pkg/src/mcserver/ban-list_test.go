@@ -0,0 +1,65 @@
+package mcserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCheckBansSanitizesCommands(t *testing.T) {
+	commands := []string{}
+	bannedPlayersPath := filepath.Join(t.TempDir(), "banned-players.json")
+	manager := NewAccountManager(
+		AccountManagerConfig{BannedPlayersPath: bannedPlayersPath},
+		func(command string) error {
+			commands = append(commands, command)
+			return nil
+		},
+		zap.NewNop(),
+	)
+	manager.neededBans = map[string]PlayerBanSpec{
+		"069a79f4-44e9-4726-a5be-fca90e38aaf5": {
+			Name:     "Notch",
+			PlayerId: "069a79f4-44e9-4726-a5be-fca90e38aaf5",
+			Reason:   "griefing\nop attacker\r\n/stop\u2028deop Notch",
+			Created:  time.Now(),
+		},
+		"853c80ef-3c37-49fd-aa49-938b674adae6": {
+			Name:     "jeb_\n/op attacker",
+			PlayerId: "853c80ef-3c37-49fd-aa49-938b674adae6",
+			Created:  time.Now(),
+		},
+	}
+	manager.checkBans()
+	if len(commands) != 1 {
+		t.Fatalf("Expected one ban command, got %q", commands)
+	}
+	if strings.ContainsAny(commands[0], "\r\n\u2028") {
+		t.Fatalf("Command contains a line break: %q", commands[0])
+	}
+	if commands[0] != "/ban Notch griefing op attacker /stop deop Notch" {
+		t.Fatalf("Wrong ban command %q", commands[0])
+	}
+
+	if _, err := os.Stat(bannedPlayersPath); !os.IsNotExist(err) {
+		t.Fatal("banned-players.json was written while the server runs")
+	}
+
+	manager.writeBannedPlayers()
+	bans, err := manager.readBannedPlayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Name != "Notch" || bans[0].Reason != "griefing op attacker /stop deop Notch" {
+		t.Fatalf("Unexpected bans after write %+v", bans)
+	}
+
+	long := sanitizeBanReason(strings.Repeat("a", 2*maxBanReasonLength))
+	if len(long) != maxBanReasonLength {
+		t.Fatalf("Reason is not capped, length %d", len(long))
+	}
+}
@@ -0,0 +1,235 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"go.uber.org/zap"
+)
+
+// role name to luckperms groups
+type LuckPermsGroupsMapping map[string][]string
+
+type AccountRolesSpec struct {
+	Name     mojang.MinecraftLogin `json:"name"`
+	PlayerId string                `json:"player_id"`
+	Roles    []string              `json:"roles"`
+}
+
+type LuckPermsGroupsStatus struct {
+	Name    mojang.MinecraftLogin `json:"name"`
+	Desired []string              `json:"desired"`
+	Applied []string              `json:"applied"`
+}
+
+const luckPermsDefaultGroup = "default"
+
+// groups applied by the overseer, by player id. LuckPerms storage is not read
+// directly, so this is what we believe is set on the server.
+type luckPermsState map[string]LuckPermsGroupsStatus
+
+func (manager *AccountManager) desiredGroups(roles []string) []string {
+	groupsSet := map[string]struct{}{}
+	for _, role := range roles {
+		for _, group := range manager.luckPermsGroups[role] {
+			groupsSet[group] = struct{}{}
+		}
+	}
+	groups := make([]string, 0, len(groupsSet))
+	for group := range groupsSet {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (manager *AccountManager) loadLuckPermsState() {
+	if manager.appliedGroups != nil {
+		return
+	}
+	manager.appliedGroups = luckPermsState{}
+	content, err := os.ReadFile(manager.luckPermsStatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			manager.logger.Error("cannot read luckperms state", zap.Error(err))
+		}
+		return
+	}
+	err = json.Unmarshal(content, &manager.appliedGroups)
+	if err != nil {
+		manager.logger.Error("cannot unmarshal luckperms state", zap.Error(err))
+		manager.appliedGroups = luckPermsState{}
+	}
+}
+
+func (manager *AccountManager) saveLuckPermsState() {
+	content, err := json.Marshal(manager.appliedGroups)
+	if err != nil {
+		manager.logger.Error("cannot marshal luckperms state", zap.Error(err))
+		return
+	}
+	err = os.WriteFile(manager.luckPermsStatePath, content, 0664)
+	if err != nil {
+		manager.logger.Error("cannot write luckperms state", zap.Error(err))
+	}
+}
+
+// returns true if all commands succeeded
+func (manager *AccountManager) applyGroups(playerId string, applied []string, desired []string) bool {
+	if len(desired) == 0 {
+		err := manager.execFunc(fmt.Sprintf("/lp user %s parent set %s", playerId, luckPermsDefaultGroup))
+		if err != nil {
+			manager.logger.Error("cannot reset luckperms groups", zap.String("player_id", playerId), zap.Error(err))
+			return false
+		}
+		return true
+	}
+	if applied == nil {
+		// unknown state, replace everything
+		err := manager.execFunc(fmt.Sprintf("/lp user %s parent set %s", playerId, desired[0]))
+		if err != nil {
+			manager.logger.Error("cannot set luckperms group", zap.String("player_id", playerId), zap.Error(err))
+			return false
+		}
+		applied = desired[:1]
+	}
+	success := true
+	for _, group := range applied {
+		if slices.Contains(desired, group) {
+			continue
+		}
+		err := manager.execFunc(fmt.Sprintf("/lp user %s parent remove %s", playerId, group))
+		if err != nil {
+			manager.logger.Error("cannot remove luckperms group", zap.String("player_id", playerId), zap.Error(err))
+			success = false
+		}
+	}
+	for _, group := range desired {
+		if slices.Contains(applied, group) {
+			continue
+		}
+		err := manager.execFunc(fmt.Sprintf("/lp user %s parent add %s", playerId, group))
+		if err != nil {
+			manager.logger.Error("cannot add luckperms group", zap.String("player_id", playerId), zap.Error(err))
+			success = false
+		}
+	}
+	return success
+}
+
+func (manager *AccountManager) checkLuckPermsGroups() {
+	if manager.neededRoles == nil {
+		return
+	}
+	manager.loadLuckPermsState()
+	changed := false
+	for playerId, spec := range manager.neededRoles {
+		desired := manager.desiredGroups(spec.Roles)
+		status, known := manager.appliedGroups[playerId]
+		if known && slices.Equal(status.Applied, desired) {
+			continue
+		}
+		var applied []string
+		if known {
+			applied = status.Applied
+		}
+		manager.logger.Info("applying luckperms groups",
+			zap.String("name", string(spec.Name)),
+			zap.Strings("applied", applied),
+			zap.Strings("desired", desired),
+		)
+		if !manager.applyGroups(playerId, applied, desired) {
+			continue
+		}
+		manager.appliedGroups[playerId] = LuckPermsGroupsStatus{
+			Name:    spec.Name,
+			Desired: desired,
+			Applied: desired,
+		}
+		changed = true
+	}
+	for playerId, status := range manager.appliedGroups {
+		if _, ok := manager.neededRoles[playerId]; ok {
+			continue
+		}
+		if len(status.Applied) > 0 {
+			manager.logger.Info("applying luckperms groups",
+				zap.String("name", string(status.Name)),
+				zap.Strings("applied", status.Applied),
+			)
+			if !manager.applyGroups(playerId, status.Applied, nil) {
+				continue
+			}
+		}
+		delete(manager.appliedGroups, playerId)
+		changed = true
+	}
+	if changed {
+		manager.saveLuckPermsState()
+	}
+}
+
+// reports accounts whose groups the overseer has not applied yet, e.g. because
+// console commands failed. Only the overseer's own state file is compared,
+// groups changed in LuckPerms by hand are not detected.
+func (manager *AccountManager) luckPermsUnapplied() []LuckPermsGroupsStatus {
+	unapplied := []LuckPermsGroupsStatus{}
+	if manager.neededRoles == nil {
+		return unapplied
+	}
+	manager.loadLuckPermsState()
+	for playerId, spec := range manager.neededRoles {
+		desired := manager.desiredGroups(spec.Roles)
+		status := manager.appliedGroups[playerId]
+		if slices.Equal(status.Applied, desired) {
+			continue
+		}
+		unapplied = append(unapplied, LuckPermsGroupsStatus{
+			Name:    spec.Name,
+			Desired: desired,
+			Applied: status.Applied,
+		})
+	}
+	for playerId, status := range manager.appliedGroups {
+		if _, ok := manager.neededRoles[playerId]; ok || len(status.Applied) == 0 {
+			continue
+		}
+		unapplied = append(unapplied, LuckPermsGroupsStatus{
+			Name:    status.Name,
+			Desired: []string{},
+			Applied: status.Applied,
+		})
+	}
+	sort.Slice(unapplied, func(i, j int) bool {
+		return unapplied[i].Name < unapplied[j].Name
+	})
+	return unapplied
+}
+
+func (manager *AccountManager) SetNeededRoles(roles []AccountRolesSpec) error {
+	select {
+	case manager.rolesRequests <- roles:
+		return nil
+	case <-manager.ctx.Done():
+		return manager.ctx.Err()
+	}
+}
+
+func (manager *AccountManager) GetLuckPermsUnapplied() ([]LuckPermsGroupsStatus, error) {
+	resultC := make(chan []LuckPermsGroupsStatus, 1)
+	select {
+	case manager.unappliedRequests <- resultC:
+	case <-manager.ctx.Done():
+		return nil, manager.ctx.Err()
+	}
+	select {
+	case unapplied := <-resultC:
+		return unapplied, nil
+	case <-manager.ctx.Done():
+		return nil, manager.ctx.Err()
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/imobulus/subchat-mc-server/src/mcprocess"
+	"github.com/imobulus/subchat-mc-server/src/modconfigs"
+	"github.com/imobulus/subchat-mc-server/src/modsindex"
 	"github.com/imobulus/subchat-mc-server/src/mojang"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -25,7 +28,16 @@ type Config struct {
 	CommandsPort           int                       `yaml:"commands port"`
 	AuthDbPath             string                    `yaml:"auth db path"`
 	UserCachePath          string                    `yaml:"user cache path"`
+	WorldPath              string                    `yaml:"world path"`
+	MigrationsLogPath      string                    `yaml:"migrations log path"`
 	WhitelistPath          string                    `yaml:"whitelist path"`
+	OpsPath                string                    `yaml:"ops path"`
+	BannedPlayersPath      string                    `yaml:"banned players path"`
+	LuckPermsStatePath     string                    `yaml:"luckperms state path"`
+	LuckPermsGroups        LuckPermsGroupsMapping    `yaml:"luckperms groups"`
+	ModConfigsPath         string                    `yaml:"mod configs path"`
+	ModConfigsStatePath    string                    `yaml:"mod configs state path"`
+	ModConfigVariables     map[string]string         `yaml:"mod config variables"`
 	JavaProcessConfig      mcprocess.McProcessConfig `yaml:"java process config"`
 	CheckAccountsFrequency time.Duration             `yaml:"check accounts frequency"`
 }
@@ -35,7 +47,16 @@ var DefaultConfig = Config{
 	CommandsPort:           8080,
 	AuthDbPath:             "mods/EasyAuth/levelDBStore",
 	UserCachePath:          "usercache.json",
+	WorldPath:              "world",
+	MigrationsLogPath:      "player-lists/player-migrations.jsonl",
 	WhitelistPath:          "whitelist.json",
+	OpsPath:                "ops.json",
+	BannedPlayersPath:      "banned-players.json",
+	LuckPermsStatePath:     "player-lists/luckperms-groups.json",
+	LuckPermsGroups:        LuckPermsGroupsMapping{},
+	ModConfigsPath:         "mod-configs/mod-configs.json",
+	ModConfigsStatePath:    "player-lists/mod-configs-state.json",
+	ModConfigVariables:     map[string]string{},
 	JavaProcessConfig:      mcprocess.DefaultMcProcessConfig,
 	CheckAccountsFrequency: 2 * time.Second,
 }
@@ -44,6 +65,9 @@ type Server struct {
 	config         Config
 	javaProcess    *mcprocess.McProcessHolder
 	accountManager *AccountManager
+	verifier       *OwnershipVerifier
+	modsHandler    *modsindex.Handler
+	maintenanceMu  *sync.Mutex // held while java is stopped for maintenance
 	wg             *sync.WaitGroup
 	doneC          chan struct{}
 	logger         *zap.Logger
@@ -54,15 +78,26 @@ type Server struct {
 func NewServer(config Config, logger *zap.Logger) (*Server, error) {
 	javaProcess := mcprocess.NewMcProcessHolder(config.JavaProcessConfig, logger)
 	accountManager := NewAccountManager(
-		config.WhitelistPath,
-		config.CheckAccountsFrequency,
+		AccountManagerConfig{
+			WhitelistPath:      config.WhitelistPath,
+			OpsPath:            config.OpsPath,
+			BannedPlayersPath:  config.BannedPlayersPath,
+			LuckPermsStatePath: config.LuckPermsStatePath,
+			LuckPermsGroups:    config.LuckPermsGroups,
+			CheckFrequency:     config.CheckAccountsFrequency,
+		},
 		javaProcess.Exec,
 		logger,
 	)
+	verifier := NewOwnershipVerifier(javaProcess.Exec, logger)
+	javaProcess.AddOutputListener(verifier.HandleOutputLine)
 	s := &Server{
 		config:         config,
 		javaProcess:    javaProcess,
 		accountManager: accountManager,
+		verifier:       verifier,
+		modsHandler:    modsindex.NewHandler("clientmods", logger),
+		maintenanceMu:  &sync.Mutex{},
 		wg:             &sync.WaitGroup{},
 		doneC:          make(chan struct{}),
 		logger:         logger,
@@ -86,10 +121,10 @@ func (s *Server) Start(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "cannot start java process")
 	}
+	s.accountManager.runAccountManager(ctx)
 	s.watchJava()
 	s.runServer()
 	go s.watchWg()
-	s.accountManager.runAccountManager(ctx)
 	success = true
 	return nil
 }
@@ -104,11 +139,40 @@ func (s *Server) watchJava() {
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		<-s.javaProcess.Done()
-		s.cancel()
+		for {
+			done := s.javaProcess.Done()
+			<-done
+			s.maintenanceMu.Lock()
+			restarted := s.javaProcess.Done() != done
+			if !restarted {
+				s.accountManager.writePlayerLists()
+			}
+			s.maintenanceMu.Unlock()
+			if !restarted {
+				s.cancel()
+				return
+			}
+		}
 	}()
 }
 
+// Stops java, runs f and starts java again. The overseer shuts down if java cannot be restarted
+func (s *Server) runStopped(f func() error) error {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	s.logger.Info("stopping server for maintenance")
+	s.javaProcess.Stop()
+	s.accountManager.writePlayerLists()
+	fErr := f()
+	s.logger.Info("starting server after maintenance")
+	err := s.javaProcess.Restart()
+	if err != nil {
+		s.cancel()
+		return errors.Wrap(err, "cannot restart java process")
+	}
+	return fErr
+}
+
 func (s *Server) Done() <-chan struct{} {
 	return s.doneC
 }
@@ -119,7 +183,30 @@ func (s *Server) GetMcProcess() *mcprocess.McProcessHolder {
 
 func (s *Server) configure() error {
 	err := s.updateProperties()
-	return err
+	if err != nil {
+		return err
+	}
+	return s.renderModConfigs()
+}
+
+func (s *Server) renderModConfigs() error {
+	manifest, err := modconfigs.ReadManifest(s.config.ModConfigsPath)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			s.logger.Info("no mod configs manifest", zap.String("path", s.config.ModConfigsPath))
+			return nil
+		}
+		return err
+	}
+	drifted, err := manifest.ApplyServer(".", s.config.ModConfigsStatePath, s.config.ModConfigVariables)
+	for _, file := range drifted {
+		s.logger.Warn("mod config was changed since it was rendered, update its template",
+			zap.String("target", file.Target), zap.String("backup", file.BackupPath))
+	}
+	if err != nil {
+		return errors.Wrap(err, "cannot render mod configs")
+	}
+	return nil
 }
 
 func (s *Server) updateProperties() error {
@@ -203,6 +290,220 @@ func (s *Server) handleSetWhitelist(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) handleSetOps(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var ops []OpSpec
+	err = json.Unmarshal(bodyBytes, &ops)
+	if err != nil {
+		s.logger.Error("cannot unmarshal ops", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = s.accountManager.SetNeededOps(ops)
+	if err != nil {
+		s.logger.Error("cannot set needed ops", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSetBans(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var bans []PlayerBanSpec
+	err = json.Unmarshal(bodyBytes, &bans)
+	if err != nil {
+		s.logger.Error("cannot unmarshal bans", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = s.accountManager.SetNeededBans(bans)
+	if err != nil {
+		s.logger.Error("cannot set needed bans", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleSetRoles(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var roles []AccountRolesSpec
+	err = json.Unmarshal(bodyBytes, &roles)
+	if err != nil {
+		s.logger.Error("cannot unmarshal roles", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = s.accountManager.SetNeededRoles(roles)
+	if err != nil {
+		s.logger.Error("cannot set needed roles", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleLuckPermsUnapplied(w http.ResponseWriter, r *http.Request) {
+	unapplied, err := s.accountManager.GetLuckPermsUnapplied()
+	if err != nil {
+		s.logger.Error("cannot get unapplied luckperms groups", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	unappliedBytes, err := json.Marshal(unapplied)
+	if err != nil {
+		s.logger.Error("cannot marshal unapplied luckperms groups", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(unappliedBytes)
+}
+
+func (s *Server) handleSetOwnershipChallenges(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var challenges []OwnershipChallengeSpec
+	err = json.Unmarshal(bodyBytes, &challenges)
+	if err != nil {
+		s.logger.Error("cannot unmarshal ownership challenges", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.verifier.SetChallenges(challenges)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleOwnershipVerifications(w http.ResponseWriter, r *http.Request) {
+	verificationsBytes, err := json.Marshal(s.verifier.GetVerifications())
+	if err != nil {
+		s.logger.Error("cannot marshal ownership verifications", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(verificationsBytes)
+}
+
+func (s *Server) handleUserCache(w http.ResponseWriter, r *http.Request) {
+	entries, err := readUserCache(s.config.UserCachePath)
+	if err != nil {
+		s.logger.Error("cannot read user cache", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	entriesBytes, err := json.Marshal(entries)
+	if err != nil {
+		s.logger.Error("cannot marshal user cache", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(entriesBytes)
+}
+
+func (s *Server) handleMigratePlayerData(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var spec PlayerDataMigrationSpec
+	err = json.Unmarshal(bodyBytes, &spec)
+	if err != nil {
+		s.logger.Error("cannot unmarshal migration", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	record, migrationErr := s.migratePlayer(spec)
+	status := http.StatusOK
+	if migrationErr != nil {
+		s.logger.Error("cannot migrate player", zap.Any("record", record), zap.Error(migrationErr))
+		status = http.StatusInternalServerError
+		if errors.Is(migrationErr, ErrorPlayerDataExists{}) {
+			status = http.StatusConflict
+		}
+	} else {
+		s.logger.Info("migrated player", zap.Any("record", record))
+	}
+	// the record is sent on errors too, it tells whether the migration was rolled back
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		s.logger.Error("cannot marshal migration record", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(recordBytes)
+}
+
+func (s *Server) handlePlayerInfo(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.logger.Error("cannot read body", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var request PlayerInfoRequest
+	err = json.Unmarshal(bodyBytes, &request)
+	if err != nil {
+		s.logger.Error("cannot unmarshal player info request", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	playerId, err := uuid.Parse(request.PlayerId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	info, err := ReadPlayerInfo(s.config.WorldPath, playerId)
+	if err != nil {
+		if errors.Is(err, ErrorNoPlayerData{}) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.logger.Error("cannot read player info", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	infoBytes, err := json.Marshal(info)
+	if err != nil {
+		s.logger.Error("cannot marshal player info", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(infoBytes)
+}
+
 func (s *Server) handleSetPasswords(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	bodyBytes, err := io.ReadAll(r.Body)
@@ -241,6 +542,60 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		s.handleSetWhitelist(w, r)
+	case "/set-ops":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSetOps(w, r)
+	case "/set-bans":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSetBans(w, r)
+	case "/set-roles":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSetRoles(w, r)
+	case "/set-ownership-challenges":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSetOwnershipChallenges(w, r)
+	case "/ownership-verifications":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleOwnershipVerifications(w, r)
+	case "/luckperms-unapplied":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleLuckPermsUnapplied(w, r)
+	case "/usercache":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleUserCache(w, r)
+	case "/migrate-player-data":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleMigratePlayerData(w, r)
+	case "/player-info":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handlePlayerInfo(w, r)
 	case "/set-passwords":
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -262,7 +617,7 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	default:
 		if strings.HasPrefix(r.RequestURI, "/mods/") {
-			http.StripPrefix("/mods", http.FileServer(http.Dir("clientmods"))).ServeHTTP(w, r)
+			http.StripPrefix("/mods", s.modsHandler).ServeHTTP(w, r)
 			return
 		}
 		w.WriteHeader(http.StatusNotFound)
@@ -0,0 +1,146 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"go.uber.org/zap"
+)
+
+type OpSpec struct {
+	Name                mojang.MinecraftLogin `json:"name"`
+	PlayerId            string                `json:"player_id"`
+	Level               int                   `json:"level"`
+	BypassesPlayerLimit bool                  `json:"bypasses_player_limit"`
+}
+
+// format of ops.json entries
+type opsEntry struct {
+	Uuid                string                `json:"uuid"`
+	Name                mojang.MinecraftLogin `json:"name"`
+	Level               int                   `json:"level"`
+	BypassesPlayerLimit bool                  `json:"bypassesPlayerLimit"`
+}
+
+func sortOps(ops []opsEntry) {
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Name != ops[j].Name {
+			return ops[i].Name < ops[j].Name
+		}
+		return ops[i].Uuid < ops[j].Uuid
+	})
+}
+
+func (manager *AccountManager) readOps() ([]opsEntry, error) {
+	content, err := os.ReadFile(manager.opsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []opsEntry{}, nil
+		}
+		return nil, err
+	}
+	var ops []opsEntry
+	err = json.Unmarshal(content, &ops)
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (manager *AccountManager) neededOpsEntries() []opsEntry {
+	neededOps := make([]opsEntry, 0, len(manager.neededOps))
+	for _, op := range manager.neededOps {
+		neededOps = append(neededOps, opsEntry{
+			Uuid:                op.PlayerId,
+			Name:                op.Name,
+			Level:               op.Level,
+			BypassesPlayerLimit: op.BypassesPlayerLimit,
+		})
+	}
+	sortOps(neededOps)
+	return neededOps
+}
+
+// Running server keeps operators in memory and saves ops.json itself after
+// /op and /deop, so membership is changed only with these commands. The configured
+// levels are written by writeOps while the server is stopped.
+func (manager *AccountManager) checkOps() {
+	if manager.neededOps == nil {
+		return
+	}
+	neededOps := manager.neededOpsEntries()
+	currentOps, err := manager.readOps()
+	if err != nil {
+		manager.logger.Error("cannot read ops", zap.Error(err))
+		return
+	}
+	sortOps(currentOps)
+	currentUuids := make(map[string]struct{}, len(currentOps))
+	for _, op := range currentOps {
+		currentUuids[op.Uuid] = struct{}{}
+		if _, ok := manager.neededOps[op.Uuid]; ok {
+			continue
+		}
+		err = manager.execFunc(fmt.Sprintf("/deop %s", op.Name))
+		if err != nil {
+			manager.logger.Error("cannot deop player", zap.String("name", string(op.Name)), zap.Error(err))
+		}
+	}
+	for _, op := range neededOps {
+		if _, ok := currentUuids[op.Uuid]; ok {
+			continue
+		}
+		err = manager.execFunc(fmt.Sprintf("/op %s", op.Name))
+		if err != nil {
+			manager.logger.Error("cannot op player", zap.String("name", string(op.Name)), zap.Error(err))
+		}
+	}
+}
+
+// Rewrites ops.json with the configured levels. Must only be called with the server
+// stopped, a running server overwrites the file from memory.
+func (manager *AccountManager) writeOps() {
+	if manager.neededOps == nil {
+		return
+	}
+	neededOps := manager.neededOpsEntries()
+	currentOps, err := manager.readOps()
+	if err != nil {
+		manager.logger.Error("cannot read ops", zap.Error(err))
+		return
+	}
+	sortOps(currentOps)
+	if len(currentOps) == len(neededOps) {
+		equal := true
+		for i := range currentOps {
+			if currentOps[i] != neededOps[i] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return
+		}
+	}
+	opsContent, err := json.Marshal(neededOps)
+	if err != nil {
+		manager.logger.Error("cannot marshal ops", zap.Error(err))
+		return
+	}
+	err = os.WriteFile(manager.opsPath, opsContent, 0664)
+	if err != nil {
+		manager.logger.Error("cannot write ops", zap.Error(err))
+	}
+}
+
+func (manager *AccountManager) SetNeededOps(ops []OpSpec) error {
+	select {
+	case manager.opsRequests <- ops:
+		return nil
+	case <-manager.ctx.Done():
+		return manager.ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package mcserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOpsFileWrittenOnlyWhenStopped(t *testing.T) {
+	opsPath := filepath.Join(t.TempDir(), "ops.json")
+	// as saved by the running server after /op
+	serverOps := `[{"uuid":"853c80ef-3c37-49fd-aa49-938b674adae6","name":"jeb_","level":4,"bypassesPlayerLimit":false}]`
+	err := os.WriteFile(opsPath, []byte(serverOps), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := []string{}
+	manager := NewAccountManager(
+		AccountManagerConfig{OpsPath: opsPath},
+		func(command string) error {
+			commands = append(commands, command)
+			return nil
+		},
+		zap.NewNop(),
+	)
+	manager.neededOps = map[string]OpSpec{
+		"069a79f4-44e9-4726-a5be-fca90e38aaf5": {
+			Name:     "Notch",
+			PlayerId: "069a79f4-44e9-4726-a5be-fca90e38aaf5",
+			Level:    2,
+		},
+	}
+	manager.checkOps()
+	if len(commands) != 2 || commands[0] != "/deop jeb_" || commands[1] != "/op Notch" {
+		t.Fatalf("Unexpected commands %q", commands)
+	}
+	content, err := os.ReadFile(opsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != serverOps {
+		t.Fatalf("ops.json was written while the server runs: %s", content)
+	}
+
+	manager.writeOps()
+	ops, err := manager.readOps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Name != "Notch" || ops[0].Level != 2 {
+		t.Fatalf("Unexpected ops after write %+v", ops)
+	}
+}
@@ -0,0 +1,132 @@
+package mcserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"go.uber.org/zap"
+)
+
+// pending proof of ownership of an official account
+type OwnershipChallengeSpec struct {
+	Name     mojang.MinecraftLogin `json:"name"`
+	PlayerId string                `json:"player_id"`
+	Code     string                `json:"code"`
+}
+
+// code was typed in chat by the player authenticated as PlayerId
+type OwnershipVerification struct {
+	PlayerId string `json:"player_id"`
+	Code     string `json:"code"`
+}
+
+// Patterns match the whole line, chat messages are logged after the same prefix and could
+// otherwise contain a fake line of another kind
+const serverThreadPrefix = `^\[[^\]]+\] \[Server thread/INFO\]: `
+
+var (
+	// logged by the login listener for players authenticated by mojang
+	authenticatedPlayerRegexp = regexp.MustCompile(`^\[[^\]]+\] \[User Authenticator #\d+/INFO\]: UUID of player (\w+) is ([0-9a-f-]{36})$`)
+	chatMessageRegexp         = regexp.MustCompile(serverThreadPrefix + `(?:\[Not Secure\] )?<(\w+)> (.*)$`)
+	playerLeftRegexp          = regexp.MustCompile(serverThreadPrefix + `(\w+) (?:left the game|lost connection: .*)$`)
+)
+
+// Watches server output for pending players typing their codes in chat
+type OwnershipVerifier struct {
+	// by player id
+	challenges map[string]OwnershipChallengeSpec
+	// player id by name of players authenticated in current session
+	authenticated map[mojang.MinecraftLogin]string
+	// by code
+	verified map[string]OwnershipVerification
+	mu       *sync.Mutex
+	execFunc func(string) error
+	logger   *zap.Logger
+}
+
+func NewOwnershipVerifier(execFunc func(string) error, logger *zap.Logger) *OwnershipVerifier {
+	return &OwnershipVerifier{
+		challenges:    make(map[string]OwnershipChallengeSpec),
+		authenticated: make(map[mojang.MinecraftLogin]string),
+		verified:      make(map[string]OwnershipVerification),
+		mu:            &sync.Mutex{},
+		execFunc:      execFunc,
+		logger:        logger,
+	}
+}
+
+// replaces pending challenges, verifications of removed challenges are forgotten
+func (v *OwnershipVerifier) SetChallenges(challenges []OwnershipChallengeSpec) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.challenges = make(map[string]OwnershipChallengeSpec, len(challenges))
+	codes := make(map[string]struct{}, len(challenges))
+	for _, challenge := range challenges {
+		v.challenges[challenge.PlayerId] = challenge
+		codes[challenge.Code] = struct{}{}
+	}
+	for code := range v.verified {
+		if _, ok := codes[code]; !ok {
+			delete(v.verified, code)
+		}
+	}
+}
+
+func (v *OwnershipVerifier) GetVerifications() []OwnershipVerification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	verifications := make([]OwnershipVerification, 0, len(v.verified))
+	for _, verification := range v.verified {
+		verifications = append(verifications, verification)
+	}
+	return verifications
+}
+
+// called for every line of server output
+func (v *OwnershipVerifier) HandleOutputLine(line string) {
+	if match := authenticatedPlayerRegexp.FindStringSubmatch(line); match != nil {
+		v.mu.Lock()
+		v.authenticated[mojang.MinecraftLogin(match[1])] = match[2]
+		v.mu.Unlock()
+		return
+	}
+	if match := playerLeftRegexp.FindStringSubmatch(line); match != nil {
+		v.mu.Lock()
+		delete(v.authenticated, mojang.MinecraftLogin(match[1]))
+		v.mu.Unlock()
+		return
+	}
+	if match := chatMessageRegexp.FindStringSubmatch(line); match != nil {
+		v.handleChatMessage(mojang.MinecraftLogin(match[1]), strings.TrimSpace(match[2]))
+	}
+}
+
+func (v *OwnershipVerifier) handleChatMessage(name mojang.MinecraftLogin, message string) {
+	v.mu.Lock()
+	playerId, ok := v.authenticated[name]
+	if !ok {
+		v.mu.Unlock()
+		return
+	}
+	challenge, ok := v.challenges[playerId]
+	if !ok || challenge.Code != message {
+		v.mu.Unlock()
+		return
+	}
+	v.verified[challenge.Code] = OwnershipVerification{
+		PlayerId: playerId,
+		Code:     challenge.Code,
+	}
+	v.mu.Unlock()
+	v.logger.Info("ownership verified", zap.String("name", string(name)), zap.String("player_id", playerId))
+	// exec waits for the command pipe, output reading must not be blocked
+	go func() {
+		err := v.execFunc(fmt.Sprintf("/tell %s Account ownership confirmed, return to the bot", name))
+		if err != nil {
+			v.logger.Error("cannot notify player", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package mcserver
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOwnershipVerifier(t *testing.T) {
+	verifier := NewOwnershipVerifier(func(string) error { return nil }, zap.NewNop())
+	playerId := "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+	verifier.SetChallenges([]OwnershipChallengeSpec{{Name: "Notch", PlayerId: playerId, Code: "ABC123"}})
+
+	// not authenticated yet
+	verifier.HandleOutputLine("[12:00:00] [Server thread/INFO]: <Notch> ABC123")
+	verifier.HandleOutputLine("[12:00:01] [User Authenticator #1/INFO]: UUID of player Notch is " + playerId)
+	// someone quoting the code
+	verifier.HandleOutputLine("[12:00:02] [Server thread/INFO]: <Eve> <Notch> ABC123")
+	verifier.HandleOutputLine("[12:00:03] [Server thread/INFO]: [Not Secure] <Notch> wrong")
+	if len(verifier.GetVerifications()) != 0 {
+		t.Fatalf("Nothing should be verified yet: %v", verifier.GetVerifications())
+	}
+	verifier.HandleOutputLine("[12:00:04] [Server thread/INFO]: [Not Secure] <Notch> ABC123")
+	verifications := verifier.GetVerifications()
+	if len(verifications) != 1 || verifications[0].PlayerId != playerId || verifications[0].Code != "ABC123" {
+		t.Fatalf("Wrong verifications: %v", verifications)
+	}
+
+	verifier.SetChallenges(nil)
+	if len(verifier.GetVerifications()) != 0 {
+		t.Fatalf("Verifications of removed challenges should be dropped")
+	}
+	verifier.SetChallenges([]OwnershipChallengeSpec{{Name: "Notch", PlayerId: playerId, Code: "XYZ789"}})
+	verifier.HandleOutputLine("[12:01:00] [Server thread/INFO]: Notch left the game")
+	verifier.HandleOutputLine("[12:01:01] [Server thread/INFO]: <Notch> XYZ789")
+	if len(verifier.GetVerifications()) != 0 {
+		t.Fatalf("Player who left should not verify")
+	}
+}
+
+func TestOwnershipVerifierIgnoresSpoofedLines(t *testing.T) {
+	verifier := NewOwnershipVerifier(func(string) error { return nil }, zap.NewNop())
+	victimId := "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+	verifier.SetChallenges([]OwnershipChallengeSpec{{Name: "Notch", PlayerId: victimId, Code: "ABC123"}})
+
+	// chat messages pretending to be authenticator lines
+	verifier.HandleOutputLine("[12:00:00] [Server thread/INFO]: <attacker> x]: UUID of player attacker is " + victimId)
+	verifier.HandleOutputLine("[12:00:01] [Server thread/INFO]: [Not Secure] <attacker> x]: UUID of player attacker is " + victimId)
+	// authenticator lines are only logged by the authenticator threads
+	verifier.HandleOutputLine("[12:00:02] [Server thread/INFO]: UUID of player attacker is " + victimId)
+	verifier.HandleOutputLine("[12:00:03] [Server thread/INFO]: <attacker> ABC123")
+	if len(verifier.GetVerifications()) != 0 {
+		t.Fatalf("Spoofed authentication verified ownership: %v", verifier.GetVerifications())
+	}
+
+	// chat message pretending that the authenticated player left
+	verifier.HandleOutputLine("[12:00:04] [User Authenticator #2/INFO]: UUID of player Notch is " + victimId)
+	verifier.HandleOutputLine("[12:00:05] [Server thread/INFO]: <attacker> x]: Notch left the game")
+	verifier.HandleOutputLine("[12:00:06] [Server thread/INFO]: <Notch> ABC123")
+	if len(verifier.GetVerifications()) != 1 {
+		t.Fatal("Spoofed leave line logged the player out")
+	}
+}
@@ -0,0 +1,301 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// moves player data from one player id to another, names are optional
+type PlayerDataMigrationSpec struct {
+	FromName     mojang.MinecraftLogin `json:"from_name"`
+	FromPlayerId string                `json:"from_player_id"`
+	ToName       mojang.MinecraftLogin `json:"to_name"`
+	ToPlayerId   string                `json:"to_player_id"`
+	// who asked for the migration, for the audit log
+	RequestedBy string `json:"requested_by"`
+}
+
+// line of the migrations audit log
+type PlayerMigrationRecord struct {
+	Time           time.Time               `json:"time"`
+	Migration      PlayerDataMigrationSpec `json:"migration"`
+	MovedFiles     []string                `json:"moved_files"`
+	RewrittenLists []string                `json:"rewritten_lists"`
+	Error          string                  `json:"error,omitempty"`
+	// moved files and rewritten lists were restored after the error
+	RolledBack    bool   `json:"rolled_back,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+type ErrorPlayerDataExists struct {
+	Path string
+}
+
+func (e ErrorPlayerDataExists) Error() string {
+	return fmt.Sprintf("player data %s already exists", e.Path)
+}
+
+func (e ErrorPlayerDataExists) Is(target error) bool {
+	_, ok := target.(ErrorPlayerDataExists)
+	return ok
+}
+
+// The migration failed and its changes could not be restored, the record tells what is left moved
+type ErrorMigrationNotRolledBack struct {
+	Record PlayerMigrationRecord
+}
+
+func (e ErrorMigrationNotRolledBack) Error() string {
+	return fmt.Sprintf("migration failed: %s, rollback failed: %s", e.Record.Error, e.Record.RollbackError)
+}
+
+func (e ErrorMigrationNotRolledBack) Is(target error) bool {
+	_, ok := target.(ErrorMigrationNotRolledBack)
+	return ok
+}
+
+// files of the world keyed by player id
+func playerDataFiles(worldPath string, playerId uuid.UUID) []string {
+	id := playerId.String()
+	return []string{
+		filepath.Join(worldPath, "playerdata", id+".dat"),
+		filepath.Join(worldPath, "playerdata", id+".dat_old"),
+		filepath.Join(worldPath, "stats", id+".json"),
+		filepath.Join(worldPath, "advancements", id+".json"),
+	}
+}
+
+// replaced in tests to fail a migration halfway
+var renameFile = os.Rename
+
+// Renames data files of one player id to another. Nothing is moved if the target
+// player already has any data. Returns moved target files, also on error.
+func MigratePlayerData(worldPath string, from uuid.UUID, to uuid.UUID) ([]string, error) {
+	if from == to {
+		return nil, errors.Errorf("cannot migrate player %s to itself", from)
+	}
+	sources := playerDataFiles(worldPath, from)
+	targets := playerDataFiles(worldPath, to)
+	for _, target := range targets {
+		_, err := os.Stat(target)
+		if err == nil {
+			return nil, ErrorPlayerDataExists{Path: target}
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "cannot stat %s", target)
+		}
+	}
+	moved := []string{}
+	for i, source := range sources {
+		_, err := os.Stat(source)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return moved, errors.Wrapf(err, "cannot stat %s", source)
+		}
+		err = renameFile(source, targets[i])
+		if err != nil {
+			return moved, errors.Wrapf(err, "cannot move %s", source)
+		}
+		moved = append(moved, targets[i])
+	}
+	return moved, nil
+}
+
+// Moves the files returned by MigratePlayerData back to the source player id
+func rollbackPlayerData(worldPath string, from uuid.UUID, to uuid.UUID, moved []string) error {
+	sources := playerDataFiles(worldPath, from)
+	targets := playerDataFiles(worldPath, to)
+	var rollbackErr error
+	for _, path := range moved {
+		for i, target := range targets {
+			if target != path {
+				continue
+			}
+			err := renameFile(target, sources[i])
+			if err != nil && rollbackErr == nil {
+				rollbackErr = errors.Wrapf(err, "cannot move %s back", target)
+			}
+		}
+	}
+	return rollbackErr
+}
+
+// contents of the player lists by path, missing lists are not included
+func readPlayerLists(paths []string) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "cannot read %s", path)
+		}
+		contents[path] = content
+	}
+	return contents, nil
+}
+
+// Moves player data and rewrites the player lists. If any step fails, moved files and
+// lists are restored and the rollback is recorded
+func migratePlayerFiles(
+	worldPath string,
+	listPaths []string,
+	spec PlayerDataMigrationSpec,
+	from uuid.UUID,
+	to uuid.UUID,
+	record *PlayerMigrationRecord,
+) error {
+	lists, err := readPlayerLists(listPaths)
+	if err != nil {
+		return err
+	}
+	moved, err := MigratePlayerData(worldPath, from, to)
+	record.MovedFiles = append(record.MovedFiles, moved...)
+	// a failed rewrite may leave its list partially written
+	listsTouched := err == nil
+	if err == nil {
+		for _, path := range listPaths {
+			var changed bool
+			changed, err = rewritePlayerListUuid(path, from, to, spec.ToName)
+			if err != nil {
+				break
+			}
+			if changed {
+				record.RewrittenLists = append(record.RewrittenLists, path)
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	rollbackErr := rollbackPlayerData(worldPath, from, to, moved)
+	for path, content := range lists {
+		if !listsTouched {
+			break
+		}
+		writeErr := os.WriteFile(path, content, 0664)
+		if writeErr != nil && rollbackErr == nil {
+			rollbackErr = errors.Wrapf(writeErr, "cannot restore %s", path)
+		}
+	}
+	if rollbackErr != nil {
+		record.RollbackError = rollbackErr.Error()
+	} else {
+		record.RolledBack = len(moved) > 0 || listsTouched
+	}
+	return err
+}
+
+// Replaces player id in a json list of players like whitelist.json, ops.json or usercache.json.
+// Entry of the old id is dropped if the new id is already listed. Returns whether the file changed.
+func rewritePlayerListUuid(path string, from uuid.UUID, to uuid.UUID, toName mojang.MinecraftLogin) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "cannot read %s", path)
+	}
+	// entries are kept as is except for the replaced fields
+	var entries []map[string]interface{}
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	hasTarget := false
+	for _, entry := range entries {
+		if entry["uuid"] == to.String() {
+			hasTarget = true
+		}
+	}
+	changed := false
+	rewritten := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		if entry["uuid"] != from.String() {
+			rewritten = append(rewritten, entry)
+			continue
+		}
+		changed = true
+		if hasTarget {
+			continue
+		}
+		entry["uuid"] = to.String()
+		if toName != "" {
+			entry["name"] = toName
+		}
+		rewritten = append(rewritten, entry)
+	}
+	if !changed {
+		return false, nil
+	}
+	newContent, err := json.Marshal(rewritten)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot marshal %s", path)
+	}
+	err = os.WriteFile(path, newContent, 0664)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot write %s", path)
+	}
+	return true, nil
+}
+
+func appendMigrationRecord(path string, record PlayerMigrationRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal migration record")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", path)
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Wrapf(err, "cannot write %s", path)
+	}
+	return nil
+}
+
+// Moves player data and player list entries to the new player id with the server stopped,
+// the server would otherwise save the online player over the moved files
+func (s *Server) migratePlayer(spec PlayerDataMigrationSpec) (PlayerMigrationRecord, error) {
+	record := PlayerMigrationRecord{
+		Time:           time.Now(),
+		Migration:      spec,
+		MovedFiles:     []string{},
+		RewrittenLists: []string{},
+	}
+	from, err := uuid.Parse(spec.FromPlayerId)
+	if err != nil {
+		return record, errors.Wrapf(err, "invalid player id %s", spec.FromPlayerId)
+	}
+	to, err := uuid.Parse(spec.ToPlayerId)
+	if err != nil {
+		return record, errors.Wrapf(err, "invalid player id %s", spec.ToPlayerId)
+	}
+	err = s.runStopped(func() error {
+		return s.accountManager.runExclusive(func() error {
+			listPaths := []string{s.config.WhitelistPath, s.config.OpsPath, s.config.UserCachePath}
+			return migratePlayerFiles(s.config.WorldPath, listPaths, spec, from, to, &record)
+		})
+	})
+	if err != nil {
+		record.Error = err.Error()
+	}
+	auditErr := appendMigrationRecord(s.config.MigrationsLogPath, record)
+	if auditErr != nil {
+		s.logger.Error("cannot write migration audit log", zap.Error(auditErr))
+	}
+	return record, err
+}
@@ -0,0 +1,190 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+)
+
+func TestMigratePlayerData(t *testing.T) {
+	worldPath := t.TempDir()
+	from := mojang.GetOfflineUuid("Steve")
+	to := mojang.GetOfflineUuid("Alex")
+	for _, dir := range []string{"playerdata", "stats", "advancements"} {
+		err := os.Mkdir(filepath.Join(worldPath, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources := playerDataFiles(worldPath, from)
+	// no .dat_old
+	for _, path := range []string{sources[0], sources[2], sources[3]} {
+		err := os.WriteFile(path, []byte(path), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := MigratePlayerData(worldPath, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 3 {
+		t.Fatalf("Wrong moved files: %v", moved)
+	}
+	targets := playerDataFiles(worldPath, to)
+	contents, err := os.ReadFile(targets[2])
+	if err != nil || string(contents) != sources[2] {
+		t.Fatalf("Stats were not moved: %s %v", contents, err)
+	}
+	if _, err := os.Stat(sources[0]); !os.IsNotExist(err) {
+		t.Fatalf("Source player data should be gone: %v", err)
+	}
+
+	// moving back over existing data must not touch anything
+	err = os.WriteFile(sources[3], []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = MigratePlayerData(worldPath, to, from)
+	if !errors.Is(err, ErrorPlayerDataExists{}) {
+		t.Fatalf("Expected ErrorPlayerDataExists, got %v", err)
+	}
+	if _, err := os.Stat(targets[0]); err != nil {
+		t.Fatalf("Player data should stay in place: %v", err)
+	}
+}
+
+func TestRewritePlayerListUuid(t *testing.T) {
+	dir := t.TempDir()
+	from := mojang.GetOfflineUuid("Steve")
+	to := mojang.GetOfflineUuid("Alex")
+	other := mojang.GetOfflineUuid("Notch")
+	opsPath := filepath.Join(dir, "ops.json")
+	err := os.WriteFile(opsPath, []byte(`[`+
+		`{"uuid":"`+from.String()+`","name":"Steve","level":4,"bypassesPlayerLimit":true},`+
+		`{"uuid":"`+other.String()+`","name":"Notch","level":2,"bypassesPlayerLimit":false}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := rewritePlayerListUuid(opsPath, from, to, "Alex")
+	if err != nil || !changed {
+		t.Fatalf("Ops should be rewritten: %v %v", changed, err)
+	}
+	var ops []opsEntry
+	content, _ := os.ReadFile(opsPath)
+	err = json.Unmarshal(content, &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []opsEntry{
+		{Uuid: to.String(), Name: "Alex", Level: 4, BypassesPlayerLimit: true},
+		{Uuid: other.String(), Name: "Notch", Level: 2},
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("Wrong ops: %v", ops)
+	}
+
+	// old entry is dropped when the new id is already listed
+	changed, err = rewritePlayerListUuid(opsPath, other, to, "")
+	if err != nil || !changed {
+		t.Fatalf("Ops should be rewritten: %v %v", changed, err)
+	}
+	content, _ = os.ReadFile(opsPath)
+	ops = nil
+	json.Unmarshal(content, &ops)
+	if !reflect.DeepEqual(ops, expected[:1]) {
+		t.Fatalf("Wrong ops: %v", ops)
+	}
+
+	changed, err = rewritePlayerListUuid(filepath.Join(dir, "missing.json"), from, to, "")
+	if err != nil || changed {
+		t.Fatalf("Missing list should be skipped: %v %v", changed, err)
+	}
+}
+
+func TestMigratePlayerFilesRollsBack(t *testing.T) {
+	worldPath := t.TempDir()
+	listsPath := t.TempDir()
+	from := mojang.GetOfflineUuid("Steve")
+	to := mojang.GetOfflineUuid("Alex")
+	for _, dir := range []string{"playerdata", "stats", "advancements"} {
+		err := os.Mkdir(filepath.Join(worldPath, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources := playerDataFiles(worldPath, from)
+	targets := playerDataFiles(worldPath, to)
+	for _, path := range []string{sources[0], sources[2], sources[3]} {
+		err := os.WriteFile(path, []byte(path), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	whitelistPath := filepath.Join(listsPath, "whitelist.json")
+	whitelist := `[{"uuid":"` + from.String() + `","name":"Steve"}]`
+	opsPath := filepath.Join(listsPath, "ops.json")
+	err := os.WriteFile(whitelistPath, []byte(whitelist), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listPaths := []string{whitelistPath, opsPath}
+	spec := PlayerDataMigrationSpec{FromPlayerId: from.String(), ToName: "Alex", ToPlayerId: to.String()}
+	assertRestored := func(record PlayerMigrationRecord) {
+		t.Helper()
+		if !record.RolledBack || record.RollbackError != "" {
+			t.Fatalf("Rollback is not recorded: %+v", record)
+		}
+		for _, path := range []string{sources[0], sources[2], sources[3]} {
+			contents, err := os.ReadFile(path)
+			if err != nil || string(contents) != path {
+				t.Fatalf("Player data %s is not restored: %v", path, err)
+			}
+		}
+		for _, path := range targets {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("Moved file %s is left behind", path)
+			}
+		}
+		contents, err := os.ReadFile(whitelistPath)
+		if err != nil || string(contents) != whitelist {
+			t.Fatalf("Whitelist is not restored: %s %v", contents, err)
+		}
+	}
+
+	// the last file can't be moved
+	renames := 0
+	renameFile = func(source string, target string) error {
+		renames++
+		if renames == 3 {
+			return errors.New("disk failure")
+		}
+		return os.Rename(source, target)
+	}
+	defer func() { renameFile = os.Rename }()
+	record := PlayerMigrationRecord{}
+	err = migratePlayerFiles(worldPath, listPaths, spec, from, to, &record)
+	if err == nil || len(record.MovedFiles) != 2 {
+		t.Fatalf("Expected a failure after two moved files, got %v %+v", err, record)
+	}
+	assertRestored(record)
+	renameFile = os.Rename
+
+	// files are moved and the whitelist is rewritten, but ops.json is broken
+	err = os.WriteFile(opsPath, []byte("not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record = PlayerMigrationRecord{}
+	err = migratePlayerFiles(worldPath, listPaths, spec, from, to, &record)
+	if err == nil || len(record.MovedFiles) != 3 || len(record.RewrittenLists) != 1 {
+		t.Fatalf("Expected a failure on ops.json, got %v %+v", err, record)
+	}
+	assertRestored(record)
+}
@@ -0,0 +1,124 @@
+package mcserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/nbt"
+	"github.com/pkg/errors"
+)
+
+type ErrorNoPlayerData struct {
+	PlayerId string
+}
+
+func (e ErrorNoPlayerData) Error() string {
+	return fmt.Sprintf("no player data for %s", e.PlayerId)
+}
+
+func (e ErrorNoPlayerData) Is(target error) bool {
+	_, ok := target.(ErrorNoPlayerData)
+	return ok
+}
+
+type PlayerInfoRequest struct {
+	PlayerId string `json:"player_id"`
+}
+
+type InventoryItem struct {
+	Id    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+// summary of world/playerdata/<uuid>.dat, as of the last save of the player
+type PlayerInfo struct {
+	PlayerId  string     `json:"player_id"`
+	Position  [3]float64 `json:"position"`
+	Dimension string     `json:"dimension"`
+	Health    float32    `json:"health"`
+	XpLevel   int32      `json:"xp_level"`
+	GameMode  string     `json:"game_mode"`
+	// total counts by item id, most numerous first
+	Inventory  []InventoryItem `json:"inventory"`
+	EnderChest []InventoryItem `json:"ender_chest"`
+}
+
+var gameModes = map[int32]string{
+	0: "survival",
+	1: "creative",
+	2: "adventure",
+	3: "spectator",
+}
+
+func ReadPlayerInfo(worldPath string, playerId uuid.UUID) (*PlayerInfo, error) {
+	path := filepath.Join(worldPath, "playerdata", playerId.String()+".dat")
+	data, err := nbt.ReadGzipFile(path)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, ErrorNoPlayerData{PlayerId: playerId.String()}
+		}
+		return nil, err
+	}
+	return parsePlayerInfo(playerId, data), nil
+}
+
+func parsePlayerInfo(playerId uuid.UUID, data nbt.Compound) *PlayerInfo {
+	info := &PlayerInfo{PlayerId: playerId.String()}
+	if pos, ok := data.List("Pos"); ok && len(pos) == 3 {
+		for i := range pos {
+			info.Position[i], _ = pos[i].(float64)
+		}
+	}
+	if dimension, ok := data.String("Dimension"); ok {
+		info.Dimension = dimension
+	} else if dimension, ok := data.Int("Dimension"); ok {
+		// numeric before 1.16
+		info.Dimension = map[int32]string{-1: "minecraft:the_nether", 0: "minecraft:overworld", 1: "minecraft:the_end"}[dimension]
+	}
+	info.Health, _ = data.Float("Health")
+	info.XpLevel, _ = data.Int("XpLevel")
+	if gameType, ok := data.Int("playerGameType"); ok {
+		info.GameMode = gameModes[gameType]
+	}
+	inventory, _ := data.List("Inventory")
+	info.Inventory = summarizeItems(inventory)
+	enderChest, _ := data.List("EnderItems")
+	info.EnderChest = summarizeItems(enderChest)
+	return info
+}
+
+func summarizeItems(items nbt.List) []InventoryItem {
+	counts := map[string]int{}
+	for _, item := range items {
+		compound, ok := item.(nbt.Compound)
+		if !ok {
+			continue
+		}
+		id, ok := compound.String("id")
+		if !ok {
+			continue
+		}
+		if count, ok := compound.Int("count"); ok {
+			// since 1.20.5
+			counts[id] += int(count)
+		} else if count, ok := compound.Byte("Count"); ok {
+			counts[id] += int(count)
+		} else {
+			counts[id]++
+		}
+	}
+	summary := make([]InventoryItem, 0, len(counts))
+	for id, count := range counts {
+		summary = append(summary, InventoryItem{Id: id, Count: count})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Count != summary[j].Count {
+			return summary[i].Count > summary[j].Count
+		}
+		return summary[i].Id < summary[j].Id
+	})
+	return summary
+}
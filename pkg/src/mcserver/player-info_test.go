@@ -0,0 +1,45 @@
+package mcserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/nbt"
+)
+
+func TestParsePlayerInfo(t *testing.T) {
+	playerId := uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	data := nbt.Compound{
+		"Pos":            nbt.List{1.5, 64.0, -20.25},
+		"Dimension":      "minecraft:the_nether",
+		"Health":         float32(18.5),
+		"XpLevel":        int32(30),
+		"playerGameType": int32(0),
+		"Inventory": nbt.List{
+			nbt.Compound{"Slot": int8(0), "id": "minecraft:cobblestone", "count": int32(64)},
+			nbt.Compound{"Slot": int8(1), "id": "minecraft:cobblestone", "count": int32(10)},
+			nbt.Compound{"Slot": int8(2), "id": "minecraft:diamond_pickaxe", "count": int32(1)},
+			// before 1.20.5
+			nbt.Compound{"Slot": int8(3), "id": "minecraft:torch", "Count": int8(16)},
+		},
+	}
+	info := parsePlayerInfo(playerId, data)
+	expected := &PlayerInfo{
+		PlayerId:  playerId.String(),
+		Position:  [3]float64{1.5, 64, -20.25},
+		Dimension: "minecraft:the_nether",
+		Health:    18.5,
+		XpLevel:   30,
+		GameMode:  "survival",
+		Inventory: []InventoryItem{
+			{Id: "minecraft:cobblestone", Count: 74},
+			{Id: "minecraft:torch", Count: 16},
+			{Id: "minecraft:diamond_pickaxe", Count: 1},
+		},
+		EnderChest: []InventoryItem{},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("Wrong player info:\n%+v\nexpected\n%+v", info, expected)
+	}
+}
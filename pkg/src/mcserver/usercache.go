@@ -0,0 +1,32 @@
+package mcserver
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/pkg/errors"
+)
+
+// entry of usercache.json, the server records every player who joined
+type UserCacheEntry struct {
+	Name      mojang.MinecraftLogin `json:"name"`
+	Uuid      string                `json:"uuid"`
+	ExpiresOn string                `json:"expiresOn"`
+}
+
+func readUserCache(path string) ([]UserCacheEntry, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []UserCacheEntry{}, nil
+		}
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	var entries []UserCacheEntry
+	err = json.Unmarshal(contents, &entries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	return entries, nil
+}
@@ -0,0 +1,195 @@
+package modconfigs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+type ConfigFile struct {
+	// text/template file, relative to the manifest
+	Template string `json:"template"`
+	// relative to the game directory, e.g. config/mod.json
+	Target string `json:"target"`
+	Server bool   `json:"server"`
+	// shipped in the client modpack
+	Client bool `json:"client"`
+}
+
+type Manifest struct {
+	// default values of template variables, the overseer config can override them
+	Variables map[string]string `json:"variables"`
+	Files     []ConfigFile      `json:"files"`
+	dir       string
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	manifest := &Manifest{dir: filepath.Dir(path)}
+	err = json.Unmarshal(contents, manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	for _, file := range manifest.Files {
+		if file.Template == "" || file.Target == "" {
+			return nil, errors.Errorf("config file must have template and target in %s", path)
+		}
+		if !filepath.IsLocal(file.Target) {
+			return nil, errors.Errorf("config target %s must be inside the game directory", file.Target)
+		}
+	}
+	return manifest, nil
+}
+
+// Parses every template so that mistakes are found before the server starts
+func (m *Manifest) Check() error {
+	for _, file := range m.Files {
+		_, err := m.parse(file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) parse(file ConfigFile) (*template.Template, error) {
+	templatePath := filepath.Join(m.dir, file.Template)
+	contents, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read template %s", templatePath)
+	}
+	tmpl, err := template.New(file.Template).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse template %s", templatePath)
+	}
+	return tmpl, nil
+}
+
+// Renders the file with the manifest variables overridden by the given ones
+func (m *Manifest) Render(file ConfigFile, overrides map[string]string) ([]byte, error) {
+	tmpl, err := m.parse(file)
+	if err != nil {
+		return nil, err
+	}
+	variables := make(map[string]string, len(m.Variables)+len(overrides))
+	for k, v := range m.Variables {
+		variables[k] = v
+	}
+	for k, v := range overrides {
+		variables[k] = v
+	}
+	buffer := &bytes.Buffer{}
+	err = tmpl.Execute(buffer, variables)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot render %s", file.Template)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Config file changed since it was last rendered, usually by the mod itself
+type DriftedFile struct {
+	Target string
+	// the changed file is kept there before it is overwritten
+	BackupPath string
+}
+
+// hashes of rendered contents by target
+type renderState map[string]string
+
+func readState(path string) (renderState, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return renderState{}, nil
+		}
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	state := renderState{}
+	err = json.Unmarshal(contents, &state)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	return state, nil
+}
+
+func hashContents(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+// Renders server config files into gameDir. Files changed since the previous
+// render are reported as drifted and backed up next to the target
+func (m *Manifest) ApplyServer(gameDir string, statePath string, overrides map[string]string) ([]DriftedFile, error) {
+	state, err := readState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	drifted := []DriftedFile{}
+	for _, file := range m.Files {
+		if !file.Server {
+			continue
+		}
+		contents, err := m.Render(file, overrides)
+		if err != nil {
+			return drifted, err
+		}
+		targetPath := filepath.Join(gameDir, file.Target)
+		existing, err := os.ReadFile(targetPath)
+		if err != nil && !os.IsNotExist(err) {
+			return drifted, errors.Wrapf(err, "cannot read %s", targetPath)
+		}
+		// files without state were generated by the mods on the first start
+		rendered, ok := state[file.Target]
+		if err == nil && ok && hashContents(existing) != rendered {
+			backupPath := targetPath + ".drifted"
+			err = os.WriteFile(backupPath, existing, 0664)
+			if err != nil {
+				return drifted, errors.Wrapf(err, "cannot write %s", backupPath)
+			}
+			drifted = append(drifted, DriftedFile{Target: file.Target, BackupPath: backupPath})
+		}
+		err = os.MkdirAll(filepath.Dir(targetPath), 0775)
+		if err != nil {
+			return drifted, errors.Wrapf(err, "cannot create directory for %s", targetPath)
+		}
+		err = os.WriteFile(targetPath, contents, 0664)
+		if err != nil {
+			return drifted, errors.Wrapf(err, "cannot write %s", targetPath)
+		}
+		state[file.Target] = hashContents(contents)
+		stateJson, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return drifted, err
+		}
+		err = os.WriteFile(statePath, stateJson, 0664)
+		if err != nil {
+			return drifted, errors.Wrapf(err, "cannot write %s", statePath)
+		}
+	}
+	return drifted, nil
+}
+
+// Rendered client config files by target
+func (m *Manifest) RenderClient() (map[string][]byte, error) {
+	rendered := map[string][]byte{}
+	for _, file := range m.Files {
+		if !file.Client {
+			continue
+		}
+		contents, err := m.Render(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		rendered[file.Target] = contents
+	}
+	return rendered, nil
+}
@@ -0,0 +1,106 @@
+package modconfigs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyServerDetectsDrift(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "mod-configs", "mod-configs.json")
+	writeTestFile(t, manifestPath, `{
+		"variables": {"motd": "hello", "limit": "10"},
+		"files": [
+			{"template": "server.json", "target": "config/server.json", "server": true},
+			{"template": "client.json", "target": "config/client.json", "client": true}
+		]
+	}`)
+	writeTestFile(t, filepath.Join(dir, "mod-configs", "server.json"), `{"motd": "{{ .motd }}", "limit": {{ .limit }}}`)
+	writeTestFile(t, filepath.Join(dir, "mod-configs", "client.json"), `{"motd": "{{ .motd }}"}`)
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Check(); err != nil {
+		t.Fatal(err)
+	}
+	gameDir := filepath.Join(dir, "game")
+	targetPath := filepath.Join(gameDir, "config", "server.json")
+	statePath := filepath.Join(dir, "state.json")
+	// generated by the mod on the first start
+	writeTestFile(t, targetPath, `{"generated": true}`)
+
+	drifted, err := manifest.ApplyServer(gameDir, statePath, map[string]string{"limit": "20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifted) != 0 {
+		t.Fatalf("Generated file should not be reported as drifted: %v", drifted)
+	}
+	contents, _ := os.ReadFile(targetPath)
+	if string(contents) != `{"motd": "hello", "limit": 20}` {
+		t.Fatalf("Unexpected rendered config %s", contents)
+	}
+	if _, err := os.Stat(filepath.Join(gameDir, "config", "client.json")); !os.IsNotExist(err) {
+		t.Fatal("Client config should not be rendered on server")
+	}
+
+	drifted, err = manifest.ApplyServer(gameDir, statePath, map[string]string{"limit": "20"})
+	if err != nil || len(drifted) != 0 {
+		t.Fatalf("Unchanged config should not drift: %v %v", drifted, err)
+	}
+
+	writeTestFile(t, targetPath, `{"motd": "hello", "limit": 20, "added": 1}`)
+	drifted, err = manifest.ApplyServer(gameDir, statePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifted) != 1 || drifted[0].Target != "config/server.json" {
+		t.Fatalf("Expected drifted server config, got %v", drifted)
+	}
+	backup, _ := os.ReadFile(drifted[0].BackupPath)
+	contents, _ = os.ReadFile(targetPath)
+	if string(backup) != `{"motd": "hello", "limit": 20, "added": 1}` || string(contents) != `{"motd": "hello", "limit": 10}` {
+		t.Fatalf("Unexpected backup %s and config %s", backup, contents)
+	}
+
+	client, err := manifest.RenderClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client) != 1 || string(client["config/client.json"]) != `{"motd": "hello"}` {
+		t.Fatalf("Unexpected client configs %v", client)
+	}
+}
+
+func TestRenderMissingVariable(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "mod-configs.json")
+	writeTestFile(t, manifestPath, `{"files": [{"template": "a.json", "target": "config/a.json", "server": true}]}`)
+	writeTestFile(t, filepath.Join(dir, "a.json"), `{{ .missing }}`)
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = manifest.Render(manifest.Files[0], nil)
+	if err == nil {
+		t.Fatal("Missing variable should fail rendering")
+	}
+
+	writeTestFile(t, manifestPath, `{"files": [{"template": "a.json", "target": "../a.json", "server": true}]}`)
+	if _, err := ReadManifest(manifestPath); err == nil {
+		t.Fatal("Target outside of the game directory should be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package modsindex
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const IndexFile = "index.json"
+
+var indexPage = template.Must(template.New("mods").Funcs(template.FuncMap{
+	"size":  formatSize,
+	"short": func(hash string) string { return hash[:min(len(hash), 12)] },
+	"side": func(side string) string {
+		switch side {
+		case SideRequired:
+			return "обязателен"
+		case SideOptional:
+			return "по желанию"
+		default:
+			return "не нужен"
+		}
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Моды сервера</title>
+    <style>
+        body { font-family: sans-serif; margin: 1rem; }
+        table { border-collapse: collapse; }
+        th, td { padding: 0.3rem 0.6rem; border-bottom: 1px solid #ddd; text-align: left; }
+        code { font-size: 0.85em; }
+    </style>
+</head>
+<body>
+    <h1>Моды сервера</h1>
+    <p>Майнкрафт {{.GameVersion}}{{if .LoaderVersion}}, fabric loader {{.LoaderVersion}}{{end}}.</p>
+    <ul>
+        {{- if .Modpack}}
+        <li><a href="{{.Modpack}}" download>Модпак</a> для Prism Launcher и Modrinth App</li>
+        {{- end}}
+        {{- if .Archive}}
+        <li><a href="{{.Archive}}" download>Архив с модами</a> для папки <code>mods</code></li>
+        {{- end}}
+        <li><a href="` + IndexFile + `">Список модов в JSON</a></li>
+    </ul>
+    <table>
+        <tr><th>Мод</th><th>Версия</th><th>На клиенте</th><th>Размер</th><th>SHA-1</th><th></th></tr>
+        {{- range .Mods}}
+        <tr>
+            <td>{{if .Path}}<a href="{{.Path}}" download>{{or .Name .File}}</a>{{else}}{{or .Name .File}}{{end}}</td>
+            <td>{{.Version}}</td>
+            <td>{{side .Client}}</td>
+            <td>{{size .Size}}</td>
+            <td><code title="SHA-512: {{.Sha512}}">{{short .Sha1}}</code></td>
+            <td>{{if .ModrinthUrl}}<a href="{{.ModrinthUrl}}">Modrinth</a>{{end}}</td>
+        </tr>
+        {{- end}}
+    </table>
+</body>
+</html>
+`))
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f КБ", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d Б", size)
+	}
+}
+
+type fileEtag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// Serves the client directory with a download page generated from its index.
+// Files are served with ETags and ranges so that downloads can be resumed
+type Handler struct {
+	dir        string
+	fileServer http.Handler
+	logger     *zap.Logger
+
+	mu    sync.Mutex
+	etags map[string]fileEtag
+}
+
+func NewHandler(dir string, logger *zap.Logger) *Handler {
+	return &Handler{
+		dir:        dir,
+		fileServer: http.FileServer(http.Dir(dir)),
+		logger:     logger,
+		etags:      map[string]fileEtag{},
+	}
+}
+
+// expects the path relative to the directory, the prefix must be stripped
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if name == "/" {
+		h.serveIndexPage(w, r)
+		return
+	}
+	file, err := os.Open(filepath.Join(h.dir, filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		h.logger.Error("cannot open file", zap.String("name", name), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		h.logger.Error("cannot stat file", zap.String("name", name), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if stat.IsDir() {
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+	etag, err := h.etag(name, file, stat)
+	if err != nil {
+		h.logger.Error("cannot hash file", zap.String("name", name), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if strings.HasSuffix(name, ".mrpack") {
+		// launchers recognize modpacks by the content type
+		w.Header().Set("Content-Type", "application/x-modrinth-modpack+zip")
+	}
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+}
+
+// strong etag from the contents, cached until the file changes
+func (h *Handler) etag(name string, file *os.File, stat os.FileInfo) (string, error) {
+	h.mu.Lock()
+	cached, ok := h.etags[name]
+	h.mu.Unlock()
+	if ok && cached.size == stat.Size() && cached.modTime.Equal(stat.ModTime()) {
+		return cached.etag, nil
+	}
+	hash := sha1.New()
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+	h.mu.Lock()
+	h.etags[name] = fileEtag{size: stat.Size(), modTime: stat.ModTime(), etag: etag}
+	h.mu.Unlock()
+	return etag, nil
+}
+
+// falls back to the directory listing if there is no index
+func (h *Handler) serveIndexPage(w http.ResponseWriter, r *http.Request) {
+	indexPath := filepath.Join(h.dir, IndexFile)
+	stat, err := os.Stat(indexPath)
+	if os.IsNotExist(err) {
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+	index, err := Read(indexPath)
+	if err != nil {
+		h.logger.Error("cannot read mods index", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	page := &bytes.Buffer{}
+	err = indexPage.Execute(page, index)
+	if err != nil {
+		h.logger.Error("cannot render mods index", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hash := sha1.Sum(page.Bytes())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:])+`"`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", stat.ModTime(), bytes.NewReader(page.Bytes()))
+}
@@ -0,0 +1,96 @@
+package modsindex
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func get(t *testing.T, handler http.Handler, target string, headers map[string]string) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandlerServesIndexAndResumableFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "mods"), 0755)
+	os.WriteFile(filepath.Join(dir, "mods", "api.jar"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(dir, "subchat.mrpack"), []byte("pack"), 0644)
+	handler := NewHandler(dir, zap.NewNop())
+
+	// directory listing until modssetup writes the index
+	resp := get(t, handler, "/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(readBody(t, resp), "subchat.mrpack") {
+		t.Fatal("Expected directory listing")
+	}
+
+	err := Write(filepath.Join(dir, IndexFile), &Index{
+		GameVersion: "1.21.4",
+		Archive:     "mods.zip",
+		Modpack:     "subchat.mrpack",
+		Mods: []Mod{
+			{File: "api.jar", Name: "Fabric <API>", Version: "0.116.1", Client: SideRequired, Size: 10,
+				Sha1: "87acec17cd9dcd20a716cc2cf67417b71c8a7016", Path: "mods/api.jar",
+				ModrinthUrl: "https://modrinth.com/mod/fabric-api"},
+			{File: "auth.jar", Client: SideUnsupported, Size: 3 << 20},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = get(t, handler, "/", nil)
+	page := readBody(t, resp)
+	for _, expected := range []string{"Fabric &lt;API&gt;", `href="mods/api.jar"`, `href="subchat.mrpack"`,
+		`href="mods.zip"`, "https://modrinth.com/mod/fabric-api", "87acec17cd9d", "обязателен", "3.0 МБ"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Index page does not contain %s:\n%s", expected, page)
+		}
+	}
+	pageEtag := resp.Header.Get("ETag")
+	resp = get(t, handler, "/", map[string]string{"If-None-Match": pageEtag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected not modified page, got %d", resp.StatusCode)
+	}
+
+	resp = get(t, handler, "/mods/api.jar", nil)
+	etag := resp.Header.Get("ETag")
+	if etag != `"87acec17cd9dcd20a716cc2cf67417b71c8a7016"` || readBody(t, resp) != "0123456789" {
+		t.Fatalf("Unexpected file response %s", etag)
+	}
+	resp = get(t, handler, "/mods/api.jar", map[string]string{"Range": "bytes=4-", "If-Range": etag})
+	if resp.StatusCode != http.StatusPartialContent || readBody(t, resp) != "456789" {
+		t.Fatalf("Expected resumed download, got %d", resp.StatusCode)
+	}
+	resp = get(t, handler, "/mods/api.jar", map[string]string{"Range": "bytes=4-", "If-Range": `"stale"`})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Changed file should be downloaded from the start, got %d", resp.StatusCode)
+	}
+
+	resp = get(t, handler, "/subchat.mrpack", nil)
+	if resp.Header.Get("Content-Type") != "application/x-modrinth-modpack+zip" {
+		t.Errorf("Unexpected modpack content type %s", resp.Header.Get("Content-Type"))
+	}
+	resp = get(t, handler, "/../../etc/passwd", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found outside of the directory, got %d", resp.StatusCode)
+	}
+}
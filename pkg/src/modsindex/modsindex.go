@@ -0,0 +1,65 @@
+package modsindex
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// values of the client and server sides of a mod, same as in modrinth modpacks
+const (
+	SideRequired    = "required"
+	SideOptional    = "optional"
+	SideUnsupported = "unsupported"
+)
+
+type Mod struct {
+	File    string `json:"file"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Client  string `json:"client"`
+	Server  string `json:"server"`
+	Size    int64  `json:"size"`
+	Sha1    string `json:"sha1"`
+	Sha512  string `json:"sha512"`
+	// relative to the index, empty if the mod is not downloadable from the server
+	Path string `json:"path"`
+	// modrinth project page, empty for mods from elsewhere
+	ModrinthUrl string `json:"modrinth_url"`
+}
+
+// Written by modssetup into the client directory and served as the mods download page
+type Index struct {
+	GameVersion   string `json:"game_version"`
+	LoaderVersion string `json:"loader_version"`
+	// file names relative to the index, empty if missing
+	Archive string `json:"archive"`
+	Modpack string `json:"modpack"`
+	Mods    []Mod  `json:"mods"`
+}
+
+func Read(path string) (*Index, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	index := &Index{}
+	err = json.Unmarshal(contents, index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s", path)
+	}
+	return index, nil
+}
+
+func Write(path string, index *Index) error {
+	contents, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, contents, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "cannot write %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package mojang
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type ClientConfig struct {
+	BaseUrl          string        `yaml:"base_url"`
+	SessionServerUrl string        `yaml:"session_server_url"`
+	RequestTimeout   time.Duration `yaml:"request_timeout"`
+	PositiveCacheTtl time.Duration `yaml:"positive_cache_ttl"`
+	NegativeCacheTtl time.Duration `yaml:"negative_cache_ttl"`
+	// mojang allows about 600 requests per 10 minutes
+	RequestsPerSecond float64       `yaml:"requests_per_second"`
+	RequestsBurst     int           `yaml:"requests_burst"`
+	MaxRetries        int           `yaml:"max_retries"`
+	InitialBackoff    time.Duration `yaml:"initial_backoff"`
+	MaxBackoff        time.Duration `yaml:"max_backoff"`
+}
+
+var DefaultClientConfig = ClientConfig{
+	BaseUrl:           "https://api.mojang.com",
+	SessionServerUrl:  "https://sessionserver.mojang.com",
+	RequestTimeout:    10 * time.Second,
+	PositiveCacheTtl:  24 * time.Hour,
+	NegativeCacheTtl:  time.Hour,
+	RequestsPerSecond: 1,
+	RequestsBurst:     10,
+	MaxRetries:        3,
+	InitialBackoff:    time.Second,
+	MaxBackoff:        time.Minute,
+}
+
+type Profile struct {
+	Id   uuid.UUID
+	Name MinecraftLogin
+}
+
+type Client struct {
+	config     ClientConfig
+	httpClient *http.Client
+	cache      ProfileCache
+	limiter    *tokenBucket
+}
+
+// httpClient may be nil, then a client with config.RequestTimeout is used
+func NewClient(config ClientConfig, httpClient *http.Client, cache ProfileCache) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: config.RequestTimeout}
+	}
+	if cache == nil {
+		cache = NewMemoryProfileCache()
+	}
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+		cache:      cache,
+		limiter:    newTokenBucket(config.RequestsPerSecond, config.RequestsBurst),
+	}
+}
+
+var defaultClient = NewClient(DefaultClientConfig, nil, nil)
+
+func QueryOnlineUuid(login MinecraftLogin, ctx context.Context) (uuid.UUID, error) {
+	return defaultClient.QueryOnlineUuid(login, ctx)
+}
+
+type ErrorTooManyRequests struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrorTooManyRequests) Error() string {
+	return fmt.Sprintf("Mojang API rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+func (e ErrorTooManyRequests) Is(target error) bool {
+	_, ok := target.(ErrorTooManyRequests)
+	return ok
+}
+
+// error which makes sense to retry
+type retriableErr struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e retriableErr) Error() string {
+	return e.err.Error()
+}
+
+func (e retriableErr) Unwrap() error {
+	return e.err
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.config.InitialBackoff << attempt
+	if backoff > c.config.MaxBackoff || backoff <= 0 {
+		backoff = c.config.MaxBackoff
+	}
+	return backoff
+}
+
+// performs request with rate limiting, retries requests failed with retriableErr
+func (c *Client) do(ctx context.Context, makeRequest func() (*http.Request, error)) (*http.Response, []byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := c.backoff(attempt - 1)
+			var retryErr retriableErr
+			if errors.As(lastErr, &retryErr) && retryErr.retryAfter > wait {
+				wait = retryErr.retryAfter
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err := c.limiter.Wait(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		response, body, err := c.doOnce(makeRequest)
+		if err == nil {
+			return response, body, nil
+		}
+		if !errors.As(err, &retriableErr{}) {
+			return nil, nil, err
+		}
+		lastErr = err
+	}
+	return nil, nil, errors.Wrapf(lastErr, "failed after %d retries", c.config.MaxRetries)
+}
+
+func (c *Client) doOnce(makeRequest func() (*http.Request, error)) (*http.Response, []byte, error) {
+	request, err := makeRequest()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create request")
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		if request.Context().Err() != nil {
+			return nil, nil, request.Context().Err()
+		}
+		return nil, nil, retriableErr{err: errors.Wrap(err, "failed to perform request")}
+	}
+	defer response.Body.Close()
+	bodyBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, retriableErr{err: errors.Wrap(err, "failed to read response body")}
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		retryAfter := time.Duration(0)
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, nil, retriableErr{err: ErrorTooManyRequests{retryAfter}, retryAfter: retryAfter}
+	}
+	if response.StatusCode >= 500 {
+		return nil, nil, retriableErr{err: errors.Errorf("Mojang API returned %d", response.StatusCode)}
+	}
+	return response, bodyBytes, nil
+}
+
+func parsePlayerId(id string) (uuid.UUID, error) {
+	uuidBytes, err := hex.DecodeString(id)
+	if err != nil {
+		return uuid.UUID{}, errors.Wrap(err, "failed to decode player ID")
+	}
+	playerId, err := uuid.FromBytes(uuidBytes)
+	if err != nil {
+		return uuid.UUID{}, errors.Wrap(err, "failed to parse player ID")
+	}
+	return playerId, nil
+}
+
+type profileResponse struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (r profileResponse) toProfile() (Profile, error) {
+	id, err := parsePlayerId(r.Id)
+	if err != nil {
+		return Profile{}, err
+	}
+	return Profile{Id: id, Name: MinecraftLogin(r.Name)}, nil
+}
+
+func (c *Client) cachePut(login MinecraftLogin, profile Profile, found bool) {
+	ttl := c.config.NegativeCacheTtl
+	if found {
+		ttl = c.config.PositiveCacheTtl
+	}
+	// cache failures only make the next lookup slower
+	c.cache.Put(login, CachedProfile{
+		Profile:   profile,
+		Found:     found,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+}
+
+// returns NoSuchPlayerErr if there is no official account with this name
+func (c *Client) QueryProfile(login MinecraftLogin, ctx context.Context) (Profile, error) {
+	cached, err := c.cache.Get(login)
+	if err == nil && cached != nil {
+		if !cached.Found {
+			return Profile{}, NoSuchPlayerErr{login}
+		}
+		return cached.Profile, nil
+	}
+	response, bodyBytes, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/users/profiles/minecraft/%s", c.config.BaseUrl, url.PathEscape(string(login))), nil)
+	})
+	if err != nil {
+		return Profile{}, err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		c.cachePut(login, Profile{}, false)
+		return Profile{}, NoSuchPlayerErr{login}
+	}
+	if response.StatusCode == http.StatusNotFound {
+		var errNoPlayerBody struct {
+			Path  string `json:"path"`
+			Error string `json:"errorMessage"`
+		}
+		err = json.Unmarshal(bodyBytes, &errNoPlayerBody)
+		if err != nil {
+			return Profile{}, errors.Wrap(err, "failed to parse response body")
+		}
+		if errNoPlayerBody.Error == fmt.Sprintf("Couldn't find any profile with name %s", login) {
+			c.cachePut(login, Profile{}, false)
+			return Profile{}, NoSuchPlayerErr{login}
+		}
+		return Profile{}, errors.Errorf("Mojang API returned unexpected response: %v", errNoPlayerBody)
+	}
+	if response.StatusCode != http.StatusOK {
+		return Profile{}, errors.Errorf("Mojang API returned %d", response.StatusCode)
+	}
+	var result profileResponse
+	err = json.Unmarshal(bodyBytes, &result)
+	if err != nil {
+		return Profile{}, errors.Wrap(err, "failed to parse response body")
+	}
+	profile, err := result.toProfile()
+	if err != nil {
+		return Profile{}, err
+	}
+	c.cachePut(login, profile, true)
+	return profile, nil
+}
+
+func (c *Client) QueryOnlineUuid(login MinecraftLogin, ctx context.Context) (uuid.UUID, error) {
+	profile, err := c.QueryProfile(login, ctx)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return profile.Id, nil
+}
+
+// bulk profiles endpoint accepts at most this many names
+const bulkProfilesLimit = 10
+
+// resolves names in batches, names without official account are absent from the result.
+// Result is keyed by lower case name. Always queries mojang since it's used by periodic
+// checks, results are written to cache
+func (c *Client) QueryProfiles(logins []MinecraftLogin, ctx context.Context) (map[string]Profile, error) {
+	result := make(map[string]Profile, len(logins))
+	for start := 0; start < len(logins); start += bulkProfilesLimit {
+		batch := logins[start:min(start+bulkProfilesLimit, len(logins))]
+		profiles, err := c.queryProfilesBatch(batch, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, login := range batch {
+			profile, found := profiles[cacheKey(login)]
+			c.cachePut(login, profile, found)
+			if found {
+				result[cacheKey(login)] = profile
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) queryProfilesBatch(logins []MinecraftLogin, ctx context.Context) (map[string]Profile, error) {
+	body, err := json.Marshal(logins)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal names")
+	}
+	response, bodyBytes, err := c.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseUrl+"/profiles/minecraft", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		return request, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Mojang API returned %d", response.StatusCode)
+	}
+	var results []profileResponse
+	err = json.Unmarshal(bodyBytes, &results)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse response body")
+	}
+	profiles := make(map[string]Profile, len(results))
+	for _, result := range results {
+		profile, err := result.toProfile()
+		if err != nil {
+			return nil, err
+		}
+		profiles[cacheKey(profile.Name)] = profile
+	}
+	return profiles, nil
+}
+
+type NoSuchProfileErr struct {
+	Id uuid.UUID
+}
+
+func (e NoSuchProfileErr) Error() string {
+	return fmt.Sprintf("No such profile: %s", e.Id)
+}
+
+func (e NoSuchProfileErr) Is(target error) bool {
+	_, ok := target.(NoSuchProfileErr)
+	return ok
+}
+
+// returns current profile of the player, not cached since it's used to detect renames
+func (c *Client) QueryProfileById(id uuid.UUID, ctx context.Context) (Profile, error) {
+	response, bodyBytes, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/session/minecraft/profile/%s", c.config.SessionServerUrl, hex.EncodeToString(id[:])), nil)
+	})
+	if err != nil {
+		return Profile{}, err
+	}
+	if response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotFound {
+		return Profile{}, NoSuchProfileErr{id}
+	}
+	if response.StatusCode != http.StatusOK {
+		return Profile{}, errors.Errorf("Mojang API returned %d", response.StatusCode)
+	}
+	var result profileResponse
+	err = json.Unmarshal(bodyBytes, &result)
+	if err != nil {
+		return Profile{}, errors.Wrap(err, "failed to parse response body")
+	}
+	profile, err := result.toProfile()
+	if err != nil {
+		return Profile{}, err
+	}
+	c.cachePut(profile.Name, profile, true)
+	return profile, nil
+}
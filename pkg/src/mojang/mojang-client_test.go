@@ -0,0 +1,254 @@
+package mojang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const testPlayerId = "069a79f444e94726a5befca90e38aaf5"
+
+func testClientConfig(baseUrl string) ClientConfig {
+	config := DefaultClientConfig
+	config.BaseUrl = baseUrl
+	config.RequestsPerSecond = 1000
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = 10 * time.Millisecond
+	return config
+}
+
+// stand-in for api.mojang.com, counts requests per name
+func newMojangStandIn(t *testing.T, handler func(w http.ResponseWriter, name string, attempt int32)) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/users/profiles/minecraft/")
+		if !ok {
+			t.Errorf("unexpected path %s", r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler(w, name, requests.Add(1))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestQueryProfileFound(t *testing.T) {
+	server, requests := newMojangStandIn(t, func(w http.ResponseWriter, name string, attempt int32) {
+		w.Write([]byte(`{"id":"` + testPlayerId + `","name":"Notch"}`))
+	})
+	client := NewClient(testClientConfig(server.URL), nil, nil)
+	for i := 0; i < 2; i++ {
+		profile, err := client.QueryProfile("notch", context.Background())
+		if err != nil {
+			t.Fatalf("Failed to query profile: %v", err)
+		}
+		if profile.Name != "Notch" || profile.Id.String() != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+			t.Fatalf("Wrong profile: %v", profile)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Second lookup should be cached, got %d requests", requests.Load())
+	}
+}
+
+func TestQueryProfileNotFound(t *testing.T) {
+	server, requests := newMojangStandIn(t, func(w http.ResponseWriter, name string, attempt int32) {
+		if name == "nocontent" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"path":"/users/profiles/minecraft/` + name + `","errorMessage":"Couldn't find any profile with name ` + name + `"}`))
+	})
+	client := NewClient(testClientConfig(server.URL), nil, nil)
+	for _, login := range []MinecraftLogin{"nocontent", "notfound", "notfound"} {
+		_, err := client.QueryOnlineUuid(login, context.Background())
+		if !errors.Is(err, NoSuchPlayerErr{}) {
+			t.Fatalf("Expected no such player for %s, got %v", login, err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Negative lookup should be cached, got %d requests", requests.Load())
+	}
+}
+
+func TestQueryProfileRetriesTooManyRequests(t *testing.T) {
+	server, requests := newMojangStandIn(t, func(w http.ResponseWriter, name string, attempt int32) {
+		if attempt < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"id":"` + testPlayerId + `","name":"Notch"}`))
+	})
+	client := NewClient(testClientConfig(server.URL), nil, nil)
+	_, err := client.QueryOnlineUuid("Notch", context.Background())
+	if err != nil {
+		t.Fatalf("Failed to query after retries: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Expected 3 requests, got %d", requests.Load())
+	}
+}
+
+func TestQueryProfileGivesUpOnTooManyRequests(t *testing.T) {
+	server, requests := newMojangStandIn(t, func(w http.ResponseWriter, name string, attempt int32) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	config := testClientConfig(server.URL)
+	config.MaxRetries = 2
+	client := NewClient(config, nil, nil)
+	_, err := client.QueryOnlineUuid("Notch", context.Background())
+	if !errors.Is(err, ErrorTooManyRequests{}) {
+		t.Fatalf("Expected too many requests error, got %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Expected 3 requests, got %d", requests.Load())
+	}
+}
+
+func TestGormProfileCache(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	_, err = NewGormProfileCache(db)
+	if err == nil {
+		t.Fatalf("Cache should require the migrated table")
+	}
+	// stands in for the auth db migration
+	err = db.Migrator().CreateTable(&cachedProfileRow{})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	persistent, err := NewGormProfileCache(db)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	server, requests := newMojangStandIn(t, func(w http.ResponseWriter, name string, attempt int32) {
+		w.Write([]byte(`{"id":"` + testPlayerId + `","name":"Notch"}`))
+	})
+	client := NewClient(testClientConfig(server.URL), nil, NewLayeredProfileCache(persistent))
+	_, err = client.QueryOnlineUuid("Notch", context.Background())
+	if err != nil {
+		t.Fatalf("Failed to query profile: %v", err)
+	}
+	// fresh memory layer, same db
+	client = NewClient(testClientConfig(server.URL), nil, NewLayeredProfileCache(persistent))
+	playerId, err := client.QueryOnlineUuid("NOTCH", context.Background())
+	if err != nil {
+		t.Fatalf("Failed to query profile: %v", err)
+	}
+	if playerId.String() != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+		t.Fatalf("Wrong player id %s", playerId)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Lookup should be served from db, got %d requests", requests.Load())
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(100, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := bucket.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("Bucket should throttle after burst, took %s", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bucket = newTokenBucket(0.001, 1)
+	bucket.Wait(ctx)
+	if err := bucket.Wait(ctx); err == nil {
+		t.Fatalf("Wait should fail on cancelled context")
+	}
+}
+
+func TestQueryProfilesBatches(t *testing.T) {
+	batchSizes := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/profiles/minecraft" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var names []string
+		err := json.NewDecoder(r.Body).Decode(&names)
+		if err != nil {
+			t.Errorf("cannot decode names: %v", err)
+		}
+		batchSizes = append(batchSizes, len(names))
+		results := []profileResponse{}
+		for _, name := range names {
+			if strings.HasPrefix(name, "official") {
+				results = append(results, profileResponse{Id: testPlayerId, Name: name})
+			}
+		}
+		json.NewEncoder(w).Encode(results)
+	}))
+	t.Cleanup(server.Close)
+	client := NewClient(testClientConfig(server.URL), nil, nil)
+	logins := []MinecraftLogin{}
+	for i := 0; i < 12; i++ {
+		logins = append(logins, MinecraftLogin(fmt.Sprintf("official%d", i)))
+	}
+	logins = append(logins, "cracked")
+	profiles, err := client.QueryProfiles(logins, context.Background())
+	if err != nil {
+		t.Fatalf("Failed to query profiles: %v", err)
+	}
+	if len(profiles) != 12 {
+		t.Fatalf("Expected 12 profiles, got %d", len(profiles))
+	}
+	if len(batchSizes) != 2 || batchSizes[0] != 10 || batchSizes[1] != 3 {
+		t.Fatalf("Wrong batches %v", batchSizes)
+	}
+	_, err = client.QueryProfile("cracked", context.Background())
+	if !errors.Is(err, NoSuchPlayerErr{}) {
+		t.Fatalf("Missing name should be cached as not found, got %v", err)
+	}
+}
+
+func TestQueryProfileById(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strings.CutPrefix(r.URL.Path, "/session/minecraft/profile/")
+		if id != testPlayerId {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"id":"` + testPlayerId + `","name":"Renamed"}`))
+	}))
+	t.Cleanup(server.Close)
+	config := testClientConfig(server.URL)
+	config.SessionServerUrl = server.URL
+	client := NewClient(config, nil, nil)
+	playerId, err := parsePlayerId(testPlayerId)
+	if err != nil {
+		t.Fatalf("Failed to parse player id: %v", err)
+	}
+	profile, err := client.QueryProfileById(playerId, context.Background())
+	if err != nil {
+		t.Fatalf("Failed to query profile: %v", err)
+	}
+	if profile.Name != "Renamed" {
+		t.Fatalf("Wrong name %s", profile.Name)
+	}
+	_, err = client.QueryProfileById(GetOfflineUuid("Renamed"), context.Background())
+	if !errors.Is(err, NoSuchProfileErr{}) {
+		t.Fatalf("Expected no such profile, got %v", err)
+	}
+}
@@ -1,17 +1,11 @@
 package mojang
 
 import (
-	"context"
 	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"regexp"
 
 	"github.com/google/uuid"
-	"github.com/pkg/errors"
 )
 
 type MinecraftLogin string
@@ -51,54 +45,6 @@ func (e NoSuchPlayerErr) Is(target error) bool {
 	return ok
 }
 
-func QueryOnlineUuid(login MinecraftLogin, ctx context.Context) (uuid.UUID, error) {
-	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://api.mojang.com/users/profiles/minecraft/%s", login), nil)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to create request")
-	}
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to perform request")
-	}
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to read response body")
-	}
-	if response.StatusCode == 404 {
-		var errNoPlayerBody struct {
-			Path  string `json:"path"`
-			Error string `json:"errorMessage"`
-		}
-		err = json.Unmarshal(bodyBytes, &errNoPlayerBody)
-		if err != nil {
-			return uuid.UUID{}, errors.Wrap(err, "failed to parse response body")
-		}
-		if errNoPlayerBody.Error == fmt.Sprintf("Couldn't find any profile with name %s", login) {
-			return uuid.UUID{}, NoSuchPlayerErr{login}
-		}
-		return uuid.UUID{}, errors.Errorf("Mojang API returned unexpected response: %v", errNoPlayerBody)
-	}
-	if response.StatusCode != 200 {
-		return uuid.UUID{}, errors.Errorf("Mojang API returned %d", response.StatusCode)
-	}
-	var result struct {
-		Id string `json:"id"`
-	}
-	err = json.Unmarshal(bodyBytes, &result)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to parse response body")
-	}
-	uuidBytes, err := hex.DecodeString(result.Id)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to decode player ID")
-	}
-	id, err := uuid.FromBytes(uuidBytes)
-	if err != nil {
-		return uuid.UUID{}, errors.Wrap(err, "failed to parse player ID")
-	}
-	return id, nil
-}
-
 func GetOfflineUuid(login MinecraftLogin) uuid.UUID {
 	stringToHash := "OfflinePlayer:" + string(login)
 	// get md5 hash of stringToHash
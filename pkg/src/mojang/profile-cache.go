@@ -0,0 +1,159 @@
+package mojang
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// cached lookup result, Found is false for names without official account
+type CachedProfile struct {
+	Profile   Profile
+	Found     bool
+	ExpiresAt time.Time
+}
+
+type ProfileCache interface {
+	// returns nil if there is no unexpired entry
+	Get(login MinecraftLogin) (*CachedProfile, error)
+	Put(login MinecraftLogin, profile CachedProfile) error
+}
+
+// names are case insensitive
+func cacheKey(login MinecraftLogin) string {
+	return strings.ToLower(string(login))
+}
+
+type MemoryProfileCache struct {
+	entries map[string]CachedProfile
+	mu      *sync.Mutex
+}
+
+func NewMemoryProfileCache() *MemoryProfileCache {
+	return &MemoryProfileCache{
+		entries: make(map[string]CachedProfile),
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (cache *MemoryProfileCache) Get(login MinecraftLogin) (*CachedProfile, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[cacheKey(login)]
+	if !ok {
+		return nil, nil
+	}
+	if entry.ExpiresAt.Before(time.Now()) {
+		delete(cache.entries, cacheKey(login))
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (cache *MemoryProfileCache) Put(login MinecraftLogin, profile CachedProfile) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[cacheKey(login)] = profile
+	return nil
+}
+
+type cachedProfileRow struct {
+	Login     string `gorm:"primarykey"` // lower case
+	Name      string
+	PlayerID  string
+	Found     bool
+	ExpiresAt time.Time
+}
+
+func (cachedProfileRow) TableName() string {
+	return "mojang_profile_cache"
+}
+
+// persistent cache in the sqlite db
+type GormProfileCache struct {
+	db *gorm.DB
+}
+
+// The table is created by the auth db migrations, run authdb.Migrate first
+func NewGormProfileCache(db *gorm.DB) (*GormProfileCache, error) {
+	if !db.Migrator().HasTable(&cachedProfileRow{}) {
+		return nil, errors.New("no mojang_profile_cache table, the auth db is not migrated")
+	}
+	return &GormProfileCache{db: db}, nil
+}
+
+func (cache *GormProfileCache) Get(login MinecraftLogin) (*CachedProfile, error) {
+	var rows []cachedProfileRow
+	err := cache.db.Where("login = ? AND expires_at > ?", cacheKey(login), time.Now()).Find(&rows).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get cached profile %s", login)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	row := rows[0]
+	entry := CachedProfile{
+		Profile:   Profile{Name: MinecraftLogin(row.Name)},
+		Found:     row.Found,
+		ExpiresAt: row.ExpiresAt,
+	}
+	if row.Found {
+		entry.Profile.Id, err = uuid.Parse(row.PlayerID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to parse cached player id %s", row.PlayerID)
+		}
+	}
+	return &entry, nil
+}
+
+func (cache *GormProfileCache) Put(login MinecraftLogin, profile CachedProfile) error {
+	row := cachedProfileRow{
+		Login:     cacheKey(login),
+		Name:      string(profile.Profile.Name),
+		Found:     profile.Found,
+		ExpiresAt: profile.ExpiresAt,
+	}
+	if profile.Found {
+		row.PlayerID = profile.Profile.Id.String()
+	}
+	err := cache.db.Save(&row).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to save cached profile %s", login)
+	}
+	return nil
+}
+
+// memory cache in front of a persistent one
+type LayeredProfileCache struct {
+	memory     *MemoryProfileCache
+	persistent ProfileCache
+}
+
+func NewLayeredProfileCache(persistent ProfileCache) *LayeredProfileCache {
+	return &LayeredProfileCache{
+		memory:     NewMemoryProfileCache(),
+		persistent: persistent,
+	}
+}
+
+func (cache *LayeredProfileCache) Get(login MinecraftLogin) (*CachedProfile, error) {
+	entry, _ := cache.memory.Get(login)
+	if entry != nil {
+		return entry, nil
+	}
+	entry, err := cache.persistent.Get(login)
+	if err != nil || entry == nil {
+		return entry, err
+	}
+	cache.memory.Put(login, *entry)
+	return entry, nil
+}
+
+func (cache *LayeredProfileCache) Put(login MinecraftLogin, profile CachedProfile) error {
+	cache.memory.Put(login, profile)
+	return cache.persistent.Put(login, profile)
+}
@@ -0,0 +1,300 @@
+package nbt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type TagType byte
+
+const (
+	TagEnd TagType = iota
+	TagByte
+	TagShort
+	TagInt
+	TagLong
+	TagFloat
+	TagDouble
+	TagByteArray
+	TagString
+	TagList
+	TagCompound
+	TagIntArray
+	TagLongArray
+)
+
+// Values of decoded tags are int8, int16, int32, int64, float32, float64,
+// []int8, string, List, Compound, []int32 and []int64
+type Compound map[string]interface{}
+
+type List []interface{}
+
+type ErrorUnknownTag struct {
+	Type TagType
+}
+
+func (e ErrorUnknownTag) Error() string {
+	return fmt.Sprintf("unknown tag type %d", e.Type)
+}
+
+func (e ErrorUnknownTag) Is(target error) bool {
+	_, ok := target.(ErrorUnknownTag)
+	return ok
+}
+
+// nesting deeper than this is rejected, minecraft itself limits it to 512
+const maxDepth = 512
+
+type decoder struct {
+	r io.Reader
+}
+
+// Decodes uncompressed named root compound tag
+func Decode(r io.Reader) (string, Compound, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	tagType, err := d.readTagType()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot read root tag type")
+	}
+	if tagType != TagCompound {
+		return "", nil, errors.Errorf("root tag is %d, not compound", tagType)
+	}
+	name, err := d.readString()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot read root tag name")
+	}
+	root, err := d.readCompound(0)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, root, nil
+}
+
+// Decodes gzip compressed named root compound tag, the format of level.dat and player data
+func DecodeGzip(r io.Reader) (string, Compound, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot open gzip stream")
+	}
+	defer gzipReader.Close()
+	return Decode(gzipReader)
+}
+
+func ReadGzipFile(path string) (Compound, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, root, err := DecodeGzip(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode %s", path)
+	}
+	return root, nil
+}
+
+func (d *decoder) readTagType() (TagType, error) {
+	var b [1]byte
+	_, err := io.ReadFull(d.r, b[:])
+	return TagType(b[0]), err
+}
+
+func (d *decoder) readInt8() (int8, error) {
+	var v int8
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (d *decoder) readInt16() (int16, error) {
+	var v int16
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (d *decoder) readInt32() (int32, error) {
+	var v int32
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (d *decoder) readInt64() (int64, error) {
+	var v int64
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (d *decoder) readLength() (int, error) {
+	length, err := d.readInt32()
+	if err != nil {
+		return 0, err
+	}
+	if length < 0 {
+		return 0, errors.Errorf("negative length %d", length)
+	}
+	return int(length), nil
+}
+
+// strings are java modified utf-8, which matches utf-8 for everything but
+// the null character and supplementary characters
+func (d *decoder) readString() (string, error) {
+	var length uint16
+	err := binary.Read(d.r, binary.BigEndian, &length)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, length)
+	_, err = io.ReadFull(d.r, b)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) readCompound(depth int) (Compound, error) {
+	compound := Compound{}
+	for {
+		tagType, err := d.readTagType()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read tag type")
+		}
+		if tagType == TagEnd {
+			return compound, nil
+		}
+		name, err := d.readString()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read tag name")
+		}
+		value, err := d.readPayload(tagType, depth+1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read tag %s", name)
+		}
+		compound[name] = value
+	}
+}
+
+func (d *decoder) readPayload(tagType TagType, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.Errorf("nesting is deeper than %d", maxDepth)
+	}
+	switch tagType {
+	case TagByte:
+		return d.readInt8()
+	case TagShort:
+		return d.readInt16()
+	case TagInt:
+		return d.readInt32()
+	case TagLong:
+		return d.readInt64()
+	case TagFloat:
+		bits, err := d.readInt32()
+		return math.Float32frombits(uint32(bits)), err
+	case TagDouble:
+		bits, err := d.readInt64()
+		return math.Float64frombits(uint64(bits)), err
+	case TagByteArray:
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return readArray[int8](d, length)
+	case TagString:
+		return d.readString()
+	case TagList:
+		return d.readList(depth)
+	case TagCompound:
+		return d.readCompound(depth)
+	case TagIntArray:
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return readArray[int32](d, length)
+	case TagLongArray:
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return readArray[int64](d, length)
+	default:
+		return nil, ErrorUnknownTag{Type: tagType}
+	}
+}
+
+// arrays are read in chunks, so a corrupted length fails on the end of input
+// instead of allocating up to 2^31 elements at once
+const arrayChunkLength = 4096
+
+func readArray[T int8 | int32 | int64](d *decoder, length int) ([]T, error) {
+	values := make([]T, 0, min(length, arrayChunkLength))
+	chunk := make([]T, min(length, arrayChunkLength))
+	for len(values) < length {
+		chunk = chunk[:min(length-len(values), arrayChunkLength)]
+		err := binary.Read(d.r, binary.BigEndian, chunk)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, chunk...)
+	}
+	return values, nil
+}
+
+func (d *decoder) readList(depth int) (List, error) {
+	elemType, err := d.readTagType()
+	if err != nil {
+		return nil, err
+	}
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := make(List, 0, min(length, 1024))
+	if elemType == TagEnd {
+		// empty lists are stored with end element type
+		return list, nil
+	}
+	for i := 0; i < length; i++ {
+		value, err := d.readPayload(elemType, depth+1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read list element %d", i)
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+func (c Compound) String(name string) (string, bool) {
+	v, ok := c[name].(string)
+	return v, ok
+}
+
+func (c Compound) Byte(name string) (int8, bool) {
+	v, ok := c[name].(int8)
+	return v, ok
+}
+
+func (c Compound) Int(name string) (int32, bool) {
+	v, ok := c[name].(int32)
+	return v, ok
+}
+
+func (c Compound) Float(name string) (float32, bool) {
+	v, ok := c[name].(float32)
+	return v, ok
+}
+
+func (c Compound) List(name string) (List, bool) {
+	v, ok := c[name].(List)
+	return v, ok
+}
+
+func (c Compound) Compound(name string) (Compound, bool) {
+	v, ok := c[name].(Compound)
+	return v, ok
+}
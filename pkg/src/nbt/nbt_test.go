@@ -0,0 +1,151 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// minimal encoder of test data
+type testWriter struct {
+	bytes.Buffer
+}
+
+func (w *testWriter) tag(tagType TagType, name string) {
+	w.WriteByte(byte(tagType))
+	w.str(name)
+}
+
+func (w *testWriter) str(s string) {
+	binary.Write(&w.Buffer, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *testWriter) put(v interface{}) {
+	binary.Write(&w.Buffer, binary.BigEndian, v)
+}
+
+func TestDecodeGzip(t *testing.T) {
+	w := &testWriter{}
+	w.tag(TagCompound, "")
+	w.tag(TagByte, "byte")
+	w.put(int8(-3))
+	w.tag(TagShort, "short")
+	w.put(int16(300))
+	w.tag(TagInt, "int")
+	w.put(int32(-70000))
+	w.tag(TagLong, "long")
+	w.put(int64(1 << 40))
+	w.tag(TagFloat, "float")
+	w.put(math.Float32bits(20))
+	w.tag(TagDouble, "double")
+	w.put(math.Float64bits(-1.5))
+	w.tag(TagByteArray, "bytes")
+	w.put(int32(2))
+	w.put([]int8{1, -1})
+	w.tag(TagString, "string")
+	w.str("minecraft:overworld")
+	w.tag(TagList, "list")
+	w.WriteByte(byte(TagCompound))
+	w.put(int32(2))
+	w.tag(TagString, "id")
+	w.str("minecraft:stone")
+	w.WriteByte(byte(TagEnd))
+	w.WriteByte(byte(TagEnd))
+	w.tag(TagList, "empty")
+	w.WriteByte(byte(TagEnd))
+	w.put(int32(0))
+	w.tag(TagIntArray, "ints")
+	w.put(int32(1))
+	w.put([]int32{42})
+	w.tag(TagLongArray, "longs")
+	w.put(int32(1))
+	w.put([]int64{-42})
+	w.tag(TagCompound, "nested")
+	w.tag(TagInt, "x")
+	w.put(int32(1))
+	w.WriteByte(byte(TagEnd))
+	w.WriteByte(byte(TagEnd))
+
+	compressed := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(compressed)
+	gzipWriter.Write(w.Bytes())
+	gzipWriter.Close()
+
+	name, root, err := DecodeGzip(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "" {
+		t.Fatalf("Wrong root name %q", name)
+	}
+	expected := Compound{
+		"byte":   int8(-3),
+		"short":  int16(300),
+		"int":    int32(-70000),
+		"long":   int64(1 << 40),
+		"float":  float32(20),
+		"double": float64(-1.5),
+		"bytes":  []int8{1, -1},
+		"string": "minecraft:overworld",
+		"list":   List{Compound{"id": "minecraft:stone"}, Compound{}},
+		"empty":  List{},
+		"ints":   []int32{42},
+		"longs":  []int64{-42},
+		"nested": Compound{"x": int32(1)},
+	}
+	if !reflect.DeepEqual(root, expected) {
+		t.Fatalf("Wrong decoded value:\n%#v\nexpected\n%#v", root, expected)
+	}
+	if nested, ok := root.Compound("nested"); !ok {
+		t.Fatal("Nested compound not found")
+	} else if x, ok := nested.Int("x"); !ok || x != 1 {
+		t.Fatalf("Wrong nested int %d", x)
+	}
+	if _, ok := root.Int("float"); ok {
+		t.Fatal("Float should not be read as int")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	w := &testWriter{}
+	w.tag(TagCompound, "")
+	w.tag(TagType(13), "bad")
+	_, _, err := Decode(bytes.NewReader(w.Bytes()))
+	if !errors.Is(err, ErrorUnknownTag{}) {
+		t.Fatalf("Expected unknown tag error, got %v", err)
+	}
+
+	w = &testWriter{}
+	w.tag(TagCompound, "")
+	w.tag(TagInt, "truncated")
+	w.put(int16(1))
+	_, _, err = Decode(bytes.NewReader(w.Bytes()))
+	if err == nil {
+		t.Fatal("Truncated data should fail")
+	}
+
+	w = &testWriter{}
+	w.tag(TagInt, "")
+	_, _, err = Decode(bytes.NewReader(w.Bytes()))
+	if err == nil {
+		t.Fatal("Non compound root should fail")
+	}
+
+	// the length is not trusted for allocation
+	for _, tagType := range []TagType{TagByteArray, TagIntArray, TagLongArray} {
+		w = &testWriter{}
+		w.tag(TagCompound, "")
+		w.tag(tagType, "huge")
+		w.put(int32(math.MaxInt32))
+		w.put(int64(1))
+		_, _, err = Decode(bytes.NewReader(w.Bytes()))
+		if err == nil {
+			t.Fatalf("Array of type %d longer than the input should fail", tagType)
+		}
+	}
+}
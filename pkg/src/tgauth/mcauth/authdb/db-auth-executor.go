@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -19,6 +20,8 @@ import (
 
 type AuthDbExecutorConfig struct {
 	ServerOverseerUrl string `yaml:"server_overseer_url"`
+	// database is backed up there before migrations, no backups if empty
+	MigrationBackupDir string `yaml:"migration_backup_dir"`
 }
 
 var DefaultAuthDbExecutorConfig = AuthDbExecutorConfig{
@@ -50,9 +53,12 @@ func (authdb *AuthDbExecutor) InitDB() error {
 	if err != nil {
 		return errors.Wrap(err, "fail to setup join table")
 	}
-	err = authdb.db.AutoMigrate(allSchemas...)
+	steps, err := Migrate(authdb.db, MigrateOptions{BackupDir: authdb.config.MigrationBackupDir}, authdb.logger)
 	if err != nil {
-		return errors.Wrap(err, "fail to auto migrate schemas")
+		return errors.Wrap(err, "fail to migrate schema")
+	}
+	if len(steps) > 0 {
+		authdb.logger.Info("migrated schema", zap.Int("version", LatestSchemaVersion()), zap.Int("migrations", len(steps)))
 	}
 	return nil
 }
@@ -115,28 +121,40 @@ func (authdb *AuthDbExecutor) createTgUserWithActor(tguser tgbotapi.User) error
 }
 
 // 0 duration means unlimited
-func (authdb *AuthDbExecutor) BanActor(actorId ActorId, duration time.Duration, reason string) error {
+func (authdb *AuthDbExecutor) BanActor(actorId ActorId, duration time.Duration, reason string) (*Ban, error) {
 	authdb.logger.Debug("banning actor", zap.Uint("actor_id", uint(actorId)), zap.Duration("duration", duration), zap.String("reason", reason))
-	ban := Ban{
+	ban := &Ban{
 		ActorID:     actorId,
 		BanDuration: duration,
 		Reason:      reason,
 	}
-	return authdb.db.Create(&ban).Error
+	err := authdb.db.Create(ban).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to ban actor %d", actorId)
+	}
+	return ban, nil
 }
 
-func (authdb *AuthDbExecutor) UnbanActor(actorId uint) {
-	authdb.logger.Debug("unbanning actor", zap.Uint("actor_id", actorId))
-	authdb.db.Where("actor_id = ?", actorId).Delete(&Ban{})
+// lifts all bans of the actor, they are soft deleted
+func (authdb *AuthDbExecutor) UnbanActor(actorId ActorId) error {
+	authdb.logger.Debug("unbanning actor", zap.Uint("actor_id", uint(actorId)))
+	err := authdb.db.Where("actor_id = ?", actorId).Delete(&Ban{}).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to unban actor %d", actorId)
+	}
+	return nil
 }
 
 func preloadActorFields(db *gorm.DB) *gorm.DB {
 	preloadFields := []string{
 		"SeenInChats",
+		"ChatMemberships",
 		"VerifiedByAdmins",
 		"Bans",
 		"TgAccounts",
 		"MinecraftAccounts",
+		"InvitedBy",
+		"InvitedBy.Bans",
 	}
 	for _, field := range preloadFields {
 		db = db.Preload(field)
@@ -168,6 +186,18 @@ func (authdb *AuthDbExecutor) GetActorChats(actor *Actor) error {
 	return nil
 }
 
+func (authdb *AuthDbExecutor) OptionalGetTgUser(id TgUserId) (*TgUser, error) {
+	var users []TgUser
+	err := authdb.db.Find(&users, id).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to find tg user %d", id)
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
 func (authdb *AuthDbExecutor) GetTgUser(user *TgUser) error {
 	authdb.logger.Debug("getting tg user", zap.Uint("tg_user_id", uint(user.ID)))
 	if user.ID == 0 {
@@ -260,13 +290,124 @@ func (authdb *AuthDbExecutor) RevokeMinecraftLogin(login mojang.MinecraftLogin)
 	return nil
 }
 
+func (authdb *AuthDbExecutor) GetOnlineMinecraftAccounts() ([]MinecraftAccount, error) {
+	var accounts []MinecraftAccount
+	err := authdb.db.Where("is_online = ? AND actor_id IS NOT NULL", true).Find(&accounts).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get online minecraft accounts")
+	}
+	return accounts, nil
+}
+
+func (authdb *AuthDbExecutor) GetOfflineMinecraftAccounts() ([]MinecraftAccount, error) {
+	var accounts []MinecraftAccount
+	err := authdb.db.Where("is_online = ? AND actor_id IS NOT NULL", false).Find(&accounts).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get offline minecraft accounts")
+	}
+	return accounts, nil
+}
+
+// moves account to the new login keeping owner and player id.
+// Returns ErrorLoginTaken if new login belongs to some actor
+func (authdb *AuthDbExecutor) RenameMinecraftAccount(oldLogin mojang.MinecraftLogin, newLogin mojang.MinecraftLogin) error {
+	return authdb.moveMinecraftAccount(oldLogin, newLogin, nil)
+}
+
+// moves account to the new login with a new player id, used when the player data is migrated.
+// Returns ErrorLoginTaken if new login belongs to some actor
+func (authdb *AuthDbExecutor) MigrateMinecraftAccount(oldLogin mojang.MinecraftLogin, newLogin mojang.MinecraftLogin, playerId uuid.UUID) error {
+	return authdb.moveMinecraftAccount(oldLogin, newLogin, &playerId)
+}
+
+// nil playerId keeps the old one
+func (authdb *AuthDbExecutor) moveMinecraftAccount(oldLogin mojang.MinecraftLogin, newLogin mojang.MinecraftLogin, playerId *uuid.UUID) error {
+	authdb.logger.Debug("moving minecraft account", zap.String("old_login", string(oldLogin)), zap.String("new_login", string(newLogin)))
+	return authdb.db.Transaction(func(tx *gorm.DB) error {
+		account := MinecraftAccount{ID: oldLogin}
+		err := tx.First(&account).Error
+		if err != nil {
+			return errors.Wrapf(err, "fail to find minecraft account %s", oldLogin)
+		}
+		var existing []MinecraftAccount
+		err = tx.Unscoped().Find(&existing, "id = ?", newLogin).Error
+		if err != nil {
+			return errors.Wrapf(err, "fail to find minecraft account %s", newLogin)
+		}
+		if len(existing) > 0 && existing[0].ActorID != nil && !existing[0].DeletedAt.Valid {
+			return ErrorLoginTaken{Login: newLogin}
+		}
+		err = tx.Unscoped().Delete(&MinecraftAccount{}, "id IN ?", []mojang.MinecraftLogin{oldLogin, newLogin}).Error
+		if err != nil {
+			return errors.Wrapf(err, "fail to delete minecraft account %s", oldLogin)
+		}
+		account.ID = newLogin
+		if playerId != nil {
+			account.PlayerID = playerId.String()
+		}
+		err = tx.Create(&account).Error
+		if err != nil {
+			return errors.Wrapf(err, "fail to create minecraft account %s", newLogin)
+		}
+		return nil
+	})
+}
+
 func (authdb *AuthDbExecutor) SeenInChat(actorId ActorId, chatId TgChatId) error {
 	authdb.logger.Debug("marking actor as seen in chat", zap.Uint("actor_id", uint(actorId)), zap.Uint("chat_id", uint(chatId)))
 	actor := Actor{ID: actorId}
 	authdb.db.Model(&actor).Association("SeenInChats").Append(&TgChat{ID: chatId})
+	// the actor is back if they left
+	err := authdb.db.Model(&ActorSeenInChats{}).
+		Where("actor_id = ? AND tg_chat_id = ?", actorId, chatId).
+		Update("left_at", nil).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to mark actor %d as back in chat %d", actorId, chatId)
+	}
 	return nil
 }
 
+// Returns false if the actor is not known to be in the chat
+func (authdb *AuthDbExecutor) LeftChat(actorId ActorId, chatId TgChatId) (bool, error) {
+	authdb.logger.Debug("marking actor as left chat", zap.Uint("actor_id", uint(actorId)), zap.Int64("chat_id", int64(chatId)))
+	result := authdb.db.Model(&ActorSeenInChats{}).
+		Where("actor_id = ? AND tg_chat_id = ? AND left_at IS NULL", actorId, chatId).
+		Update("left_at", time.Now())
+	if result.Error != nil {
+		return false, errors.Wrapf(result.Error, "fail to mark actor %d as left chat %d", actorId, chatId)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// accepted actors which left some chat before the moment
+func (authdb *AuthDbExecutor) GetAcceptedActorIdsLeftChatsBefore(moment time.Time) ([]ActorId, error) {
+	var actorIds []ActorId
+	err := authdb.db.Model(&ActorSeenInChats{}).
+		Joins("JOIN actors ON actors.id = actor_seen_in_chats.actor_id").
+		Where("actor_seen_in_chats.left_at <= ? AND actors.accepted = ? AND actors.deleted_at IS NULL", moment, true).
+		Distinct("actor_seen_in_chats.actor_id").
+		Pluck("actor_seen_in_chats.actor_id", &actorIds).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get actors which left chats")
+	}
+	return actorIds, nil
+}
+
+// memberships of existing actors in approved chats, left ones included
+func (authdb *AuthDbExecutor) GetApprovedChatMemberships() ([]ActorSeenInChats, error) {
+	var memberships []ActorSeenInChats
+	err := authdb.db.Model(&ActorSeenInChats{}).
+		Joins("JOIN tg_chats ON tg_chats.id = actor_seen_in_chats.tg_chat_id").
+		Joins("JOIN actors ON actors.id = actor_seen_in_chats.actor_id").
+		Where("tg_chats.approved = ? AND tg_chats.deleted_at IS NULL AND actors.deleted_at IS NULL", true).
+		Order("actor_seen_in_chats.tg_chat_id, actor_seen_in_chats.actor_id").
+		Find(&memberships).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get memberships of approved chats")
+	}
+	return memberships, nil
+}
+
 func (authdb *AuthDbExecutor) VerifiedByAdmin(actorId ActorId, adminId ActorId) error {
 	authdb.logger.Debug("marking actor as verified by admin", zap.Uint("actor_id", uint(actorId)), zap.Uint("admin_id", uint(adminId)))
 	actor := Actor{ID: actorId}
@@ -346,6 +487,27 @@ func (authdb *AuthDbExecutor) SetAdmin(actorId ActorId, isAdmin bool) error {
 	return nil
 }
 
+func (authdb *AuthDbExecutor) SetRole(actorId ActorId, role string) error {
+	authdb.logger.Debug("setting actor role", zap.Uint("actor_id", uint(actorId)), zap.String("role", role))
+	actor := Actor{ID: actorId}
+	err := authdb.db.Model(&actor).Update("role", role).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to set actor role %d", actorId)
+	}
+	return nil
+}
+
+// nil limit resets to the default one
+func (authdb *AuthDbExecutor) SetMinecraftLoginLimit(actorId ActorId, limit *int) error {
+	authdb.logger.Debug("setting actor minecraft login limit", zap.Uint("actor_id", uint(actorId)), zap.Intp("limit", limit))
+	actor := Actor{ID: actorId}
+	err := authdb.db.Model(&actor).Update("custom_minecraft_login_limit", limit).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to set actor minecraft login limit %d", actorId)
+	}
+	return nil
+}
+
 func (authdb *AuthDbExecutor) GetAllActors() ([]Actor, error) {
 	authdb.logger.Debug("getting all actors")
 	var actors []Actor
@@ -373,9 +535,10 @@ func (authdb *AuthDbExecutor) GetAcceptedActorsWithAccounts() ([]Actor, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "fail to get accepted actors")
 	}
+	now := time.Now()
 	notBannedActors := make([]Actor, 0, len(actors))
 	for _, actor := range actors {
-		if len(actor.Bans) > 0 {
+		if actor.ActiveBan(now) != nil {
 			continue
 		}
 		notBannedActors = append(notBannedActors, actor)
@@ -383,35 +546,239 @@ func (authdb *AuthDbExecutor) GetAcceptedActorsWithAccounts() ([]Actor, error) {
 	return notBannedActors, nil
 }
 
-func (authdb *AuthDbExecutor) SetWhitelist(logins []mcserver.MinecraftAccountSpec) error {
-	// authdb.logger.Debug("setting logins", zap.Any("logins", logins))
-	body, err := json.Marshal(logins)
+func (authdb *AuthDbExecutor) GetBannedActorsWithAccounts() ([]Actor, error) {
+	var actors []Actor
+	err := authdb.db.
+		Where("id IN (?)", authdb.db.Model(&Ban{}).Select("actor_id")).
+		Preload("MinecraftAccounts").Preload("Bans").
+		Find(&actors).Error
 	if err != nil {
-		return errors.Wrap(err, "fail to marshal logins")
+		return nil, errors.Wrap(err, "fail to get banned actors")
 	}
-	client := http.Client{}
-	req, err := http.NewRequest("POST", authdb.config.ServerOverseerUrl+"/set-whitelist", bytes.NewReader(body))
+	return actors, nil
+}
+
+func (authdb *AuthDbExecutor) CreateOwnershipChallenge(challenge *OwnershipChallenge) error {
+	authdb.logger.Debug("creating ownership challenge", zap.Uint("actor_id", uint(challenge.ActorID)), zap.String("login", string(challenge.Login)))
+	err := authdb.db.Create(challenge).Error
 	if err != nil {
-		return errors.Wrap(err, "fail to create request")
+		return errors.Wrapf(err, "fail to create ownership challenge for %s", challenge.Login)
 	}
-	resp, err := client.Do(req)
+	return nil
+}
+
+// not completed and not expired
+func (authdb *AuthDbExecutor) GetPendingOwnershipChallenges() ([]OwnershipChallenge, error) {
+	var challenges []OwnershipChallenge
+	err := authdb.db.Where("completed_at IS NULL AND expires_at > ?", time.Now()).Find(&challenges).Error
 	if err != nil {
-		return errors.Wrap(err, "fail to send request")
+		return nil, errors.Wrap(err, "fail to get pending ownership challenges")
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("bad response status %d", resp.StatusCode)
+	return challenges, nil
+}
+
+func (authdb *AuthDbExecutor) CompleteOwnershipChallenge(challengeId uint) error {
+	authdb.logger.Debug("completing ownership challenge", zap.Uint("challenge_id", challengeId))
+	err := authdb.db.Model(&OwnershipChallenge{}).Where("id = ?", challengeId).Update("completed_at", time.Now()).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to complete ownership challenge %d", challengeId)
 	}
 	return nil
 }
 
-func (authdb *AuthDbExecutor) SetPassword(login mojang.MinecraftLogin, password string) error {
-	authdb.logger.Debug("setting password", zap.String("login", string(login)))
-	body, err := json.Marshal(map[string]string{string(login): password})
+func (authdb *AuthDbExecutor) CreateInviteCode(code *InviteCode) error {
+	authdb.logger.Debug("creating invite code", zap.Uint("inviter_id", uint(code.InviterID)))
+	err := authdb.db.Create(code).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to create invite code of %d", code.InviterID)
+	}
+	return nil
+}
+
+// both active and redeemed ones
+func (authdb *AuthDbExecutor) GetInviteCodesOf(inviterId ActorId) ([]InviteCode, error) {
+	var codes []InviteCode
+	err := authdb.db.Where("inviter_id = ?", inviterId).Order("id").Find(&codes).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get invite codes of %d", inviterId)
+	}
+	return codes, nil
+}
+
+type ErrorInvalidInviteCode struct {
+	Code string
+}
+
+func (e ErrorInvalidInviteCode) Error() string {
+	return fmt.Sprintf("invite code %s does not exist, expired or is already used", e.Code)
+}
+
+func (e ErrorInvalidInviteCode) Is(target error) bool {
+	_, ok := target.(ErrorInvalidInviteCode)
+	return ok
+}
+
+type ErrorInviteCycle struct {
+	ActorId   ActorId
+	InviterId ActorId
+}
+
+func (e ErrorInviteCycle) Error() string {
+	return fmt.Sprintf("actor %d is in the invite chain of the inviter %d", e.ActorId, e.InviterId)
+}
+
+func (e ErrorInviteCycle) Is(target error) bool {
+	_, ok := target.(ErrorInviteCycle)
+	return ok
+}
+
+// Walks the chain of inviters from inviterId, fails if it reaches the actor
+func checkInviteChain(tx *gorm.DB, actorId ActorId, inviterId ActorId) error {
+	visited := map[ActorId]bool{}
+	for next := &inviterId; next != nil; {
+		if *next == actorId {
+			return ErrorInviteCycle{ActorId: actorId, InviterId: inviterId}
+		}
+		// a cycle made before the check existed
+		if visited[*next] {
+			return nil
+		}
+		visited[*next] = true
+		inviter := Actor{}
+		err := tx.Select("id", "invited_by_id").First(&inviter, *next).Error
+		if err != nil {
+			return err
+		}
+		next = inviter.InvitedByID
+	}
+	return nil
+}
+
+// Marks the code as used and the actor as invited, returns the redeemed code.
+// Fails if the actor invited the inviter, directly or through other invitees
+func (authdb *AuthDbExecutor) RedeemInviteCode(code string, actorId ActorId) (*InviteCode, error) {
+	authdb.logger.Debug("redeeming invite code", zap.Uint("actor_id", uint(actorId)))
+	inviteCode := &InviteCode{}
+	err := authdb.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&InviteCode{}).
+			Where("code = ? AND redeemed_by IS NULL AND expires_at > ? AND inviter_id <> ?", code, now, actorId).
+			Updates(map[string]interface{}{"redeemed_by": actorId, "redeemed_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrorInvalidInviteCode{Code: code}
+		}
+		err := tx.Where("code = ?", code).First(inviteCode).Error
+		if err != nil {
+			return err
+		}
+		err = checkInviteChain(tx, actorId, inviteCode.InviterID)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Actor{ID: actorId}).Update("invited_by_id", inviteCode.InviterID).Error
+	})
 	if err != nil {
-		return errors.Wrap(err, "fail to marshal password")
+		return nil, errors.Wrapf(err, "fail to redeem invite code for %d", actorId)
 	}
+	return inviteCode, nil
+}
+
+func (authdb *AuthDbExecutor) GetInvitees(inviterId ActorId) ([]Actor, error) {
+	var actors []Actor
+	err := preloadActorFields(authdb.db).Where("invited_by_id = ?", inviterId).Find(&actors).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get invitees of %d", inviterId)
+	}
+	return actors, nil
+}
+
+func (authdb *AuthDbExecutor) RecordAuditEvent(event *AuditEvent) error {
+	authdb.logger.Debug("recording audit event", zap.String("action", event.Action), zap.String("target_type", event.TargetType), zap.String("target_id", event.TargetID))
+	err := authdb.db.Create(event).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to record audit event %s", event.Action)
+	}
+	return nil
+}
+
+// zero fields match everything
+type AuditEventFilter struct {
+	ActorID    *ActorId
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+	// no limit if not positive
+	Limit int
+}
+
+// newest events first
+func (authdb *AuthDbExecutor) GetAuditEvents(filter AuditEventFilter) ([]AuditEvent, error) {
+	query := authdb.db.Model(&AuditEvent{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var events []AuditEvent
+	err := query.Order("created_at DESC, id DESC").Find(&events).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get audit events")
+	}
+	return events, nil
+}
+
+type ErrorOverseerStatus struct {
+	StatusCode int
+	// response body, may explain the error
+	Body []byte
+}
+
+func (e ErrorOverseerStatus) Error() string {
+	return fmt.Sprintf("bad response status %d", e.StatusCode)
+}
+
+func (e ErrorOverseerStatus) Is(target error) bool {
+	_, ok := target.(ErrorOverseerStatus)
+	return ok
+}
+
+const maxOverseerErrorBody = 64 * 1024
+
+// sends json encoded payload to the server overseer
+func (authdb *AuthDbExecutor) postToOverseer(path string, payload interface{}) error {
+	return authdb.exchangeWithOverseer(path, payload, nil)
+}
+
+// sends json encoded payload to the server overseer and decodes the result unless it is nil
+func (authdb *AuthDbExecutor) exchangeWithOverseer(path string, payload interface{}, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal payload")
+	}
+	return authdb.sendToOverseer(path, body, result)
+}
+
+// decodes json result unless it is nil
+func (authdb *AuthDbExecutor) sendToOverseer(path string, body []byte, result interface{}) error {
 	client := http.Client{}
-	req, err := http.NewRequest("POST", authdb.config.ServerOverseerUrl+"/set-passwords", bytes.NewReader(body))
+	req, err := http.NewRequest("POST", authdb.config.ServerOverseerUrl+path, bytes.NewReader(body))
 	if err != nil {
 		return errors.Wrap(err, "fail to create request")
 	}
@@ -419,12 +786,122 @@ func (authdb *AuthDbExecutor) SetPassword(login mojang.MinecraftLogin, password
 	if err != nil {
 		return errors.Wrap(err, "fail to send request")
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxOverseerErrorBody))
+		return ErrorOverseerStatus{StatusCode: resp.StatusCode, Body: respBody}
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return errors.Wrap(err, "fail to decode response")
+	}
+	return nil
+}
+
+// gets json encoded result from the server overseer
+func (authdb *AuthDbExecutor) getFromOverseer(path string, result interface{}) error {
+	client := http.Client{}
+	resp, err := client.Get(authdb.config.ServerOverseerUrl + path)
+	if err != nil {
+		return errors.Wrap(err, "fail to send request")
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("bad response status %d", resp.StatusCode)
+		return ErrorOverseerStatus{StatusCode: resp.StatusCode}
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return errors.Wrap(err, "fail to decode response")
 	}
 	return nil
 }
 
+func (authdb *AuthDbExecutor) SetWhitelist(logins []mcserver.MinecraftAccountSpec) error {
+	// authdb.logger.Debug("setting logins", zap.Any("logins", logins))
+	return authdb.postToOverseer("/set-whitelist", logins)
+}
+
+func (authdb *AuthDbExecutor) SetOps(ops []mcserver.OpSpec) error {
+	return authdb.postToOverseer("/set-ops", ops)
+}
+
+func (authdb *AuthDbExecutor) SetBans(bans []mcserver.PlayerBanSpec) error {
+	return authdb.postToOverseer("/set-bans", bans)
+}
+
+func (authdb *AuthDbExecutor) SetRoles(roles []mcserver.AccountRolesSpec) error {
+	return authdb.postToOverseer("/set-roles", roles)
+}
+
+func (authdb *AuthDbExecutor) SetOwnershipChallenges(challenges []mcserver.OwnershipChallengeSpec) error {
+	return authdb.postToOverseer("/set-ownership-challenges", challenges)
+}
+
+func (authdb *AuthDbExecutor) GetOwnershipVerifications() ([]mcserver.OwnershipVerification, error) {
+	var verifications []mcserver.OwnershipVerification
+	err := authdb.getFromOverseer("/ownership-verifications", &verifications)
+	if err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
+
+func (authdb *AuthDbExecutor) GetUserCache() ([]mcserver.UserCacheEntry, error) {
+	var entries []mcserver.UserCacheEntry
+	err := authdb.getFromOverseer("/usercache", &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// returns mcserver.ErrorPlayerDataExists if the target player already has data and
+// mcserver.ErrorMigrationNotRolledBack if the failed migration left files moved
+func (authdb *AuthDbExecutor) MigratePlayerData(migration mcserver.PlayerDataMigrationSpec) error {
+	authdb.logger.Info("migrating player data", zap.Any("migration", migration))
+	err := authdb.postToOverseer("/migrate-player-data", migration)
+	var statusErr ErrorOverseerStatus
+	if !errors.As(err, &statusErr) {
+		return err
+	}
+	if statusErr.StatusCode == http.StatusConflict {
+		return mcserver.ErrorPlayerDataExists{}
+	}
+	var record mcserver.PlayerMigrationRecord
+	if json.Unmarshal(statusErr.Body, &record) == nil && record.RollbackError != "" {
+		return mcserver.ErrorMigrationNotRolledBack{Record: record}
+	}
+	return err
+}
+
+// returns mcserver.ErrorNoPlayerData if the player has never joined
+func (authdb *AuthDbExecutor) GetPlayerInfo(playerId uuid.UUID) (*mcserver.PlayerInfo, error) {
+	var info mcserver.PlayerInfo
+	err := authdb.exchangeWithOverseer("/player-info", mcserver.PlayerInfoRequest{PlayerId: playerId.String()}, &info)
+	var statusErr ErrorOverseerStatus
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, mcserver.ErrorNoPlayerData{PlayerId: playerId.String()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// runs a console command on the minecraft server
+func (authdb *AuthDbExecutor) RunServerCommand(command string) error {
+	authdb.logger.Debug("running server command", zap.String("command", command))
+	return authdb.sendToOverseer("/command", []byte(command), nil)
+}
+
+func (authdb *AuthDbExecutor) SetPassword(login mojang.MinecraftLogin, password string) error {
+	authdb.logger.Debug("setting password", zap.String("login", string(login)))
+	return authdb.postToOverseer("/set-passwords", map[string]string{string(login): password})
+}
+
 func (authdb *AuthDbExecutor) ApproveChat(chatId TgChatId, actorId ActorId) error {
 	authdb.logger.Debug("approving chat", zap.Uint("chat_id", uint(chatId)))
 	chat := TgChat{
@@ -439,12 +916,84 @@ func (authdb *AuthDbExecutor) ApproveChat(chatId TgChatId, actorId ActorId) erro
 	return nil
 }
 
-func (authdb *AuthDbExecutor) EnteredCorrectPassword(actorId ActorId) error {
-	authdb.logger.Debug("setting entered correct password", zap.Uint("actor_id", uint(actorId)))
-	actor := Actor{ID: actorId, EnteredAccessPass: true}
-	err := authdb.db.Model(&actor).Updates(actor).Error
+type ErrorAccessPasswordUnavailable struct {
+	PasswordId uint
+}
+
+func (e ErrorAccessPasswordUnavailable) Error() string {
+	return fmt.Sprintf("access password %d is revoked, expired or used up", e.PasswordId)
+}
+
+func (e ErrorAccessPasswordUnavailable) Is(target error) bool {
+	_, ok := target.(ErrorAccessPasswordUnavailable)
+	return ok
+}
+
+// Counts the use of the password if it is still active and records it for the actor
+func (authdb *AuthDbExecutor) EnteredCorrectPassword(actorId ActorId, passwordId uint) error {
+	authdb.logger.Debug("setting entered correct password", zap.Uint("actor_id", uint(actorId)), zap.Uint("password_id", passwordId))
+	err := authdb.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&AccessPassword{}).
+			Where("id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND (max_uses IS NULL OR uses < max_uses)", passwordId, now).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrorAccessPasswordUnavailable{PasswordId: passwordId}
+		}
+		return tx.Model(&Actor{ID: actorId}).
+			Updates(map[string]interface{}{"entered_access_pass": true, "access_password_id": passwordId}).Error
+	})
 	if err != nil {
 		return errors.Wrapf(err, "fail to set entered password %d", actorId)
 	}
 	return nil
 }
+
+func (authdb *AuthDbExecutor) CreateAccessPassword(password *AccessPassword) error {
+	authdb.logger.Debug("creating access password", zap.String("label", password.Label))
+	err := authdb.db.Create(password).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to create access password %s", password.Label)
+	}
+	return nil
+}
+
+// revoked ones too, nil if there is no password with the hash
+func (authdb *AuthDbExecutor) OptionalGetAccessPasswordByHash(hash string) (*AccessPassword, error) {
+	var passwords []AccessPassword
+	err := authdb.db.Where("password_hash = ?", hash).Limit(1).Find(&passwords).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get access password")
+	}
+	if len(passwords) == 0 {
+		return nil, nil
+	}
+	return &passwords[0], nil
+}
+
+// not revoked, not expired and not used up
+func (authdb *AuthDbExecutor) GetActiveAccessPasswords() ([]AccessPassword, error) {
+	var passwords []AccessPassword
+	err := authdb.db.
+		Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND (max_uses IS NULL OR uses < max_uses)", time.Now()).
+		Order("id").Find(&passwords).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get active access passwords")
+	}
+	return passwords, nil
+}
+
+func (authdb *AuthDbExecutor) RevokeAccessPassword(passwordId uint) error {
+	authdb.logger.Debug("revoking access password", zap.Uint("password_id", passwordId))
+	result := authdb.db.Model(&AccessPassword{}).Where("id = ? AND revoked_at IS NULL", passwordId).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return errors.Wrapf(result.Error, "fail to revoke access password %d", passwordId)
+	}
+	if result.RowsAffected == 0 {
+		return ErrorAccessPasswordUnavailable{PasswordId: passwordId}
+	}
+	return nil
+}
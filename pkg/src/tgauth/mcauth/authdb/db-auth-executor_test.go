@@ -3,6 +3,7 @@ package authdb
 import (
 	"os"
 	"testing"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/imobulus/subchat-mc-server/src/mojang"
@@ -90,3 +91,135 @@ func TestDoubleSave(t *testing.T) {
 		t.Fatalf("Double save should fail")
 	}
 }
+
+func TestRenameMinecraftAccount(t *testing.T) {
+	executor := initExecutor(t)
+	playerId := mojang.GetOfflineUuid("official")
+	err := executor.AddMinecraftLogin(1, "official", true, playerId)
+	if err != nil {
+		t.Fatalf("Failed to add minecraft login: %v", err)
+	}
+	err = executor.AddMinecraftLogin(2, "taken", false, mojang.GetOfflineUuid("taken"))
+	if err != nil {
+		t.Fatalf("Failed to add minecraft login: %v", err)
+	}
+	err = executor.RenameMinecraftAccount("official", "taken")
+	if !errors.Is(err, ErrorLoginTaken{}) {
+		t.Fatalf("Rename to taken login should fail, got %v", err)
+	}
+	err = executor.RenameMinecraftAccount("official", "renamed")
+	if err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	old, err := executor.OptionalGetMinecraftAccount("official")
+	if err != nil || old != nil {
+		t.Fatalf("Old login should be gone: %v %v", old, err)
+	}
+	renamed, err := executor.OptionalGetMinecraftAccount("renamed")
+	if err != nil || renamed == nil {
+		t.Fatalf("Failed to get renamed account: %v", err)
+	}
+	if *renamed.ActorID != 1 || !renamed.IsOnline || renamed.PlayerID != playerId.String() {
+		t.Fatalf("Wrong renamed account: %v", renamed)
+	}
+	// old login is free for registration again
+	err = executor.AddMinecraftLogin(3, "official", false, mojang.GetOfflineUuid("official"))
+	if err != nil {
+		t.Fatalf("Failed to add old login: %v", err)
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	executor := initExecutor(t)
+	admin := ActorId(1)
+	updateId := 42
+	events := []AuditEvent{
+		{ActorID: &admin, Action: "ban_actor", TargetType: AuditTargetActor, TargetID: "2", TgUpdateID: &updateId},
+		{ActorID: &admin, Action: "approve_chat", TargetType: AuditTargetTgChat, TargetID: "-100"},
+		{Action: "update_actor_status", TargetType: AuditTargetActor, TargetID: "2", After: `{"Accepted":true}`},
+	}
+	for i := range events {
+		err := executor.RecordAuditEvent(&events[i])
+		if err != nil {
+			t.Fatalf("Failed to record audit event: %v", err)
+		}
+	}
+	found, err := executor.GetAuditEvents(AuditEventFilter{ActorID: &admin})
+	if err != nil || len(found) != 2 {
+		t.Fatalf("Expected events of the admin: %v %v", found, err)
+	}
+	if found[0].Action != "approve_chat" || *found[1].TgUpdateID != updateId {
+		t.Fatalf("Expected newest events first: %v", found)
+	}
+	found, err = executor.GetAuditEvents(AuditEventFilter{TargetType: AuditTargetActor, TargetID: "2", Limit: 1})
+	if err != nil || len(found) != 1 || found[0].ActorID != nil || found[0].After != `{"Accepted":true}` {
+		t.Fatalf("Expected the latest event of the target: %v %v", found, err)
+	}
+	future := time.Now().Add(time.Hour)
+	found, err = executor.GetAuditEvents(AuditEventFilter{Since: &future})
+	if err != nil || len(found) != 0 {
+		t.Fatalf("Expected no events in the future: %v %v", found, err)
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	executor := initExecutor(t)
+	err := executor.UpdateTgUserInfo(tgbotapi.User{ID: 1, UserName: "griefer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := Actor{}
+	err = executor.GetActorByTgUser(1, &actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = executor.SetAccept(actor.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isAccepted := func() bool {
+		actors, err := executor.GetAcceptedActorsWithAccounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(actors) == 1
+	}
+	// lapsed ban
+	err = executor.db.Create(&Ban{
+		Model:       gorm.Model{CreatedAt: time.Now().Add(-2 * time.Hour)},
+		ActorID:     actor.ID,
+		BanDuration: time.Hour,
+		Reason:      "old",
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAccepted() {
+		t.Fatal("Expired ban should not block the actor")
+	}
+	ban, err := executor.BanActor(actor.ID, 24*time.Hour, "griefing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAccepted() {
+		t.Fatal("Banned actor should not be accepted")
+	}
+	err = executor.GetActor(&actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := actor.ActiveBan(time.Now())
+	if active == nil || active.ID != ban.ID || !active.ExpiresAt().Equal(ban.CreatedAt.Add(24*time.Hour)) {
+		t.Fatalf("Unexpected active ban %v", active)
+	}
+	if actor.ActiveBan(time.Now().Add(25*time.Hour)) != nil {
+		t.Fatal("Ban should lapse after its duration")
+	}
+	err = executor.UnbanActor(actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAccepted() {
+		t.Fatal("Unbanned actor should be accepted")
+	}
+}
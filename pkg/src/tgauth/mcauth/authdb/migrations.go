@@ -0,0 +1,320 @@
+package authdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// table schema_migrations, one row per applied migration
+type SchemaMigration struct {
+	Version   int `gorm:"primarykey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+func execStatements(statements ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			err := tx.Exec(statement).Error
+			if err != nil {
+				return errors.Wrapf(err, "fail to exec %s", statement)
+			}
+		}
+		return nil
+	}
+}
+
+// Ordered by version. Never change an applied migration, add a new one instead.
+// The first ones use IF NOT EXISTS to adopt databases created by AutoMigrate
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: execStatements(
+			"CREATE TABLE IF NOT EXISTS `actors` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`nickname` text,`description` text,`is_admin` numeric,`accepted` numeric,`entered_access_pass` numeric,`accepted_last_time` datetime,`custom_minecraft_login_limit` integer)",
+			"CREATE INDEX IF NOT EXISTS `idx_actors_deleted_at` ON `actors`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `actors_verified_by_admins` (`actor_id` integer,`verified_by_admin_id` integer,PRIMARY KEY (`actor_id`,`verified_by_admin_id`),CONSTRAINT `fk_actors_verified_by_admins_actor` FOREIGN KEY (`actor_id`) REFERENCES `actors`(`id`),CONSTRAINT `fk_actors_verified_by_admins_verified_by_admins` FOREIGN KEY (`verified_by_admin_id`) REFERENCES `actors`(`id`))",
+			"CREATE TABLE IF NOT EXISTS `tg_chats` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`approved` numeric,`approved_by` integer)",
+			"CREATE INDEX IF NOT EXISTS `idx_tg_chats_deleted_at` ON `tg_chats`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `actor_seen_in_chats` (`tg_chat_id` integer,`actor_id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,PRIMARY KEY (`tg_chat_id`,`actor_id`),CONSTRAINT `fk_actor_seen_in_chats_actor` FOREIGN KEY (`actor_id`) REFERENCES `actors`(`id`),CONSTRAINT `fk_actor_seen_in_chats_tg_chat` FOREIGN KEY (`tg_chat_id`) REFERENCES `tg_chats`(`id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_actor_seen_in_chats_deleted_at` ON `actor_seen_in_chats`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `tg_users` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`last_seen_info` text,`actor_id` integer,`last_lower_username` text,CONSTRAINT `fk_actors_tg_accounts` FOREIGN KEY (`actor_id`) REFERENCES `actors`(`id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_tg_users_deleted_at` ON `tg_users`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `bans` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`actor_id` integer,`ban_duration` integer,`reason` text,CONSTRAINT `fk_actors_bans` FOREIGN KEY (`actor_id`) REFERENCES `actors`(`id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_bans_deleted_at` ON `bans`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `minecraft_accounts` (`id` text,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`actor_id` integer,`is_online` numeric,`player_id` text,PRIMARY KEY (`id`),CONSTRAINT `fk_actors_minecraft_accounts` FOREIGN KEY (`actor_id`) REFERENCES `actors`(`id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_minecraft_accounts_deleted_at` ON `minecraft_accounts`(`deleted_at`)",
+		),
+		Down: execStatements(
+			"DROP TABLE `minecraft_accounts`",
+			"DROP TABLE `bans`",
+			"DROP TABLE `tg_users`",
+			"DROP TABLE `actor_seen_in_chats`",
+			"DROP TABLE `tg_chats`",
+			"DROP TABLE `actors_verified_by_admins`",
+			"DROP TABLE `actors`",
+		),
+	},
+	{
+		Version: 2,
+		Name:    "ownership challenges",
+		Up: execStatements(
+			"CREATE TABLE IF NOT EXISTS `ownership_challenges` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`actor_id` integer,`login` text,`player_id` text,`code` text,`expires_at` datetime,`completed_at` datetime)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS `idx_ownership_challenges_code` ON `ownership_challenges`(`code`)",
+			"CREATE INDEX IF NOT EXISTS `idx_ownership_challenges_deleted_at` ON `ownership_challenges`(`deleted_at`)",
+		),
+		Down: execStatements(
+			"DROP TABLE `ownership_challenges`",
+		),
+	},
+	{
+		Version: 3,
+		Name:    "audit events",
+		Up: execStatements(
+			"CREATE TABLE `audit_events` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`actor_id` integer,`action` text,`target_type` text,`target_id` text,`before` text,`after` text,`tg_update_id` integer)",
+			"CREATE INDEX `idx_audit_events_created_at` ON `audit_events`(`created_at`)",
+			"CREATE INDEX `idx_audit_events_actor_id` ON `audit_events`(`actor_id`)",
+			"CREATE INDEX `idx_audit_events_action` ON `audit_events`(`action`)",
+			"CREATE INDEX `idx_audit_events_target_id` ON `audit_events`(`target_id`)",
+		),
+		Down: execStatements(
+			"DROP TABLE `audit_events`",
+		),
+	},
+	{
+		Version: 4,
+		Name:    "actor roles",
+		Up: execStatements(
+			"ALTER TABLE `actors` ADD COLUMN `role` text",
+		),
+		Down: execStatements(
+			"ALTER TABLE `actors` DROP COLUMN `role`",
+		),
+	},
+	{
+		Version: 5,
+		Name:    "invite codes",
+		Up: execStatements(
+			"ALTER TABLE `actors` ADD COLUMN `invited_by_id` integer",
+			"CREATE INDEX `idx_actors_invited_by_id` ON `actors`(`invited_by_id`)",
+			"CREATE TABLE `invite_codes` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`code` text,`inviter_id` integer,`expires_at` datetime,`redeemed_by` integer,`redeemed_at` datetime)",
+			"CREATE UNIQUE INDEX `idx_invite_codes_code` ON `invite_codes`(`code`)",
+			"CREATE INDEX `idx_invite_codes_inviter_id` ON `invite_codes`(`inviter_id`)",
+			"CREATE INDEX `idx_invite_codes_deleted_at` ON `invite_codes`(`deleted_at`)",
+		),
+		Down: execStatements(
+			"DROP TABLE `invite_codes`",
+			"DROP INDEX `idx_actors_invited_by_id`",
+			"ALTER TABLE `actors` DROP COLUMN `invited_by_id`",
+		),
+	},
+	{
+		Version: 6,
+		Name:    "access passwords",
+		Up: execStatements(
+			"ALTER TABLE `actors` ADD COLUMN `access_password_id` integer",
+			"CREATE TABLE `access_passwords` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`label` text,`password_hash` text,`created_by_id` integer,`expires_at` datetime,`max_uses` integer,`uses` integer,`revoked_at` datetime)",
+			"CREATE UNIQUE INDEX `idx_access_passwords_password_hash` ON `access_passwords`(`password_hash`)",
+			"CREATE INDEX `idx_access_passwords_deleted_at` ON `access_passwords`(`deleted_at`)",
+		),
+		Down: execStatements(
+			"DROP TABLE `access_passwords`",
+			"ALTER TABLE `actors` DROP COLUMN `access_password_id`",
+		),
+	},
+	{
+		Version: 7,
+		Name:    "chat leaves",
+		Up: execStatements(
+			"ALTER TABLE `actor_seen_in_chats` ADD COLUMN `left_at` datetime",
+		),
+		Down: execStatements(
+			"ALTER TABLE `actor_seen_in_chats` DROP COLUMN `left_at`",
+		),
+	},
+	{
+		// the table of mojang.GormProfileCache, which used to AutoMigrate it
+		Version: 8,
+		Name:    "mojang profile cache",
+		Up: execStatements(
+			"CREATE TABLE IF NOT EXISTS `mojang_profile_cache` (`login` text,`name` text,`player_id` text,`found` numeric,`expires_at` datetime,PRIMARY KEY (`login`))",
+		),
+		Down: execStatements(
+			"DROP TABLE `mojang_profile_cache`",
+		),
+	},
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+type MigrateOptions struct {
+	// version to migrate up or down to, the latest one if nil
+	Target *int
+	// migrations are applied in a transaction which is rolled back
+	DryRun bool
+	// the sqlite database is copied there before it is changed, no backup if empty
+	BackupDir string
+}
+
+type MigrationStep struct {
+	Migration
+	IsDown bool
+}
+
+func (step MigrationStep) String() string {
+	direction := "up"
+	if step.IsDown {
+		direction = "down"
+	}
+	return fmt.Sprintf("%d %s (%s)", step.Version, step.Name, direction)
+}
+
+func createMigrationsTable(db *gorm.DB) error {
+	err := db.Exec("CREATE TABLE IF NOT EXISTS `schema_migrations` (`version` integer PRIMARY KEY,`name` text,`applied_at` datetime)").Error
+	if err != nil {
+		return errors.Wrap(err, "fail to create schema_migrations")
+	}
+	return nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]bool, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return map[int]bool{}, nil
+	}
+	var rows []SchemaMigration
+	err := db.Find(&rows).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read schema_migrations")
+	}
+	applied := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = true
+	}
+	return applied, nil
+}
+
+// Pending up migrations to target in order, or applied down migrations above target in reverse order
+func planMigrations(applied map[int]bool, target int) []MigrationStep {
+	steps := []MigrationStep{}
+	for _, migration := range migrations {
+		if migration.Version <= target && !applied[migration.Version] {
+			steps = append(steps, MigrationStep{Migration: migration})
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migrations[i].Version > target && applied[migrations[i].Version] {
+			steps = append(steps, MigrationStep{Migration: migrations[i], IsDown: true})
+		}
+	}
+	return steps
+}
+
+func applyStep(tx *gorm.DB, step MigrationStep) error {
+	if step.IsDown {
+		err := step.Down(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{}, step.Version).Error
+	}
+	err := step.Up(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&SchemaMigration{Version: step.Version, Name: step.Name, AppliedAt: time.Now()}).Error
+}
+
+var errDryRun = errors.New("dry run")
+
+// Migrates the database to the target version. Returns the steps taken, or
+// the steps which would be taken in dry run mode
+func Migrate(db *gorm.DB, options MigrateOptions, logger *zap.Logger) ([]MigrationStep, error) {
+	target := LatestSchemaVersion()
+	if options.Target != nil {
+		target = *options.Target
+	}
+	if target < 0 || target > LatestSchemaVersion() {
+		return nil, errors.Errorf("unknown schema version %d", target)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	steps := planMigrations(applied, target)
+	if len(steps) == 0 {
+		return steps, nil
+	}
+	if options.DryRun {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := createMigrationsTable(tx)
+			if err != nil {
+				return err
+			}
+			for _, step := range steps {
+				logger.Info("dry run of migration", zap.Stringer("migration", step))
+				err := applyStep(tx, step)
+				if err != nil {
+					return errors.Wrapf(err, "migration %s failed", step)
+				}
+			}
+			return errDryRun
+		})
+		if err != errDryRun {
+			return steps, err
+		}
+		return steps, nil
+	}
+	if options.BackupDir != "" && (len(applied) > 0 || db.Migrator().HasTable("actors")) {
+		err = backupDatabase(db, options.BackupDir, applied, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = createMigrationsTable(db)
+	if err != nil {
+		return nil, err
+	}
+	for i, step := range steps {
+		logger.Info("applying migration", zap.Stringer("migration", step))
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return applyStep(tx, step)
+		})
+		if err != nil {
+			return steps[:i], errors.Wrapf(err, "migration %s failed", step)
+		}
+	}
+	return steps, nil
+}
+
+func backupDatabase(db *gorm.DB, backupDir string, applied map[int]bool, logger *zap.Logger) error {
+	err := os.MkdirAll(backupDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "fail to create backup dir %s", backupDir)
+	}
+	current := 0
+	for version := range applied {
+		current = max(current, version)
+	}
+	backupPath := filepath.Join(backupDir, fmt.Sprintf("auth-v%d-%s.db", current, time.Now().Format("20060102-150405")))
+	logger.Info("backing up database before migrations", zap.String("path", backupPath))
+	err = db.Exec("VACUUM INTO ?", backupPath).Error
+	if err != nil {
+		return errors.Wrapf(err, "fail to back up database to %s", backupPath)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package authdb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func openTempDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	return db
+}
+
+func schemaVersion(t *testing.T, db *gorm.DB) int {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := 0
+	for version := range applied {
+		current = max(current, version)
+	}
+	return current
+}
+
+func TestMigrateFixtureToLatest(t *testing.T) {
+	db := openTempDb(t)
+	logger := zap.NewNop()
+	first := 1
+	_, err := Migrate(db, MigrateOptions{Target: &first}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("ownership_challenges") {
+		t.Fatal("Ownership challenges are not in the first schema")
+	}
+	// fixture data of the first schema
+	err = db.Exec("INSERT INTO actors (id, nickname, is_admin) VALUES (1, 'admin', true)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Exec("INSERT INTO minecraft_accounts (id, actor_id, is_online, player_id) VALUES ('Steve', 1, false, 'abc')").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := Migrate(db, MigrateOptions{DryRun: true}, logger)
+	if err != nil || len(steps) != len(migrations)-1 {
+		t.Fatalf("Unexpected dry run %v %v", steps, err)
+	}
+	if schemaVersion(t, db) != 1 || db.Migrator().HasTable("ownership_challenges") {
+		t.Fatal("Dry run should not change the database")
+	}
+
+	backupDir := filepath.Join(t.TempDir(), "backups")
+	_, err = Migrate(db, MigrateOptions{BackupDir: backupDir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemaVersion(t, db) != LatestSchemaVersion() {
+		t.Fatalf("Expected latest version, got %d", schemaVersion(t, db))
+	}
+	backups, _ := os.ReadDir(backupDir)
+	if len(backups) != 1 {
+		t.Fatalf("Expected a backup, got %d", len(backups))
+	}
+	var account MinecraftAccount
+	err = db.First(&account, "id = ?", "Steve").Error
+	if err != nil || account.ActorID == nil || *account.ActorID != 1 {
+		t.Fatalf("Fixture data was not kept: %v %v", account, err)
+	}
+
+	// migrated schema has every column of the models
+	for _, model := range allSchemas {
+		parsed, err := schema.Parse(model, &sync.Map{}, db.NamingStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !db.Migrator().HasTable(parsed.Table) {
+			t.Errorf("Missing table %s", parsed.Table)
+			continue
+		}
+		for _, field := range parsed.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("Missing column %s.%s", parsed.Table, field.DBName)
+			}
+		}
+	}
+
+	_, err = mojang.NewGormProfileCache(db)
+	if err != nil {
+		t.Fatalf("Profile cache should work on the migrated db: %v", err)
+	}
+
+	_, err = Migrate(db, MigrateOptions{Target: &first}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemaVersion(t, db) != 1 || db.Migrator().HasTable("ownership_challenges") {
+		t.Fatal("Expected migration down to the first schema")
+	}
+}
+
+// databases created by AutoMigrate have the tables but no schema_migrations
+func TestMigrateAdoptsAutoMigratedDatabase(t *testing.T) {
+	db := openTempDb(t)
+	// same statements as AutoMigrate of the models before migrations existed
+	for _, migration := range migrations[:2] {
+		err := migration.Up(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps, err := Migrate(db, MigrateOptions{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != len(migrations) || schemaVersion(t, db) != LatestSchemaVersion() {
+		t.Fatalf("Unexpected migrations %v", steps)
+	}
+}
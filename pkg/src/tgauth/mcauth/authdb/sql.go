@@ -45,8 +45,13 @@ type Actor struct {
 	Accepted          bool
 	EnteredAccessPass bool
 	AcceptedLastTime  time.Time
-	SeenInChats       []TgChat `gorm:"many2many:actors_seen_in_chats"`
-	VerifiedByAdmins  []*Actor `gorm:"many2many:actors_verified_by_admins"`
+	Role              string // empty for the default role
+	InvitedByID       *ActorId
+	InvitedBy         *Actor             // with bans
+	AccessPasswordID  *uint              // the access password the actor entered
+	SeenInChats       []TgChat           `gorm:"many2many:actors_seen_in_chats"`
+	ChatMemberships   []ActorSeenInChats `gorm:"foreignKey:ActorID"` // rows of SeenInChats
+	VerifiedByAdmins  []*Actor           `gorm:"many2many:actors_verified_by_admins"`
 	Bans              []Ban
 	TgAccounts        []TgUser
 	MinecraftAccounts []MinecraftAccount
@@ -60,6 +65,7 @@ type ActorSeenInChats struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	LeftAt    *time.Time     // nil while the actor is in the chat
 }
 
 // table bans
@@ -70,6 +76,43 @@ type Ban struct {
 	Reason      string
 }
 
+// nil means the ban never expires
+func (ban *Ban) ExpiresAt() *time.Time {
+	if ban.BanDuration == 0 {
+		return nil
+	}
+	expires := ban.CreatedAt.Add(ban.BanDuration)
+	return &expires
+}
+
+// expired bans are kept as history
+func (ban *Ban) IsActive(now time.Time) bool {
+	expires := ban.ExpiresAt()
+	return expires == nil || expires.After(now)
+}
+
+// Picks the ban ending last if there are several, nil if the actor is not banned.
+// Bans must be preloaded
+func (actor *Actor) ActiveBan(now time.Time) *Ban {
+	var activeBan *Ban
+	for i := range actor.Bans {
+		ban := &actor.Bans[i]
+		if !ban.IsActive(now) {
+			continue
+		}
+		if activeBan == nil {
+			activeBan = ban
+			continue
+		}
+		activeExpires := activeBan.ExpiresAt()
+		expires := ban.ExpiresAt()
+		if activeExpires != nil && (expires == nil || expires.After(*activeExpires)) {
+			activeBan = ban
+		}
+	}
+	return activeBan
+}
+
 type TgUser struct {
 	ID           TgUserId `gorm:"primarykey"` // tg user id
 	CreatedAt    time.Time
@@ -104,11 +147,87 @@ type TgChat struct {
 	ApprovedBy ActorId        // who approved
 }
 
-// used for AutoMigrate
+// one-time code proving that actor owns the official account
+type OwnershipChallenge struct {
+	gorm.Model
+	ActorID     ActorId
+	Login       mojang.MinecraftLogin
+	PlayerID    string
+	Code        string `gorm:"uniqueIndex"`
+	ExpiresAt   time.Time
+	CompletedAt *time.Time
+}
+
+// single-use code inviting a new actor, redeemed with the /start deep link
+type InviteCode struct {
+	gorm.Model
+	Code       string  `gorm:"uniqueIndex"`
+	InviterID  ActorId `gorm:"index"`
+	ExpiresAt  time.Time
+	RedeemedBy *ActorId
+	RedeemedAt *time.Time
+}
+
+// redeemed codes are kept for history
+func (code *InviteCode) IsActive(now time.Time) bool {
+	return code.RedeemedBy == nil && code.ExpiresAt.After(now)
+}
+
+// password giving access to the server, only its hash is stored
+type AccessPassword struct {
+	gorm.Model
+	Label        string
+	PasswordHash string   `gorm:"uniqueIndex"`
+	CreatedByID  *ActorId // nil for the password imported from the secret
+	ExpiresAt    *time.Time
+	MaxUses      *int // unlimited if nil
+	Uses         int
+	RevokedAt    *time.Time
+}
+
+func (password *AccessPassword) IsActive(now time.Time) bool {
+	if password.RevokedAt != nil {
+		return false
+	}
+	if password.ExpiresAt != nil && !password.ExpiresAt.After(now) {
+		return false
+	}
+	return password.MaxUses == nil || password.Uses < *password.MaxUses
+}
+
+const (
+	AuditTargetActor            = "actor"
+	AuditTargetTgUser           = "tg_user"
+	AuditTargetTgChat           = "tg_chat"
+	AuditTargetMinecraftAccount = "minecraft_account"
+	AuditTargetPlayer           = "player"
+	AuditTargetServer           = "server"
+	AuditTargetAccessPassword   = "access_password"
+)
+
+// table audit_events, one row per mutation of the auth state
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	ActorID    *ActorId  `gorm:"index"` // who did it, nil for automatic changes
+	Action     string    `gorm:"index"`
+	TargetType string
+	TargetID   string `gorm:"index"`
+	Before     string // json snapshot of the target, empty if unknown
+	After      string
+	TgUpdateID *int // telegram update which caused the change, if any
+}
+
+// models backed by tables, migrations must create all of their columns
 var allSchemas = []interface{}{
 	&Actor{},
+	&ActorSeenInChats{},
 	&TgUser{},
 	&Ban{},
 	&MinecraftAccount{},
 	&TgChat{},
+	&OwnershipChallenge{},
+	&AuditEvent{},
+	&InviteCode{},
+	&AccessPassword{},
 }
@@ -0,0 +1,175 @@
+package permsengine
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+const accessPasswordLength = 10
+
+// label of the password imported from the secret
+const SecretAccessPasswordLabel = "secret"
+
+type ErrorWrongAccessPassword struct{}
+
+func (e ErrorWrongAccessPassword) Error() string {
+	return "wrong access password"
+}
+
+func (e ErrorWrongAccessPassword) Is(target error) bool {
+	_, ok := target.(ErrorWrongAccessPassword)
+	return ok
+}
+
+func hashAccessPassword(pass string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(pass)))
+	return hex.EncodeToString(sum[:])
+}
+
+func accessPasswordTarget(passwordId uint) string {
+	return strconv.FormatUint(uint64(passwordId), 10)
+}
+
+// A revoked secret password is not imported again
+func (engine *ServerPermsEngine) importSecretAccessPassword(pass string) error {
+	if pass == "" {
+		return nil
+	}
+	hash := hashAccessPassword(pass)
+	existing, err := engine.dbExecutor.OptionalGetAccessPasswordByHash(hash)
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret access password")
+	}
+	if existing != nil {
+		return nil
+	}
+	password := &authdb.AccessPassword{Label: SecretAccessPasswordLabel, PasswordHash: hash}
+	err = engine.dbExecutor.CreateAccessPassword(password)
+	if err != nil {
+		return errors.Wrap(err, "failed to import secret access password")
+	}
+	after := map[string]interface{}{"Label": password.Label}
+	engine.audit(nil, AuditCreateAccessPassword, authdb.AuditTargetAccessPassword, accessPasswordTarget(password.ID), nil, after)
+	return nil
+}
+
+// Compares with every active password in constant time, nil if none matches
+func (engine *ServerPermsEngine) matchAccessPassword(pass string) (*authdb.AccessPassword, error) {
+	passwords, err := engine.dbExecutor.GetActiveAccessPasswords()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get access passwords")
+	}
+	hash := []byte(hashAccessPassword(pass))
+	var matched *authdb.AccessPassword
+	for i := range passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(passwords[i].PasswordHash)) == 1 {
+			matched = &passwords[i]
+		}
+	}
+	return matched, nil
+}
+
+func (engine *ServerPermsEngine) HandleAccessPassword(actorId authdb.ActorId, pass string) error {
+	password, err := engine.matchAccessPassword(pass)
+	if err != nil {
+		return err
+	}
+	if password == nil {
+		return ErrorWrongAccessPassword{}
+	}
+	before, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
+	err = engine.dbExecutor.EnteredCorrectPassword(actorId, password.ID)
+	if err != nil {
+		// used up by someone else in the meantime
+		if errors.Is(err, authdb.ErrorAccessPasswordUnavailable{}) {
+			return ErrorWrongAccessPassword{}
+		}
+		return errors.Wrap(err, "failed to enter correct password")
+	}
+	engine.auditActor(&actorId, AuditEnterAccessPassword, actorId, before)
+	return nil
+}
+
+// Returns the generated password, it is not stored and can't be shown again.
+// Zero expiresIn means the password does not expire, nil maxUses means unlimited uses
+func (engine *ServerPermsEngine) AdminCreateAccessPassword(
+	requestor authdb.ActorId,
+	label string,
+	expiresIn time.Duration,
+	maxUses *int,
+) (string, *authdb.AccessPassword, error) {
+	err := engine.Authorize(requestor, PermManageAccessPasswords)
+	if err != nil {
+		return "", nil, err
+	}
+	pass, err := generateCode(accessPasswordLength)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to generate access password")
+	}
+	password := &authdb.AccessPassword{
+		Label:        label,
+		PasswordHash: hashAccessPassword(pass),
+		CreatedByID:  &requestor,
+		MaxUses:      maxUses,
+	}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		password.ExpiresAt = &expiresAt
+	}
+	err = engine.dbExecutor.CreateAccessPassword(password)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to create access password")
+	}
+	after := map[string]interface{}{"Label": label, "ExpiresAt": password.ExpiresAt, "MaxUses": maxUses}
+	engine.audit(&requestor, AuditCreateAccessPassword, authdb.AuditTargetAccessPassword, accessPasswordTarget(password.ID), nil, after)
+	return pass, password, nil
+}
+
+func (engine *ServerPermsEngine) AdminGetAccessPasswords(requestor authdb.ActorId) ([]authdb.AccessPassword, error) {
+	err := engine.Authorize(requestor, PermManageAccessPasswords)
+	if err != nil {
+		return nil, err
+	}
+	return engine.dbExecutor.GetActiveAccessPasswords()
+}
+
+type ErrorUnknownAccessPassword struct {
+	PasswordId uint
+}
+
+func (e ErrorUnknownAccessPassword) Error() string {
+	return fmt.Sprintf("there is no active access password %d", e.PasswordId)
+}
+
+func (e ErrorUnknownAccessPassword) Is(target error) bool {
+	_, ok := target.(ErrorUnknownAccessPassword)
+	return ok
+}
+
+// actors which have already entered the password keep their access
+func (engine *ServerPermsEngine) AdminRevokeAccessPassword(requestor authdb.ActorId, passwordId uint) error {
+	err := engine.Authorize(requestor, PermManageAccessPasswords)
+	if err != nil {
+		return err
+	}
+	err = engine.dbExecutor.RevokeAccessPassword(passwordId)
+	if err != nil {
+		if errors.Is(err, authdb.ErrorAccessPasswordUnavailable{}) {
+			return ErrorUnknownAccessPassword{PasswordId: passwordId}
+		}
+		return errors.Wrap(err, "failed to revoke access password")
+	}
+	engine.audit(&requestor, AuditRevokeAccessPassword, authdb.AuditTargetAccessPassword, accessPasswordTarget(passwordId), nil, nil)
+	return nil
+}
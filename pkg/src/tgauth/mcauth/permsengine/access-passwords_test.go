@@ -0,0 +1,70 @@
+package permsengine
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+func TestAccessPasswords(t *testing.T) {
+	engine := initEngine(t)
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	firstId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "first"})
+	secondId := registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "second"})
+
+	// the secret password of initEngine is imported
+	passwords, err := engine.AdminGetAccessPasswords(adminId)
+	if err != nil || len(passwords) != 1 || passwords[0].Label != SecretAccessPasswordLabel {
+		t.Fatalf("Expected imported secret password, got %v %v", passwords, err)
+	}
+	_, _, err = engine.AdminCreateAccessPassword(firstId, "friends", 0, nil)
+	if !errors.Is(err, ErrorPermissionDenied{}) {
+		t.Fatalf("Only admins create passwords, got %v", err)
+	}
+	maxUses := 1
+	pass, password, err := engine.AdminCreateAccessPassword(adminId, "friends", time.Hour, &maxUses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.HandleAccessPassword(firstId, "wrong")
+	if !errors.Is(err, ErrorWrongAccessPassword{}) {
+		t.Fatalf("Expected wrong password, got %v", err)
+	}
+	err = engine.HandleAccessPassword(firstId, " "+pass+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := getActor(t, engine, firstId)
+	if !first.EnteredAccessPass || first.AccessPasswordID == nil || *first.AccessPasswordID != password.ID {
+		t.Fatalf("The used password is not recorded %v", first.AccessPasswordID)
+	}
+	err = engine.HandleAccessPassword(secondId, pass)
+	if !errors.Is(err, ErrorWrongAccessPassword{}) {
+		t.Fatalf("Used up password is accepted, got %v", err)
+	}
+
+	err = engine.AdminRevokeAccessPassword(adminId, passwords[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.HandleAccessPassword(secondId, "password")
+	if !errors.Is(err, ErrorWrongAccessPassword{}) {
+		t.Fatalf("Revoked password is accepted, got %v", err)
+	}
+	err = engine.AdminRevokeAccessPassword(adminId, passwords[0].ID)
+	if !errors.Is(err, ErrorUnknownAccessPassword{}) {
+		t.Fatalf("Expected unknown password, got %v", err)
+	}
+	// the revoked secret is not imported again on restart
+	err = engine.importSecretAccessPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err = engine.AdminGetAccessPasswords(adminId)
+	if err != nil || len(passwords) != 0 {
+		t.Fatalf("Expected no active passwords, got %v %v", passwords, err)
+	}
+}
@@ -0,0 +1,243 @@
+package permsengine
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	AuditVerifyActor                = "verify_actor"
+	AuditRejectActor                = "reject_actor"
+	AuditBanActor                   = "ban_actor"
+	AuditUnbanActor                 = "unban_actor"
+	AuditSetRole                    = "set_role"
+	AuditSetLoginLimit              = "set_login_limit"
+	AuditRunCommand                 = "run_command"
+	AuditEnterAccessPassword        = "enter_access_password"
+	AuditSeenInChat                 = "seen_in_chat"
+	AuditLeftChat                   = "left_chat"
+	AuditUpdateActorStatus          = "update_actor_status"
+	AuditUpdateTgUser               = "update_tg_user"
+	AuditAssignMinecraftLogin       = "assign_minecraft_login"
+	AuditRevokeMinecraftLogin       = "revoke_minecraft_login"
+	AuditSetPassword                = "set_password"
+	AuditApproveChat                = "approve_chat"
+	AuditStartOwnershipVerification = "start_ownership_verification"
+	AuditMigrateMinecraftAccount    = "migrate_minecraft_account"
+	AuditMigratePlayerData          = "migrate_player_data"
+	AuditRenameMinecraftAccount     = "rename_minecraft_account"
+	AuditCreateInviteCode           = "create_invite_code"
+	AuditRedeemInviteCode           = "redeem_invite_code"
+	AuditReviewInvitees             = "review_invitees"
+	AuditCreateAccessPassword       = "create_access_password"
+	AuditRevokeAccessPassword       = "revoke_access_password"
+)
+
+// Returns the engine which marks audit events with the telegram update causing them
+func (engine *ServerPermsEngine) ForTgUpdate(updateId int) *ServerPermsEngine {
+	scoped := *engine
+	scoped.tgUpdateId = &updateId
+	return &scoped
+}
+
+type auditBan struct {
+	Reason   string
+	Duration time.Duration
+}
+
+// fields of the actor which audited actions change
+type actorSnapshot struct {
+	IsAdmin           bool
+	Role              string
+	Accepted          bool
+	EnteredAccessPass bool
+	AccessPassword    *uint
+	VerifiedBy        []authdb.ActorId
+	InvitedBy         *authdb.ActorId
+	Chats             []authdb.TgChatId
+	LeftChats         []authdb.TgChatId
+	Bans              []auditBan
+	MinecraftAccounts []mojang.MinecraftLogin
+}
+
+func snapshotActor(actor *authdb.Actor) *actorSnapshot {
+	snapshot := &actorSnapshot{
+		IsAdmin:           actor.IsAdmin,
+		Role:              actor.Role,
+		Accepted:          actor.Accepted,
+		EnteredAccessPass: actor.EnteredAccessPass,
+		AccessPassword:    actor.AccessPasswordID,
+		InvitedBy:         actor.InvitedByID,
+	}
+	for _, admin := range actor.VerifiedByAdmins {
+		snapshot.VerifiedBy = append(snapshot.VerifiedBy, admin.ID)
+	}
+	for _, chat := range actor.SeenInChats {
+		snapshot.Chats = append(snapshot.Chats, chat.ID)
+	}
+	for _, membership := range actor.ChatMemberships {
+		if membership.LeftAt != nil {
+			snapshot.LeftChats = append(snapshot.LeftChats, membership.TgChatID)
+		}
+	}
+	for _, ban := range actor.Bans {
+		snapshot.Bans = append(snapshot.Bans, auditBan{Reason: ban.Reason, Duration: ban.BanDuration})
+	}
+	for _, acc := range actor.MinecraftAccounts {
+		snapshot.MinecraftAccounts = append(snapshot.MinecraftAccounts, acc.ID)
+	}
+	return snapshot
+}
+
+func (engine *ServerPermsEngine) getActorSnapshot(actorId authdb.ActorId) (*actorSnapshot, error) {
+	actor := authdb.Actor{ID: actorId}
+	err := engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotActor(&actor), nil
+}
+
+type accountSnapshot struct {
+	ActorId  *authdb.ActorId
+	IsOnline bool
+	PlayerId string
+}
+
+// nil if the account does not exist
+func (engine *ServerPermsEngine) getAccountSnapshot(login mojang.MinecraftLogin) (*accountSnapshot, error) {
+	account, err := engine.dbExecutor.OptionalGetMinecraftAccount(login)
+	if err != nil || account == nil || account.ActorID == nil {
+		return nil, err
+	}
+	return &accountSnapshot{
+		ActorId:  account.ActorID,
+		IsOnline: account.IsOnline,
+		PlayerId: account.PlayerID,
+	}, nil
+}
+
+func actorTarget(actorId authdb.ActorId) string {
+	return strconv.FormatUint(uint64(actorId), 10)
+}
+
+func marshalSnapshot(snapshot interface{}) (string, error) {
+	if snapshot == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	if string(data) == "null" {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+// Records the event of an already committed change. Failures are logged and not
+// returned, the caller can't undo the change anyway. Actor is nil for automatic changes
+func (engine *ServerPermsEngine) audit(
+	actor *authdb.ActorId,
+	action string,
+	targetType string,
+	targetId string,
+	before interface{},
+	after interface{},
+) {
+	err := engine.recordAuditEvent(actor, action, targetType, targetId, before, after)
+	if err != nil {
+		engine.logAuditFailure(action, targetType, targetId, err)
+	}
+}
+
+func (engine *ServerPermsEngine) logAuditFailure(action string, targetType string, targetId string, err error) {
+	engine.logger.Error("failed to audit committed change",
+		zap.String("action", action),
+		zap.String("target_type", targetType),
+		zap.String("target_id", targetId),
+		zap.Error(err),
+	)
+}
+
+func (engine *ServerPermsEngine) recordAuditEvent(
+	actor *authdb.ActorId,
+	action string,
+	targetType string,
+	targetId string,
+	before interface{},
+	after interface{},
+) error {
+	event := &authdb.AuditEvent{
+		ActorID:    actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetId,
+		TgUpdateID: engine.tgUpdateId,
+	}
+	var err error
+	event.Before, err = marshalSnapshot(before)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s audit snapshot", action)
+	}
+	event.After, err = marshalSnapshot(after)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s audit snapshot", action)
+	}
+	err = engine.dbExecutor.RecordAuditEvent(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to record audit event")
+	}
+	return nil
+}
+
+// audits change of the actor made by requestor
+func (engine *ServerPermsEngine) auditActor(
+	requestor *authdb.ActorId,
+	action string,
+	actorId authdb.ActorId,
+	before *actorSnapshot,
+) {
+	after, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		engine.logAuditFailure(action, authdb.AuditTargetActor, actorTarget(actorId), errors.Wrap(err, "failed to get actor for audit"))
+		return
+	}
+	engine.audit(requestor, action, authdb.AuditTargetActor, actorTarget(actorId), before, after)
+}
+
+// audits change of the account made by requestor
+func (engine *ServerPermsEngine) auditAccount(
+	requestor *authdb.ActorId,
+	action string,
+	login mojang.MinecraftLogin,
+	before *accountSnapshot,
+) {
+	after, err := engine.getAccountSnapshot(login)
+	if err != nil {
+		engine.logAuditFailure(action, authdb.AuditTargetMinecraftAccount, string(login), errors.Wrap(err, "failed to get account for audit"))
+		return
+	}
+	engine.audit(requestor, action, authdb.AuditTargetMinecraftAccount, string(login), before, after)
+}
+
+func (engine *ServerPermsEngine) AdminGetAuditEvents(
+	requestor authdb.ActorId,
+	filter authdb.AuditEventFilter,
+) ([]authdb.AuditEvent, error) {
+	err := engine.Authorize(requestor, PermViewAudit)
+	if err != nil {
+		return nil, err
+	}
+	events, err := engine.dbExecutor.GetAuditEvents(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get audit events")
+	}
+	return events, nil
+}
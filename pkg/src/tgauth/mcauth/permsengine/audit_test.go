@@ -0,0 +1,141 @@
+package permsengine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func initEngine(t *testing.T) *ServerPermsEngine {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	executor, err := authdb.NewAuthDbExecutor(db, authdb.DefaultAuthDbExecutorConfig, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	config := DefaultServerPermsEngineConfig
+	config.AdminTgIds = []authdb.TgUserId{1}
+	engine, err := NewServerPermsEngine(config, executor, "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func registerTgUser(t *testing.T, engine *ServerPermsEngine, user tgbotapi.User) authdb.ActorId {
+	err := engine.UpdateTgUserInfo(user)
+	if err != nil {
+		t.Fatalf("Failed to update tg user: %v", err)
+	}
+	actor := authdb.Actor{}
+	err = engine.GetActorByTgUser(authdb.TgUserId(user.ID), &actor)
+	if err != nil {
+		t.Fatalf("Failed to get actor: %v", err)
+	}
+	err = engine.UpdateActorStatus(actor.ID, false)
+	if err != nil {
+		t.Fatalf("Failed to update actor status: %v", err)
+	}
+	return actor.ID
+}
+
+func TestAuditOfAdminActions(t *testing.T) {
+	engine := initEngine(t)
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "user"})
+
+	_, err := engine.AdminGetAuditEvents(userId, authdb.AuditEventFilter{})
+	if !errors.Is(err, ErrorPermissionDenied{}) {
+		t.Fatalf("Only admins can read the audit log, got %v", err)
+	}
+	// repeated messages are not audited
+	err = engine.UpdateTgUserInfo(tgbotapi.User{ID: 2, UserName: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := engine.AdminGetAuditEvents(adminId, authdb.AuditEventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tg users of both, admin status of the admin and the imported secret password
+	if len(events) != 4 || events[3].Action != AuditCreateAccessPassword || events[0].Action != AuditUpdateTgUser || events[1].Action != AuditUpdateActorStatus || events[1].ActorID != nil {
+		t.Fatalf("Unexpected events %v", events)
+	}
+
+	err = engine.ForTgUpdate(7).AdminVerifyActor(adminId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = engine.AdminGetAuditEvents(adminId, authdb.AuditEventFilter{
+		TargetType: authdb.AuditTargetActor,
+		TargetID:   actorTarget(userId),
+	})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected verification of the user, got %v %v", events, err)
+	}
+	event := events[0]
+	if event.Action != AuditVerifyActor || *event.ActorID != adminId || event.TgUpdateID == nil || *event.TgUpdateID != 7 {
+		t.Fatalf("Unexpected event %v", event)
+	}
+	if strings.Contains(event.Before, `"VerifiedBy":[`) || !strings.Contains(event.After, `"VerifiedBy":[1]`) {
+		t.Fatalf("Unexpected snapshots %s -> %s", event.Before, event.After)
+	}
+	// the engine itself is not scoped
+	_, err = engine.AdminBanActor(adminId, userId, time.Hour, "griefing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = engine.AdminGetAuditEvents(adminId, authdb.AuditEventFilter{ActorID: &adminId, Limit: 1})
+	if err != nil || len(events) != 1 || events[0].Action != AuditBanActor || events[0].TgUpdateID != nil {
+		t.Fatalf("Expected ban without update, got %v %v", events, err)
+	}
+}
+
+func TestAuditFailureKeepsCommittedChange(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	executor, err := authdb.NewAuthDbExecutor(db, authdb.DefaultAuthDbExecutorConfig, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	config := DefaultServerPermsEngineConfig
+	config.AdminTgIds = []authdb.TgUserId{1}
+	engine, err := NewServerPermsEngine(config, executor, "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "user"})
+
+	err = db.Migrator().DropTable(&authdb.AuditEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.AdminVerifyActor(adminId, userId)
+	if err != nil {
+		t.Fatalf("Audit failure should not fail the committed change, got %v", err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil {
+		t.Fatalf("Audit failure should not fail the status update, got %v", err)
+	}
+	if !getActor(t, engine, userId).Accepted {
+		t.Fatal("Verified user is not accepted")
+	}
+}
@@ -0,0 +1,116 @@
+package permsengine
+
+import (
+	"time"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+// chat memberships must be preloaded
+func hasLeftChat(actor *authdb.Actor, chatId authdb.TgChatId) bool {
+	for _, membership := range actor.ChatMemberships {
+		if membership.TgChatID == chatId {
+			return membership.LeftAt != nil
+		}
+	}
+	return false
+}
+
+// the actor left the chat less than the grace period before the moment
+func (engine *ServerPermsEngine) isInChatAt(actor *authdb.Actor, chatId authdb.TgChatId, now time.Time) bool {
+	for _, membership := range actor.ChatMemberships {
+		if membership.TgChatID == chatId && membership.LeftAt != nil {
+			return now.Before(membership.LeftAt.Add(engine.config.ChatLeaveGracePeriod))
+		}
+	}
+	return true
+}
+
+// Marks the chat as left. Returns the moment the actor loses access if they do not come back, nil if
+// they keep it
+func (engine *ServerPermsEngine) LeftChat(actorId authdb.ActorId, chatId authdb.TgChatId) (*time.Time, error) {
+	before, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get actor")
+	}
+	left, err := engine.dbExecutor.LeftChat(actorId, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if !left {
+		return nil, nil
+	}
+	engine.auditActor(nil, AuditLeftChat, actorId, before)
+	err = engine.UpdateActorStatus(actorId, true)
+	if err != nil {
+		return nil, err
+	}
+	actor := authdb.Actor{ID: actorId}
+	err = engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get actor")
+	}
+	lossAt := time.Now().Add(engine.config.ChatLeaveGracePeriod)
+	if !actor.Accepted || engine.computeActorAcceptedStatusAt(&actor, lossAt) {
+		return nil, nil
+	}
+	return &lossAt, nil
+}
+
+// Re-evaluates actors whose grace period after leaving a chat is over, returns those which lost access
+func (engine *ServerPermsEngine) RemoveActorsAfterChatLeaves() ([]authdb.ActorId, error) {
+	actorIds, err := engine.dbExecutor.GetAcceptedActorIdsLeftChatsBefore(time.Now().Add(-engine.config.ChatLeaveGracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	removed := []authdb.ActorId{}
+	for _, actorId := range actorIds {
+		err = engine.UpdateActorStatus(actorId, true)
+		if err != nil {
+			return removed, errors.Wrapf(err, "failed to update status of actor %d", actorId)
+		}
+		actor := authdb.Actor{ID: actorId}
+		err = engine.dbExecutor.GetActor(&actor)
+		if err != nil {
+			return removed, errors.Wrap(err, "failed to get actor")
+		}
+		if !actor.Accepted {
+			removed = append(removed, actorId)
+		}
+	}
+	return removed, nil
+}
+
+func (engine *ServerPermsEngine) GetApprovedChatMemberships() ([]authdb.ActorSeenInChats, error) {
+	return engine.dbExecutor.GetApprovedChatMemberships()
+}
+
+// Accepted actors which were seen in approved chats but have left all of them. They keep access
+// during the grace period or for another reason
+func (engine *ServerPermsEngine) GetAcceptedActorsOutsideApprovedChats() ([]authdb.Actor, error) {
+	actors, err := engine.dbExecutor.GetAllActors()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get actors")
+	}
+	outside := []authdb.Actor{}
+	for _, actor := range actors {
+		if !actor.Accepted {
+			continue
+		}
+		seenInApproved, inApproved := false, false
+		for _, chat := range actor.SeenInChats {
+			if !chat.Approved {
+				continue
+			}
+			seenInApproved = true
+			if !hasLeftChat(&actor, chat.ID) {
+				inApproved = true
+			}
+		}
+		if seenInApproved && !inApproved {
+			outside = append(outside, actor)
+		}
+	}
+	return outside, nil
+}
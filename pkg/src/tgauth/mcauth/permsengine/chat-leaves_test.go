@@ -0,0 +1,82 @@
+package permsengine
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+)
+
+func TestChatLeaves(t *testing.T) {
+	engine := initEngine(t)
+	engine.config.ChatLeaveGracePeriod = time.Hour
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "user"})
+	chatId := authdb.TgChatId(-100)
+
+	err := engine.SeenInChat(adminId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.ApproveChat(adminId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.SeenInChat(userId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil || !getActor(t, engine, userId).Accepted {
+		t.Fatalf("User of approved chat is not accepted %v", err)
+	}
+
+	lossAt, err := engine.LeftChat(userId, chatId)
+	if err != nil || lossAt == nil {
+		t.Fatalf("Expected warning about access loss, got %v %v", lossAt, err)
+	}
+	if !getActor(t, engine, userId).Accepted {
+		t.Fatal("Access is lost during the grace period")
+	}
+	removed, err := engine.RemoveActorsAfterChatLeaves()
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("Nobody is removed during the grace period, got %v %v", removed, err)
+	}
+	// leaving again is not noticed
+	lossAt, err = engine.LeftChat(userId, chatId)
+	if err != nil || lossAt != nil {
+		t.Fatalf("Repeated leave %v %v", lossAt, err)
+	}
+
+	// coming back cancels the removal
+	err = engine.SeenInChat(userId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.config.ChatLeaveGracePeriod = 0
+	removed, err = engine.RemoveActorsAfterChatLeaves()
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("Returned user is removed %v %v", removed, err)
+	}
+
+	engine.config.ChatLeaveGracePeriod = time.Hour
+	_, err = engine.LeftChat(userId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if the grace period is over
+	engine.config.ChatLeaveGracePeriod = 0
+	removed, err = engine.RemoveActorsAfterChatLeaves()
+	if err != nil || len(removed) != 1 || removed[0] != userId {
+		t.Fatalf("Expected removal of the user, got %v %v", removed, err)
+	}
+	if getActor(t, engine, userId).Accepted {
+		t.Fatal("Access is kept after the grace period")
+	}
+	// the admin has access anyway
+	lossAt, err = engine.LeftChat(adminId, chatId)
+	if err != nil || lossAt != nil || !getActor(t, engine, adminId).Accepted {
+		t.Fatalf("Admin loses access %v %v", lossAt, err)
+	}
+}
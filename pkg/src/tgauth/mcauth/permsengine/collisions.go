@@ -0,0 +1,167 @@
+package permsengine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+// cracked account whose name is also an official account
+type UuidCollision struct {
+	ActorId   authdb.ActorId
+	Login     mojang.MinecraftLogin
+	OfflineId uuid.UUID
+	// uuid.Nil if only seen on the server
+	OnlineId uuid.UUID
+	// official owner has already joined the server
+	SeenOnServer bool
+}
+
+// Compares cracked accounts against Mojang and the server user cache
+func (engine *ServerPermsEngine) DetectOfflineCollisions(ctx context.Context, client *mojang.Client) ([]UuidCollision, error) {
+	accounts, err := engine.dbExecutor.GetOfflineMinecraftAccounts()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get offline accounts")
+	}
+	names := make([]mojang.MinecraftLogin, 0, len(accounts))
+	for _, acc := range accounts {
+		names = append(names, acc.ID)
+	}
+	profiles, err := client.QueryProfiles(names, ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query profiles")
+	}
+	userCache, err := engine.dbExecutor.GetUserCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user cache")
+	}
+	// by lowercase name, player ids the server has seen with the name
+	seenIds := map[string][]string{}
+	for _, entry := range userCache {
+		key := strings.ToLower(string(entry.Name))
+		seenIds[key] = append(seenIds[key], entry.Uuid)
+	}
+	collisions := []UuidCollision{}
+	for _, acc := range accounts {
+		key := strings.ToLower(string(acc.ID))
+		collision := UuidCollision{
+			ActorId:   *acc.ActorID,
+			Login:     acc.ID,
+			OfflineId: mojang.GetOfflineUuid(acc.ID),
+		}
+		profile, found := profiles[key]
+		if found {
+			collision.OnlineId = profile.Id
+		}
+		for _, seenId := range seenIds[key] {
+			id, err := uuid.Parse(seenId)
+			if err != nil || id == collision.OfflineId {
+				continue
+			}
+			collision.SeenOnServer = true
+		}
+		if found || collision.SeenOnServer {
+			collisions = append(collisions, collision)
+		}
+	}
+	return collisions, nil
+}
+
+// actors receiving alerts
+func (engine *ServerPermsEngine) GetAdmins() ([]authdb.Actor, error) {
+	actors, err := engine.dbExecutor.GetAllActors()
+	if err != nil {
+		return nil, err
+	}
+	admins := []authdb.Actor{}
+	for _, actor := range actors {
+		if engine.HasPermission(&actor, PermReceiveAlerts) {
+			admins = append(admins, actor)
+		}
+	}
+	return admins, nil
+}
+
+type ErrorNotCrackedAccount struct {
+	Login mojang.MinecraftLogin
+}
+
+func (e ErrorNotCrackedAccount) Error() string {
+	return fmt.Sprintf("%s is not a registered cracked account", e.Login)
+}
+
+func (e ErrorNotCrackedAccount) Is(target error) bool {
+	_, ok := target.(ErrorNotCrackedAccount)
+	return ok
+}
+
+// Moves a cracked account to a new name together with its player data
+func (engine *ServerPermsEngine) AdminMigrateMinecraftAccount(
+	requestor authdb.ActorId,
+	oldLogin mojang.MinecraftLogin,
+	newLogin mojang.MinecraftLogin,
+) error {
+	err := engine.Authorize(requestor, PermMigratePlayers)
+	if err != nil {
+		return err
+	}
+	account, err := engine.dbExecutor.OptionalGetMinecraftAccount(oldLogin)
+	if err != nil {
+		return errors.Wrap(err, "failed to get account")
+	}
+	if account == nil || account.ActorID == nil || account.IsOnline {
+		return ErrorNotCrackedAccount{Login: oldLogin}
+	}
+	newAccount, err := engine.dbExecutor.OptionalGetMinecraftAccount(newLogin)
+	if err != nil {
+		return errors.Wrap(err, "failed to get account")
+	}
+	if newAccount != nil && newAccount.ActorID != nil {
+		return authdb.ErrorLoginTaken{Login: newLogin}
+	}
+	fromId := mojang.GetOfflineUuid(oldLogin)
+	toId := mojang.GetOfflineUuid(newLogin)
+	before, err := engine.getAccountSnapshot(oldLogin)
+	if err != nil {
+		return errors.Wrap(err, "failed to get account")
+	}
+	// the account is moved first, player data migration can't be a part of the db
+	// transaction, so the account is moved back if it fails. The overseer restores
+	// moved files on failure, if it could not the account stays with them
+	err = engine.dbExecutor.MigrateMinecraftAccount(oldLogin, newLogin, toId)
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate account")
+	}
+	err = engine.dbExecutor.MigratePlayerData(mcserver.PlayerDataMigrationSpec{
+		FromName:     oldLogin,
+		FromPlayerId: fromId.String(),
+		ToName:       newLogin,
+		ToPlayerId:   toId.String(),
+		RequestedBy:  describeRequestor(requestor),
+	})
+	if errors.Is(err, mcserver.ErrorMigrationNotRolledBack{}) {
+		return err
+	}
+	if err != nil {
+		revertErr := engine.dbExecutor.MigrateMinecraftAccount(newLogin, oldLogin, fromId)
+		if revertErr != nil {
+			return errors.Wrapf(err, "failed to migrate player data, failed to move account back: %v", revertErr)
+		}
+		return errors.Wrap(err, "failed to migrate player data")
+	}
+	after, err := engine.getAccountSnapshot(newLogin)
+	if err != nil {
+		engine.logAuditFailure(AuditMigrateMinecraftAccount, authdb.AuditTargetMinecraftAccount, string(oldLogin), errors.Wrap(err, "failed to get account for audit"))
+		return nil
+	}
+	beforeJson := map[string]interface{}{"Login": oldLogin, "Account": before}
+	afterJson := map[string]interface{}{"Login": newLogin, "Account": after}
+	engine.audit(&requestor, AuditMigrateMinecraftAccount, authdb.AuditTargetMinecraftAccount, string(oldLogin), beforeJson, afterJson)
+	return nil
+}
@@ -0,0 +1,123 @@
+package permsengine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrateAccountMovedBackOnFailure(t *testing.T) {
+	migrationStatus := http.StatusInternalServerError
+	var migrationRecord *mcserver.PlayerMigrationRecord
+	overseer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(migrationStatus)
+		if migrationRecord != nil {
+			json.NewEncoder(w).Encode(migrationRecord)
+		}
+	}))
+	defer overseer.Close()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	dbConfig := authdb.DefaultAuthDbExecutorConfig
+	dbConfig.ServerOverseerUrl = overseer.URL
+	executor, err := authdb.NewAuthDbExecutor(db, dbConfig, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	config := DefaultServerPermsEngineConfig
+	config.AdminTgIds = []authdb.TgUserId{1}
+	engine, err := NewServerPermsEngine(config, executor, "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "user"})
+	err = engine.AdminVerifyActor(adminId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLogin := mojang.MinecraftLogin("Steve")
+	newLogin := mojang.MinecraftLogin("Steve_")
+	err = engine.AssignMinecraftLogin(userId, oldLogin, false, mojang.GetOfflineUuid(oldLogin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.AdminMigrateMinecraftAccount(adminId, oldLogin, newLogin)
+	if err == nil {
+		t.Fatal("Expected failed player data migration")
+	}
+	account, err := engine.OptionalGetMinecraftAccount(oldLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.ActorID == nil || *account.ActorID != userId ||
+		account.PlayerID != mojang.GetOfflineUuid(oldLogin).String() {
+		t.Fatalf("Account is not moved back %+v", account)
+	}
+	account, err = engine.OptionalGetMinecraftAccount(newLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account != nil && account.ActorID != nil {
+		t.Fatalf("New login is still assigned %+v", account)
+	}
+
+	// the overseer restored the files, the account is moved back too
+	migrationRecord = &mcserver.PlayerMigrationRecord{Error: "disk failure", MovedFiles: []string{"a"}, RolledBack: true}
+	err = engine.AdminMigrateMinecraftAccount(adminId, oldLogin, newLogin)
+	if err == nil || errors.Is(err, mcserver.ErrorMigrationNotRolledBack{}) {
+		t.Fatalf("Expected rolled back migration failure, got %v", err)
+	}
+	account, err = engine.OptionalGetMinecraftAccount(oldLogin)
+	if err != nil || account == nil || account.ActorID == nil {
+		t.Fatalf("Account is not moved back %+v %v", account, err)
+	}
+
+	// files are left moved, the account stays with them
+	migrationRecord = &mcserver.PlayerMigrationRecord{Error: "disk failure", MovedFiles: []string{"a"}, RollbackError: "disk failure"}
+	err = engine.AdminMigrateMinecraftAccount(adminId, oldLogin, newLogin)
+	var notRolledBack mcserver.ErrorMigrationNotRolledBack
+	if !errors.As(err, &notRolledBack) || len(notRolledBack.Record.MovedFiles) != 1 {
+		t.Fatalf("Expected failed rollback to be reported, got %v", err)
+	}
+	account, err = engine.OptionalGetMinecraftAccount(newLogin)
+	if err != nil || account == nil || account.PlayerID != mojang.GetOfflineUuid(newLogin).String() {
+		t.Fatalf("Account should stay with the moved files %+v %v", account, err)
+	}
+	err = engine.dbExecutor.MigrateMinecraftAccount(newLogin, oldLogin, mojang.GetOfflineUuid(oldLogin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrationStatus = http.StatusOK
+	migrationRecord = nil
+	err = engine.AdminMigrateMinecraftAccount(adminId, oldLogin, newLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = engine.OptionalGetMinecraftAccount(newLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.PlayerID != mojang.GetOfflineUuid(newLogin).String() {
+		t.Fatalf("Account is not migrated %+v", account)
+	}
+}
@@ -0,0 +1,165 @@
+package permsengine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+// long enough to not be guessed, fits in the /start deep link
+const inviteCodeLength = 12
+
+type ErrorInviteQuotaExceeded struct {
+	Quota int
+}
+
+func (e ErrorInviteQuotaExceeded) Error() string {
+	return fmt.Sprintf("invite quota of %d codes is exceeded", e.Quota)
+}
+
+func (e ErrorInviteQuotaExceeded) Is(target error) bool {
+	_, ok := target.(ErrorInviteQuotaExceeded)
+	return ok
+}
+
+type ErrorAlreadyInvited struct {
+	ActorId authdb.ActorId
+}
+
+func (e ErrorAlreadyInvited) Error() string {
+	return fmt.Sprintf("actor %d is already invited", e.ActorId)
+}
+
+func (e ErrorAlreadyInvited) Is(target error) bool {
+	_, ok := target.(ErrorAlreadyInvited)
+	return ok
+}
+
+type ErrorAlreadyAccepted struct {
+	ActorId authdb.ActorId
+}
+
+func (e ErrorAlreadyAccepted) Error() string {
+	return fmt.Sprintf("actor %d is already accepted", e.ActorId)
+}
+
+func (e ErrorAlreadyAccepted) Is(target error) bool {
+	_, ok := target.(ErrorAlreadyAccepted)
+	return ok
+}
+
+// Expired codes which were not redeemed do not count
+func (engine *ServerPermsEngine) GetInviteCodes(actorId authdb.ActorId) (codes []authdb.InviteCode, remaining int, err error) {
+	codes, err = engine.dbExecutor.GetInviteCodesOf(actorId)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to get invite codes")
+	}
+	remaining = engine.config.InviteQuota
+	now := time.Now()
+	for _, code := range codes {
+		if code.RedeemedBy != nil || code.IsActive(now) {
+			remaining--
+		}
+	}
+	return codes, max(remaining, 0), nil
+}
+
+// Only accepted actors which are not banned can invite
+func (engine *ServerPermsEngine) CreateInviteCode(actorId authdb.ActorId) (*authdb.InviteCode, error) {
+	actor := authdb.Actor{ID: actorId}
+	err := engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get actor")
+	}
+	err = checkAccepted(&actor)
+	if err != nil {
+		return nil, err
+	}
+	_, remaining, err := engine.GetInviteCodes(actorId)
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		return nil, ErrorInviteQuotaExceeded{Quota: engine.config.InviteQuota}
+	}
+	code, err := generateCode(inviteCodeLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate code")
+	}
+	inviteCode := &authdb.InviteCode{
+		Code:      code,
+		InviterID: actorId,
+		ExpiresAt: time.Now().Add(engine.config.InviteCodeDuration),
+	}
+	err = engine.dbExecutor.CreateInviteCode(inviteCode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create invite code")
+	}
+	// the code is a secret of the actor
+	after := map[string]interface{}{"InviteId": inviteCode.ID, "ExpiresAt": inviteCode.ExpiresAt}
+	engine.audit(&actorId, AuditCreateInviteCode, authdb.AuditTargetActor, actorTarget(actorId), nil, after)
+	return inviteCode, nil
+}
+
+// The actor is accepted while the inviter is accepted and not banned. Only actors without access
+// can redeem a code, and not one of their own invitees, so that actors can't keep each other accepted
+func (engine *ServerPermsEngine) RedeemInviteCode(actorId authdb.ActorId, code string) (*authdb.InviteCode, error) {
+	before, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get actor")
+	}
+	if before.InvitedBy != nil {
+		return nil, ErrorAlreadyInvited{ActorId: actorId}
+	}
+	if before.Accepted {
+		return nil, ErrorAlreadyAccepted{ActorId: actorId}
+	}
+	inviteCode, err := engine.dbExecutor.RedeemInviteCode(code, actorId)
+	if err != nil {
+		return nil, err
+	}
+	engine.auditActor(&actorId, AuditRedeemInviteCode, actorId, before)
+	err = engine.UpdateActorStatus(actorId, false)
+	if err != nil {
+		return nil, err
+	}
+	return inviteCode, nil
+}
+
+// Re-evaluates acceptance of actors invited by the actor, usually after the actor is banned.
+// Returns invitees which lost access
+func (engine *ServerPermsEngine) AdminReviewInvitees(requestor authdb.ActorId, actorId authdb.ActorId) ([]authdb.Actor, error) {
+	err := engine.Authorize(requestor, PermBanActor)
+	if err != nil {
+		return nil, err
+	}
+	invitees, err := engine.dbExecutor.GetInvitees(actorId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get invitees")
+	}
+	revoked := []authdb.Actor{}
+	revokedIds := []authdb.ActorId{}
+	for _, invitee := range invitees {
+		if !invitee.Accepted {
+			continue
+		}
+		err = engine.UpdateActorStatus(invitee.ID, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to update status of invitee %d", invitee.ID)
+		}
+		updated := authdb.Actor{ID: invitee.ID}
+		err = engine.dbExecutor.GetActor(&updated)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get invitee")
+		}
+		if !updated.Accepted {
+			revoked = append(revoked, updated)
+			revokedIds = append(revokedIds, updated.ID)
+		}
+	}
+	after := map[string]interface{}{"Invitees": len(invitees), "Revoked": revokedIds}
+	engine.audit(&requestor, AuditReviewInvitees, authdb.AuditTargetActor, actorTarget(actorId), nil, after)
+	return revoked, nil
+}
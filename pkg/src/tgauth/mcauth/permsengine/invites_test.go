@@ -0,0 +1,143 @@
+package permsengine
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+func TestInvites(t *testing.T) {
+	engine := initEngine(t)
+	engine.config.InviteQuota = 2
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	inviterId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "inviter"})
+	inviteeId := registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "invitee"})
+
+	_, err := engine.CreateInviteCode(inviterId)
+	if !errors.Is(err, ErrorNotAccepted{}) {
+		t.Fatalf("Only accepted actors can invite, got %v", err)
+	}
+	err = engine.AdminVerifyActor(adminId, inviterId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(inviterId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := engine.CreateInviteCode(inviterId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the inviter is accepted, so can't redeem any code including the own one
+	_, err = engine.RedeemInviteCode(inviterId, code.Code)
+	if !errors.Is(err, ErrorAlreadyAccepted{}) {
+		t.Fatalf("Own code can't be redeemed, got %v", err)
+	}
+	redeemed, err := engine.RedeemInviteCode(inviteeId, code.Code)
+	if err != nil || redeemed.InviterID != inviterId {
+		t.Fatalf("Failed to redeem %v %v", redeemed, err)
+	}
+	if !getActor(t, engine, inviteeId).Accepted {
+		t.Fatal("Invited actor is not accepted")
+	}
+	otherId := registerTgUser(t, engine, tgbotapi.User{ID: 4, UserName: "other"})
+	_, err = engine.RedeemInviteCode(otherId, code.Code)
+	if !errors.Is(err, authdb.ErrorInvalidInviteCode{}) {
+		t.Fatalf("Code is single-use, got %v", err)
+	}
+
+	// the redeemed code still counts
+	_, err = engine.CreateInviteCode(inviterId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = engine.CreateInviteCode(inviterId)
+	if !errors.Is(err, ErrorInviteQuotaExceeded{}) {
+		t.Fatalf("Expected exceeded quota, got %v", err)
+	}
+
+	_, err = engine.AdminBanActor(adminId, inviterId, time.Hour, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without the review the invitee keeps access
+	err = engine.UpdateActorStatus(inviteeId, false)
+	if err != nil || !getActor(t, engine, inviteeId).Accepted {
+		t.Fatalf("Invitee lost access without review %v", err)
+	}
+	revoked, err := engine.AdminReviewInvitees(adminId, inviterId)
+	if err != nil || len(revoked) != 1 || revoked[0].ID != inviteeId {
+		t.Fatalf("Expected the invitee to lose access, got %v %v", revoked, err)
+	}
+}
+
+func TestInviteCycle(t *testing.T) {
+	engine := initEngine(t)
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	firstId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "first"})
+	secondId := registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "second"})
+	thirdId := registerTgUser(t, engine, tgbotapi.User{ID: 4, UserName: "third"})
+
+	err := engine.AdminVerifyActor(adminId, firstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(firstId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := engine.CreateInviteCode(firstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = engine.RedeemInviteCode(secondId, code.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = engine.CreateInviteCode(secondId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = engine.RedeemInviteCode(thirdId, code.Code)
+	if err != nil || !getActor(t, engine, thirdId).Accepted {
+		t.Fatalf("Invitee of invitee is not accepted %v", err)
+	}
+
+	// accepted actors don't need an invite
+	code, err = engine.CreateInviteCode(thirdId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = engine.RedeemInviteCode(firstId, code.Code)
+	if !errors.Is(err, ErrorAlreadyAccepted{}) {
+		t.Fatalf("Accepted actor redeemed a code, got %v", err)
+	}
+
+	// the first actor loses access, the invitees keep theirs until reviewed
+	err = engine.AdminRejectActor(adminId, firstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(firstId, true)
+	if err != nil || getActor(t, engine, firstId).Accepted {
+		t.Fatalf("Rejected actor keeps access %v", err)
+	}
+	_, err = engine.RedeemInviteCode(firstId, code.Code)
+	if !errors.Is(err, authdb.ErrorInviteCycle{}) {
+		t.Fatalf("Expected invite cycle, got %v", err)
+	}
+	first := getActor(t, engine, firstId)
+	if first.Accepted || first.InvitedByID != nil {
+		t.Fatal("Actor is invited by own invitee")
+	}
+	// the code is not spent by the refused redemption
+	fourthId := registerTgUser(t, engine, tgbotapi.User{ID: 5, UserName: "fourth"})
+	_, err = engine.RedeemInviteCode(fourthId, code.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
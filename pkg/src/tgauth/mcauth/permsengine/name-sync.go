@@ -0,0 +1,91 @@
+package permsengine
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+type NameChangeKind string
+
+const (
+	// account was renamed in the db
+	NameChangeRenamed NameChangeKind = "renamed"
+	// official account was renamed but the new name is registered by another actor
+	NameChangeConflict NameChangeKind = "conflict"
+	// official account no longer exists
+	NameChangeProfileGone NameChangeKind = "profile_gone"
+)
+
+type NameChange struct {
+	Kind    NameChangeKind
+	ActorId authdb.ActorId
+	OldName mojang.MinecraftLogin
+	NewName mojang.MinecraftLogin
+	// old name now belongs to another official account
+	OldNameTaken bool
+}
+
+// Re-resolves official accounts by player id and renames them in the db if the owner
+// changed the name at Mojang. Returns changes which should be reported to the owners.
+func (engine *ServerPermsEngine) SyncOnlineAccountNames(ctx context.Context, client *mojang.Client) ([]NameChange, error) {
+	accounts, err := engine.dbExecutor.GetOnlineMinecraftAccounts()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get online accounts")
+	}
+	names := make([]mojang.MinecraftLogin, 0, len(accounts))
+	for _, acc := range accounts {
+		names = append(names, acc.ID)
+	}
+	currentOwners, err := client.QueryProfiles(names, ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query profiles")
+	}
+	changes := []NameChange{}
+	for _, acc := range accounts {
+		playerId, err := uuid.Parse(acc.PlayerID)
+		if err != nil {
+			return changes, errors.Wrapf(err, "failed to parse player id of %s", acc.ID)
+		}
+		owner, found := currentOwners[strings.ToLower(string(acc.ID))]
+		if found && owner.Id == playerId && owner.Name == acc.ID {
+			continue
+		}
+		change := NameChange{
+			ActorId:      *acc.ActorID,
+			OldName:      acc.ID,
+			OldNameTaken: found && owner.Id != playerId,
+		}
+		profile, err := client.QueryProfileById(playerId, ctx)
+		if err != nil {
+			if errors.Is(err, mojang.NoSuchProfileErr{}) {
+				change.Kind = NameChangeProfileGone
+				changes = append(changes, change)
+				continue
+			}
+			return changes, errors.Wrapf(err, "failed to query profile of %s", acc.ID)
+		}
+		if profile.Name == acc.ID {
+			// bulk lookup was stale
+			continue
+		}
+		change.NewName = profile.Name
+		err = engine.dbExecutor.RenameMinecraftAccount(acc.ID, profile.Name)
+		if err != nil {
+			if !errors.Is(err, authdb.ErrorLoginTaken{}) {
+				return changes, errors.Wrapf(err, "failed to rename %s", acc.ID)
+			}
+			change.Kind = NameChangeConflict
+		} else {
+			change.Kind = NameChangeRenamed
+			engine.audit(nil, AuditRenameMinecraftAccount, authdb.AuditTargetMinecraftAccount, string(acc.ID),
+				map[string]interface{}{"Login": acc.ID}, map[string]interface{}{"Login": profile.Name})
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
@@ -0,0 +1,139 @@
+package permsengine
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+// no similar looking characters, the code is typed in minecraft chat
+var ownershipCodeRunes = []rune("ABCDEFGHJKLMNPQRSTUVWXYZ23456789")
+
+const ownershipCodeLength = 6
+
+func generateOwnershipCode() (string, error) {
+	return generateCode(ownershipCodeLength)
+}
+
+func generateCode(length int) (string, error) {
+	b := make([]rune, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(ownershipCodeRunes))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = ownershipCodeRunes[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Official account is only assigned after the player joins the server with it and
+// types the returned code in chat
+func (engine *ServerPermsEngine) StartOwnershipVerification(
+	actorId authdb.ActorId,
+	login mojang.MinecraftLogin,
+	playerId uuid.UUID,
+) (*authdb.OwnershipChallenge, error) {
+	err := engine.CheckAddMinecraftLoginPermission(actorId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check permission")
+	}
+	code, err := generateOwnershipCode()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate code")
+	}
+	challenge := &authdb.OwnershipChallenge{
+		ActorID:   actorId,
+		Login:     login,
+		PlayerID:  playerId.String(),
+		Code:      code,
+		ExpiresAt: time.Now().Add(engine.config.OwnershipChallengeDuration),
+	}
+	err = engine.dbExecutor.CreateOwnershipChallenge(challenge)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ownership challenge")
+	}
+	// the code is a secret of the actor
+	after := map[string]interface{}{"PlayerId": challenge.PlayerID, "ExpiresAt": challenge.ExpiresAt}
+	engine.audit(&actorId, AuditStartOwnershipVerification, authdb.AuditTargetMinecraftAccount, string(login), nil, after)
+	return challenge, nil
+}
+
+func (engine *ServerPermsEngine) UpdateOwnershipChallenges() error {
+	challenges, err := engine.dbExecutor.GetPendingOwnershipChallenges()
+	if err != nil {
+		return errors.Wrap(err, "failed to get pending challenges")
+	}
+	specs := make([]mcserver.OwnershipChallengeSpec, 0, len(challenges))
+	for _, challenge := range challenges {
+		specs = append(specs, mcserver.OwnershipChallengeSpec{
+			Name:     challenge.Login,
+			PlayerId: challenge.PlayerID,
+			Code:     challenge.Code,
+		})
+	}
+	err = engine.dbExecutor.SetOwnershipChallenges(specs)
+	if err != nil {
+		return errors.Wrap(err, "failed to set ownership challenges")
+	}
+	return nil
+}
+
+type OwnershipVerificationResult struct {
+	Challenge authdb.OwnershipChallenge
+	// nil if the account was assigned
+	Err error
+}
+
+// assigns official accounts for challenges confirmed on the server
+func (engine *ServerPermsEngine) CompleteOwnershipVerifications() ([]OwnershipVerificationResult, error) {
+	verifications, err := engine.dbExecutor.GetOwnershipVerifications()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get ownership verifications")
+	}
+	if len(verifications) == 0 {
+		return nil, nil
+	}
+	challenges, err := engine.dbExecutor.GetPendingOwnershipChallenges()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get pending challenges")
+	}
+	challengesByCode := make(map[string]authdb.OwnershipChallenge, len(challenges))
+	for _, challenge := range challenges {
+		challengesByCode[challenge.Code] = challenge
+	}
+	results := []OwnershipVerificationResult{}
+	for _, verification := range verifications {
+		challenge, ok := challengesByCode[verification.Code]
+		if !ok || challenge.PlayerID != verification.PlayerId {
+			continue
+		}
+		playerId, err := uuid.Parse(challenge.PlayerID)
+		if err != nil {
+			return results, errors.Wrapf(err, "failed to parse player id %s", challenge.PlayerID)
+		}
+		assignErr := engine.AssignMinecraftLogin(challenge.ActorID, challenge.Login, true, playerId)
+		if assignErr != nil &&
+			!errors.Is(assignErr, authdb.ErrorLoginTaken{}) &&
+			!errors.Is(assignErr, ErrorExceededMaxMinecraftLogins{}) &&
+			!errors.Is(assignErr, ErrorNotAccepted{}) &&
+			!errors.Is(assignErr, ErrorActorBanned{}) {
+			return results, errors.Wrap(assignErr, "failed to assign minecraft login")
+		}
+		err = engine.dbExecutor.CompleteOwnershipChallenge(challenge.ID)
+		if err != nil {
+			return results, errors.Wrap(err, "failed to complete challenge")
+		}
+		results = append(results, OwnershipVerificationResult{
+			Challenge: challenge,
+			Err:       assignErr,
+		})
+	}
+	return results, nil
+}
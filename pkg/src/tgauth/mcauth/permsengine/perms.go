@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"regexp"
+	"strconv"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -12,56 +13,80 @@ import (
 	"github.com/imobulus/subchat-mc-server/src/mojang"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 type ServerPermsEngineConfig struct {
 	CacheInvalidationDuration   time.Duration `yaml:"cache_invalidation_duration"`
 	DefaultMinecraftLoginsLimit int           `yaml:"default_minecraft_logins_limit"`
-	AdminTags                   []string      `yaml:"admin_tags"`
+	// telegram user ids of the owners, they can't be changed or claimed unlike usernames
+	AdminTgIds []authdb.TgUserId `yaml:"admin_tg_ids"`
+	// Deprecated: usernames only keep admin status of existing admins and never grant it,
+	// use admin_tg_ids instead
+	AdminTags                  []string      `yaml:"admin_tags"`
+	AdminOpLevel               int           `yaml:"admin_op_level"`
+	OwnershipChallengeDuration time.Duration `yaml:"ownership_challenge_duration"`
+	// an approved chat the actor left still gives access for this time
+	ChatLeaveGracePeriod time.Duration `yaml:"chat_leave_grace_period"`
+	// number of active or redeemed invite codes of an actor
+	InviteQuota        int           `yaml:"invite_quota"`
+	InviteCodeDuration time.Duration `yaml:"invite_code_duration"`
+	// permissions of each role, roles missing here have none
+	RolePermissions map[Role][]Permission `yaml:"role_permissions"`
 }
 
 var DefaultServerPermsEngineConfig = ServerPermsEngineConfig{
 	CacheInvalidationDuration:   5 * time.Minute,
 	DefaultMinecraftLoginsLimit: 2,
+	AdminOpLevel:                4,
+	OwnershipChallengeDuration:  30 * time.Minute,
+	ChatLeaveGracePeriod:        24 * time.Hour,
+	InviteQuota:                 3,
+	InviteCodeDuration:          7 * 24 * time.Hour,
+	RolePermissions:             DefaultRolePermissions,
 }
 
 type ServerPermsEngine struct {
-	config         ServerPermsEngineConfig
-	dbExecutor     *authdb.AuthDbExecutor
-	adminTags      map[string]struct{}
-	random         *rand.Rand
-	accessPassword string
-}
-
-type ErrorAdminPermissionDenied struct {
-	Msg string
-}
-
-func (e ErrorAdminPermissionDenied) Error() string {
-	return "admin permission denied, " + e.Msg
-}
-
-func (e ErrorAdminPermissionDenied) Is(target error) bool {
-	_, ok := target.(ErrorAdminPermissionDenied)
-	return ok
-}
-
+	config     ServerPermsEngineConfig
+	dbExecutor *authdb.AuthDbExecutor
+	adminTgIds map[authdb.TgUserId]struct{}
+	adminTags  map[string]struct{}
+	// called when the engine grants or revokes admin status
+	adminStatusListener func(change AdminStatusChange)
+	random              *rand.Rand
+	// set by ForTgUpdate
+	tgUpdateId *int
+	logger     *zap.Logger
+}
+
+// accessPassword from the secret is imported to the database once, empty to skip
 func NewServerPermsEngine(
 	config ServerPermsEngineConfig,
 	dbExecutor *authdb.AuthDbExecutor,
 	accessPassword string,
+	logger *zap.Logger,
 ) (*ServerPermsEngine, error) {
+	adminTgIds := map[authdb.TgUserId]struct{}{}
+	for _, id := range config.AdminTgIds {
+		adminTgIds[id] = struct{}{}
+	}
 	adminTags := map[string]struct{}{}
 	for _, tag := range config.AdminTags {
 		adminTags[tag] = struct{}{}
 	}
-	return &ServerPermsEngine{
-		config:         config,
-		dbExecutor:     dbExecutor,
-		adminTags:      adminTags,
-		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
-		accessPassword: accessPassword,
-	}, nil
+	engine := &ServerPermsEngine{
+		config:     config,
+		dbExecutor: dbExecutor,
+		adminTgIds: adminTgIds,
+		adminTags:  adminTags,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger:     logger,
+	}
+	err := engine.importSecretAccessPassword(accessPassword)
+	if err != nil {
+		return nil, err
+	}
+	return engine, nil
 }
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -74,39 +99,23 @@ func (engine *ServerPermsEngine) GeneratePassword() string {
 	return string(b)
 }
 
-func (engine *ServerPermsEngine) IsAdmin(actorId authdb.ActorId) (bool, error) {
-	actor := authdb.Actor{ID: actorId}
-	err := engine.dbExecutor.GetActor(&actor)
-	if err != nil {
-		return false, err
-	}
-	return actor.IsAdmin, nil
-
-}
-
-func (engine *ServerPermsEngine) CheckAdminPermission(requestor authdb.ActorId) error {
-	isAdmin, err := engine.IsAdmin(requestor)
-	if err != nil {
-		return errors.Wrap(err, "failed to check permission")
-	}
-	if !isAdmin {
-		return ErrorAdminPermissionDenied{"can't verify actor"}
-	}
-	return nil
-}
-
 func (engine *ServerPermsEngine) AdminVerifyActor(
 	requestor authdb.ActorId,
 	actorId authdb.ActorId,
 ) error {
-	err := engine.CheckAdminPermission(requestor)
+	err := engine.Authorize(requestor, PermVerifyActor)
 	if err != nil {
 		return errors.Wrap(err, "failed to check permission")
 	}
+	before, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
 	err = engine.dbExecutor.VerifiedByAdmin(actorId, requestor)
 	if err != nil {
 		return errors.Wrap(err, "failed to verify actor")
 	}
+	engine.auditActor(&requestor, AuditVerifyActor, actorId, before)
 	return nil
 }
 
@@ -114,84 +123,132 @@ func (engine *ServerPermsEngine) AdminRejectActor(
 	requestor authdb.ActorId,
 	actorId authdb.ActorId,
 ) error {
-	err := engine.CheckAdminPermission(requestor)
+	err := engine.Authorize(requestor, PermVerifyActor)
 	if err != nil {
 		return errors.Wrap(err, "failed to check permission")
 	}
+	before, err := engine.getActorSnapshot(actorId)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
 	err = engine.dbExecutor.RejectAdminVerifications(actorId)
 	if err != nil {
 		return errors.Wrap(err, "failed to verify actor")
 	}
+	engine.auditActor(&requestor, AuditRejectActor, actorId, before)
 	return nil
 }
 
+// 0 duration means permanent ban
 func (engine *ServerPermsEngine) AdminBanActor(
 	requestor authdb.ActorId,
 	actorId authdb.ActorId,
-) error {
-	err := engine.CheckAdminPermission(requestor)
-	if err != nil {
-		return errors.Wrap(err, "failed to check permission")
-	}
-	err = engine.dbExecutor.BanActor(actorId, 0, "banned by admin")
+	duration time.Duration,
+	reason string,
+) (*authdb.Ban, error) {
+	err := engine.Authorize(requestor, PermBanActor)
 	if err != nil {
-		return errors.Wrap(err, "failed to block actor")
+		return nil, errors.Wrap(err, "failed to check permission")
 	}
-	return nil
-}
-
-func (engine *ServerPermsEngine) AdminListAllUsers(
-	requestor authdb.ActorId,
-) ([]authdb.Actor, error) {
-	err := engine.CheckAdminPermission(requestor)
+	before, err := engine.getActorSnapshot(actorId)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to check permission")
+		return nil, errors.Wrap(err, "failed to get actor")
 	}
-	actors, err := engine.dbExecutor.GetAllActors()
+	ban, err := engine.dbExecutor.BanActor(actorId, duration, reason)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get all actors")
+		return nil, errors.Wrap(err, "failed to block actor")
 	}
-	return actors, nil
+	engine.auditActor(&requestor, AuditBanActor, actorId, before)
+	engine.syncBans()
+	return ban, nil
 }
 
-type ErrorWrongAccessPassword struct{}
+type ErrorNotBanned struct {
+	ActorId authdb.ActorId
+}
 
-func (e ErrorWrongAccessPassword) Error() string {
-	return "wrong access password"
+func (e ErrorNotBanned) Error() string {
+	return fmt.Sprintf("actor %d is not banned", e.ActorId)
 }
 
-func (e ErrorWrongAccessPassword) Is(target error) bool {
-	_, ok := target.(ErrorWrongAccessPassword)
+func (e ErrorNotBanned) Is(target error) bool {
+	_, ok := target.(ErrorNotBanned)
 	return ok
 }
 
-func (engine *ServerPermsEngine) CheckAccessPassword(pass string) error {
-	if pass != engine.accessPassword {
-		return ErrorWrongAccessPassword{}
+// lifts all bans, the accounts are whitelisted again right away
+func (engine *ServerPermsEngine) AdminUnbanActor(
+	requestor authdb.ActorId,
+	actorId authdb.ActorId,
+) error {
+	err := engine.Authorize(requestor, PermBanActor)
+	if err != nil {
+		return errors.Wrap(err, "failed to check permission")
+	}
+	actor := authdb.Actor{ID: actorId}
+	err = engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
 	}
+	if actor.ActiveBan(time.Now()) == nil {
+		return ErrorNotBanned{ActorId: actorId}
+	}
+	err = engine.dbExecutor.UnbanActor(actorId)
+	if err != nil {
+		return errors.Wrap(err, "failed to unban actor")
+	}
+	engine.auditActor(&requestor, AuditUnbanActor, actorId, snapshotActor(&actor))
+	engine.syncBans()
 	return nil
 }
 
-func (engine *ServerPermsEngine) HandleAccessPassword(actorId authdb.ActorId, pass string) error {
-	err := engine.CheckAccessPassword(pass)
+// Pushes the whitelist and bans to the server without waiting for the periodic update.
+// The ban change is already saved, so failures are only logged and the next update retries
+func (engine *ServerPermsEngine) syncBans() {
+	err := engine.UpdateWhitelist()
 	if err != nil {
-		return errors.Wrap(err, "failed to check access password")
+		engine.logger.Error("failed to update whitelist after ban change", zap.Error(err))
 	}
-	err = engine.dbExecutor.EnteredCorrectPassword(actorId)
+	err = engine.UpdateBans()
 	if err != nil {
-		return errors.Wrap(err, "failed to enter correct password")
+		engine.logger.Error("failed to update bans after ban change", zap.Error(err))
 	}
-	return nil
 }
 
+func (engine *ServerPermsEngine) AdminListAllUsers(
+	requestor authdb.ActorId,
+) ([]authdb.Actor, error) {
+	err := engine.Authorize(requestor, PermListUsers)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check permission")
+	}
+	actors, err := engine.dbExecutor.GetAllActors()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all actors")
+	}
+	return actors, nil
+}
+
+// only the first message of the actor in the chat is audited
 func (engine *ServerPermsEngine) SeenInChat(
 	actorId authdb.ActorId,
 	chatId authdb.TgChatId,
 ) error {
-	err := engine.dbExecutor.SeenInChat(actorId, chatId)
+	actor := authdb.Actor{ID: actorId}
+	err := engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
+	for _, chat := range actor.SeenInChats {
+		if chat.ID == chatId && !hasLeftChat(&actor, chatId) {
+			return nil
+		}
+	}
+	err = engine.dbExecutor.SeenInChat(actorId, chatId)
 	if err != nil {
 		return errors.Wrap(err, "failed to update seen in chat")
 	}
+	engine.auditActor(&actorId, AuditSeenInChat, actorId, snapshotActor(&actor))
 	return nil
 }
 
@@ -239,7 +296,7 @@ func checkAccepted(actor *authdb.Actor) error {
 	if !actor.Accepted {
 		return ErrorNotAccepted{actor.ID}
 	}
-	if len(actor.Bans) > 0 {
+	if actor.ActiveBan(time.Now()) != nil {
 		return ErrorActorBanned{actor.ID}
 	}
 	return nil
@@ -255,7 +312,7 @@ func (engine *ServerPermsEngine) GetMinecraftLoginLimitByActor(actorId authdb.Ac
 }
 
 func (engine *ServerPermsEngine) getMinecraftLoginLimitByActor(actor *authdb.Actor) int {
-	if actor.IsAdmin {
+	if engine.HasPermission(actor, PermUnlimitedLogins) {
 		return -1
 	}
 	limit := engine.config.DefaultMinecraftLoginsLimit
@@ -302,13 +359,22 @@ func (engine *ServerPermsEngine) AssignMinecraftLogin(
 	if err != nil {
 		return errors.Wrap(err, "failed to check permission")
 	}
+	before, err := engine.getAccountSnapshot(login)
+	if err != nil {
+		return errors.Wrap(err, "failed to get account")
+	}
 	err = engine.dbExecutor.AddMinecraftLogin(actorId, login, isOnline, uuid)
 	if err != nil {
 		return errors.Wrap(err, "failed to add minecraft login")
 	}
+	engine.auditAccount(&actorId, AuditAssignMinecraftLogin, login, before)
 	return nil
 }
 
+func (engine *ServerPermsEngine) GetActor(actor *authdb.Actor) error {
+	return engine.dbExecutor.GetActor(actor)
+}
+
 func (engine *ServerPermsEngine) GetActorByTgUser(tgUserId authdb.TgUserId, actor *authdb.Actor) error {
 	err := engine.dbExecutor.GetActorByTgUser(tgUserId, actor)
 	return err
@@ -319,9 +385,40 @@ func (engine *ServerPermsEngine) GetActorByTgUserName(username string, actor *au
 	return err
 }
 
+type tgUserSnapshot struct {
+	ActorId  authdb.ActorId
+	UserName string
+}
+
+// only creation and username changes are audited, they affect admin status
 func (engine *ServerPermsEngine) UpdateTgUserInfo(tguser tgbotapi.User) error {
-	err := engine.dbExecutor.UpdateTgUserInfo(tguser)
-	return err
+	user, err := engine.dbExecutor.OptionalGetTgUser(authdb.TgUserId(tguser.ID))
+	if err != nil {
+		return err
+	}
+	err = engine.dbExecutor.UpdateTgUserInfo(tguser)
+	if err != nil {
+		return err
+	}
+	var before *tgUserSnapshot
+	if user != nil {
+		if user.LastSeenInfo.UserName == tguser.UserName {
+			return nil
+		}
+		before = &tgUserSnapshot{ActorId: user.ActorID, UserName: user.LastSeenInfo.UserName}
+	}
+	targetId := strconv.FormatInt(tguser.ID, 10)
+	user, err = engine.dbExecutor.OptionalGetTgUser(authdb.TgUserId(tguser.ID))
+	if err == nil && user == nil {
+		err = errors.Errorf("tg user %d is missing after update", tguser.ID)
+	}
+	if err != nil {
+		engine.logAuditFailure(AuditUpdateTgUser, authdb.AuditTargetTgUser, targetId, errors.Wrap(err, "failed to get tg user for audit"))
+		return nil
+	}
+	after := &tgUserSnapshot{ActorId: user.ActorID, UserName: tguser.UserName}
+	engine.audit(&user.ActorID, AuditUpdateTgUser, authdb.AuditTargetTgUser, targetId, before, after)
+	return nil
 }
 
 type ErrorNotYourLogin struct {
@@ -372,30 +469,43 @@ func (engine *ServerPermsEngine) RevokeMinecraftLogin(
 	if err != nil {
 		return errors.Wrap(err, "failed to check permission")
 	}
+	before, err := engine.getAccountSnapshot(login)
+	if err != nil {
+		return errors.Wrap(err, "failed to get account")
+	}
 	err = engine.dbExecutor.RevokeMinecraftLogin(login)
 	if err != nil {
 		return errors.Wrap(err, "failed to revoke minecraft login")
 	}
+	engine.auditAccount(&actorId, AuditRevokeMinecraftLogin, login, before)
 	return nil
 }
 
 func (engine *ServerPermsEngine) computeActorAcceptedStatus(actor *authdb.Actor) bool {
-	if actor.IsAdmin {
+	return engine.computeActorAcceptedStatusAt(actor, time.Now())
+}
+
+// whether the actor would be accepted at the moment if nothing changes until then
+func (engine *ServerPermsEngine) computeActorAcceptedStatusAt(actor *authdb.Actor, now time.Time) bool {
+	if ActorRole(actor) != RoleMember {
 		return true
 	}
 	if actor.EnteredAccessPass {
 		return true
 	}
 	for _, maybeAdmin := range actor.VerifiedByAdmins {
-		if maybeAdmin.IsAdmin {
+		if engine.HasPermission(maybeAdmin, PermVerifyActor) {
 			return true
 		}
 	}
 	for _, chat := range actor.SeenInChats {
-		if chat.Approved {
+		if chat.Approved && engine.isInChatAt(actor, chat.ID, now) {
 			return true
 		}
 	}
+	if actor.InvitedBy != nil && actor.InvitedBy.Accepted && actor.InvitedBy.ActiveBan(now) == nil {
+		return true
+	}
 	return false
 }
 
@@ -411,10 +521,20 @@ func (engine *ServerPermsEngine) updateAcceptedStatus(actor *authdb.Actor, doRem
 	if err != nil {
 		return errors.Wrap(err, "failed to update accept status")
 	}
+	actor.Accepted = accepted
 	return nil
 }
 
 func (engine *ServerPermsEngine) computeAdminStatus(actor *authdb.Actor) bool {
+	for _, tgAccount := range actor.TgAccounts {
+		if _, ok := engine.adminTgIds[tgAccount.ID]; ok {
+			return true
+		}
+	}
+	if !actor.IsAdmin {
+		return false
+	}
+	// legacy admins keep their status until their ids are moved to the config
 	for _, tgAccount := range actor.TgAccounts {
 		if _, ok := engine.adminTags[tgAccount.LastSeenInfo.UserName]; ok {
 			return true
@@ -432,6 +552,7 @@ func (engine *ServerPermsEngine) updateAdminStatus(actor *authdb.Actor) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to update admin status")
 	}
+	actor.IsAdmin = isAdmin
 	return nil
 }
 
@@ -441,6 +562,7 @@ func (engine *ServerPermsEngine) UpdateActorStatus(actorId authdb.ActorId, doRem
 	if err != nil {
 		return err
 	}
+	before := snapshotActor(&actor)
 	err = engine.updateAcceptedStatus(&actor, doRemove)
 	if err != nil {
 		return errors.Wrap(err, "failed to update accept status")
@@ -449,6 +571,14 @@ func (engine *ServerPermsEngine) UpdateActorStatus(actorId authdb.ActorId, doRem
 	if err != nil {
 		return errors.Wrap(err, "failed to update accept status")
 	}
+	after := snapshotActor(&actor)
+	if before.Accepted == after.Accepted && before.IsAdmin == after.IsAdmin {
+		return nil
+	}
+	engine.audit(nil, AuditUpdateActorStatus, authdb.AuditTargetActor, actorTarget(actorId), before, after)
+	if before.IsAdmin != after.IsAdmin && engine.adminStatusListener != nil {
+		engine.adminStatusListener(AdminStatusChange{Actor: actor})
+	}
 	return nil
 }
 
@@ -483,6 +613,17 @@ func (engine *ServerPermsEngine) UpdateWhitelist() error {
 			})
 		}
 	}
+	// players have to join to prove ownership
+	challenges, err := engine.dbExecutor.GetPendingOwnershipChallenges()
+	if err != nil {
+		return errors.Wrap(err, "failed to get pending challenges")
+	}
+	for _, challenge := range challenges {
+		accounts = append(accounts, mcserver.MinecraftAccountSpec{
+			Name:     challenge.Login,
+			PlayerId: challenge.PlayerID,
+		})
+	}
 	err = engine.dbExecutor.SetWhitelist(accounts)
 	if err != nil {
 		return errors.Wrap(err, "failed to set whitelist")
@@ -490,6 +631,89 @@ func (engine *ServerPermsEngine) UpdateWhitelist() error {
 	return nil
 }
 
+// operators get operator rights on all of their minecraft accounts
+func (engine *ServerPermsEngine) UpdateOps() error {
+	actors, err := engine.dbExecutor.GetAcceptedActorsWithAccounts()
+	if err != nil {
+		return errors.Wrap(err, "failed to get accepted actors with accounts")
+	}
+	ops := []mcserver.OpSpec{}
+	for _, actor := range actors {
+		if !engine.HasPermission(&actor, PermOperator) {
+			continue
+		}
+		for _, acc := range actor.MinecraftAccounts {
+			ops = append(ops, mcserver.OpSpec{
+				Name:                acc.ID,
+				PlayerId:            acc.PlayerID,
+				Level:               engine.config.AdminOpLevel,
+				BypassesPlayerLimit: true,
+			})
+		}
+	}
+	err = engine.dbExecutor.SetOps(ops)
+	if err != nil {
+		return errors.Wrap(err, "failed to set ops")
+	}
+	return nil
+}
+
+// overseer maps roles to luckperms groups
+func (engine *ServerPermsEngine) UpdateRoles() error {
+	actors, err := engine.dbExecutor.GetAcceptedActorsWithAccounts()
+	if err != nil {
+		return errors.Wrap(err, "failed to get accepted actors with accounts")
+	}
+	roles := []mcserver.AccountRolesSpec{}
+	for _, actor := range actors {
+		for _, acc := range actor.MinecraftAccounts {
+			roles = append(roles, mcserver.AccountRolesSpec{
+				Name:     acc.ID,
+				PlayerId: acc.PlayerID,
+				Roles:    inheritedRoles(&actor),
+			})
+		}
+	}
+	err = engine.dbExecutor.SetRoles(roles)
+	if err != nil {
+		return errors.Wrap(err, "failed to set roles")
+	}
+	return nil
+}
+
+const banSource = "Subchat bot"
+
+// bans all minecraft accounts of banned actors, expired bans are lifted
+func (engine *ServerPermsEngine) UpdateBans() error {
+	actors, err := engine.dbExecutor.GetBannedActorsWithAccounts()
+	if err != nil {
+		return errors.Wrap(err, "failed to get banned actors with accounts")
+	}
+	now := time.Now()
+	bans := []mcserver.PlayerBanSpec{}
+	for _, actor := range actors {
+		activeBan := actor.ActiveBan(now)
+		if activeBan == nil {
+			continue
+		}
+		for _, acc := range actor.MinecraftAccounts {
+			bans = append(bans, mcserver.PlayerBanSpec{
+				Name:     acc.ID,
+				PlayerId: acc.PlayerID,
+				Reason:   activeBan.Reason,
+				Source:   banSource,
+				Created:  activeBan.CreatedAt,
+				Expires:  activeBan.ExpiresAt(),
+			})
+		}
+	}
+	err = engine.dbExecutor.SetBans(bans)
+	if err != nil {
+		return errors.Wrap(err, "failed to set bans")
+	}
+	return nil
+}
+
 var passwordRegex = regexp.MustCompile(`^[a-zA-Z0-9]{8,}$`)
 
 const passwordRegexDescription = "Пароль должен состоять из не менее 8 латинских букв и цифр"
@@ -532,26 +756,22 @@ func (engine *ServerPermsEngine) SetPassword(actorId authdb.ActorId, minecraftLo
 	if err != nil {
 		return errors.Wrap(err, "failed to set password")
 	}
-	return nil
-}
-
-func (engine *ServerPermsEngine) CheckApproveChatPermission(actorId authdb.ActorId) error {
-	actor := authdb.Actor{ID: actorId}
-	err := engine.dbExecutor.GetActor(&actor)
-	if err != nil {
-		return err
-	}
-	if !actor.IsAdmin {
-		return ErrorAdminPermissionDenied{"can't approve chat"}
-	}
+	// the password itself is never recorded
+	engine.audit(&actorId, AuditSetPassword, authdb.AuditTargetMinecraftAccount, string(minecraftLogin), nil, nil)
 	return nil
 }
 
 func (engine *ServerPermsEngine) ApproveChat(actorId authdb.ActorId, chatId authdb.TgChatId) error {
-	err := engine.CheckApproveChatPermission(actorId)
+	err := engine.Authorize(actorId, PermApproveChat)
 	if err != nil {
 		return errors.Wrap(err, "failed to check permission to approve chat")
 	}
-	engine.dbExecutor.ApproveChat(chatId, actorId)
+	err = engine.dbExecutor.ApproveChat(chatId, actorId)
+	if err != nil {
+		return errors.Wrap(err, "failed to approve chat")
+	}
+	after := map[string]interface{}{"Approved": true, "ApprovedBy": actorId}
+	targetId := strconv.FormatInt(int64(chatId), 10)
+	engine.audit(&actorId, AuditApproveChat, authdb.AuditTargetTgChat, targetId, nil, after)
 	return nil
 }
@@ -0,0 +1,112 @@
+package permsengine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestBanChangesReachServer(t *testing.T) {
+	var mu sync.Mutex
+	var whitelist []mcserver.MinecraftAccountSpec
+	var bans []mcserver.PlayerBanSpec
+	overseer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/set-whitelist":
+			json.NewDecoder(r.Body).Decode(&whitelist)
+		case "/set-bans":
+			json.NewDecoder(r.Body).Decode(&bans)
+		}
+	}))
+	defer overseer.Close()
+	whitelisted := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.ContainsFunc(whitelist, func(acc mcserver.MinecraftAccountSpec) bool { return acc.Name == "Steve" })
+	}
+	banned := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.ContainsFunc(bans, func(ban mcserver.PlayerBanSpec) bool { return ban.Name == "Steve" })
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	dbConfig := authdb.DefaultAuthDbExecutorConfig
+	dbConfig.ServerOverseerUrl = overseer.URL
+	executor, err := authdb.NewAuthDbExecutor(db, dbConfig, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	config := DefaultServerPermsEngineConfig
+	config.AdminTgIds = []authdb.TgUserId{1}
+	engine, err := NewServerPermsEngine(config, executor, "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "user"})
+	err = engine.AdminVerifyActor(adminId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.AssignMinecraftLogin(userId, "Steve", false, mojang.GetOfflineUuid("Steve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.AdminBanActor(adminId, userId, 100*time.Millisecond, "griefing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whitelisted() || !banned() {
+		t.Fatalf("Ban was not pushed to the server: %v %v", whitelist, bans)
+	}
+	time.Sleep(150 * time.Millisecond)
+	err = engine.UpdateWhitelist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateBans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !whitelisted() || banned() {
+		t.Fatalf("Lapsed ban should whitelist the accounts again: %v %v", whitelist, bans)
+	}
+
+	_, err = engine.AdminBanActor(adminId, userId, 0, "griefing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whitelisted() || !banned() {
+		t.Fatalf("Ban was not pushed to the server: %v %v", whitelist, bans)
+	}
+	err = engine.AdminUnbanActor(adminId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !whitelisted() || banned() {
+		t.Fatalf("Unban was not pushed to the server: %v %v", whitelist, bans)
+	}
+}
@@ -0,0 +1,83 @@
+package permsengine
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+func describeRequestor(requestor authdb.ActorId) string {
+	return fmt.Sprintf("actor %d", requestor)
+}
+
+type ErrorInvalidPlayerRef struct {
+	Ref string
+}
+
+func (e ErrorInvalidPlayerRef) Error() string {
+	return fmt.Sprintf("%s is neither a player id nor a minecraft login", e.Ref)
+}
+
+func (e ErrorInvalidPlayerRef) Is(target error) bool {
+	_, ok := target.(ErrorInvalidPlayerRef)
+	return ok
+}
+
+// Player is referenced by id or by login. Player id of a login is taken from the
+// registered account, unregistered logins are treated as cracked.
+func (engine *ServerPermsEngine) resolvePlayerRef(ref string) (mojang.MinecraftLogin, uuid.UUID, error) {
+	playerId, err := uuid.Parse(ref)
+	if err == nil {
+		return "", playerId, nil
+	}
+	login, err := mojang.MakeMinecraftLogin(ref)
+	if err != nil {
+		return "", uuid.Nil, ErrorInvalidPlayerRef{Ref: ref}
+	}
+	account, err := engine.dbExecutor.OptionalGetMinecraftAccount(login)
+	if err != nil {
+		return "", uuid.Nil, errors.Wrap(err, "failed to get account")
+	}
+	if account == nil || account.ActorID == nil || account.PlayerID == "" {
+		return login, mojang.GetOfflineUuid(login), nil
+	}
+	playerId, err = uuid.Parse(account.PlayerID)
+	if err != nil {
+		return "", uuid.Nil, errors.Wrapf(err, "failed to parse player id of %s", login)
+	}
+	return login, playerId, nil
+}
+
+// Moves inventory, stats and advancements between player ids, e.g. when a player
+// switched from cracked to official account. The server is restarted for the migration.
+func (engine *ServerPermsEngine) AdminMigratePlayerData(requestor authdb.ActorId, fromRef string, toRef string) error {
+	err := engine.Authorize(requestor, PermMigratePlayers)
+	if err != nil {
+		return err
+	}
+	fromName, fromId, err := engine.resolvePlayerRef(fromRef)
+	if err != nil {
+		return err
+	}
+	toName, toId, err := engine.resolvePlayerRef(toRef)
+	if err != nil {
+		return err
+	}
+	err = engine.dbExecutor.MigratePlayerData(mcserver.PlayerDataMigrationSpec{
+		FromName:     fromName,
+		FromPlayerId: fromId.String(),
+		ToName:       toName,
+		ToPlayerId:   toId.String(),
+		RequestedBy:  describeRequestor(requestor),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate player data")
+	}
+	after := map[string]interface{}{"ToName": toName, "ToPlayerId": toId.String()}
+	engine.audit(&requestor, AuditMigratePlayerData, authdb.AuditTargetPlayer, fromId.String(), nil, after)
+	return nil
+}
@@ -0,0 +1,270 @@
+package permsengine
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+type Role string
+
+const (
+	RoleMember    Role = "member"
+	RoleTrusted   Role = "trusted"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+	RoleOwner     Role = "owner"
+)
+
+// from the lowest to the highest, a role can only be managed by higher roles
+var rolesByRank = []Role{RoleMember, RoleTrusted, RoleModerator, RoleAdmin, RoleOwner}
+
+func AllRoles() []Role {
+	return slices.Clone(rolesByRank)
+}
+
+func roleRank(role Role) int {
+	return slices.Index(rolesByRank, role)
+}
+
+func ParseRole(text string) (Role, error) {
+	role := Role(text)
+	if roleRank(role) < 0 {
+		return "", ErrorUnknownRole{Role: text}
+	}
+	return role, nil
+}
+
+type ErrorUnknownRole struct {
+	Role string
+}
+
+func (e ErrorUnknownRole) Error() string {
+	return fmt.Sprintf("unknown role %s", e.Role)
+}
+
+func (e ErrorUnknownRole) Is(target error) bool {
+	_, ok := target.(ErrorUnknownRole)
+	return ok
+}
+
+type Permission string
+
+const (
+	PermVerifyActor    Permission = "verify"
+	PermBanActor       Permission = "ban"
+	PermApproveChat    Permission = "approve_chat"
+	PermListUsers      Permission = "list_users"
+	PermWhois          Permission = "whois"
+	PermViewAudit      Permission = "view_audit"
+	PermSetLimits      Permission = "set_limits"
+	PermRunCommands    Permission = "run_commands"
+	PermMigratePlayers Permission = "migrate_players"
+	PermAssignRoles    Permission = "assign_roles"
+	// create and revoke access passwords
+	PermManageAccessPasswords Permission = "manage_access_passwords"
+	// operator rights on the minecraft server
+	PermOperator        Permission = "operator"
+	PermReceiveAlerts   Permission = "receive_alerts"
+	PermUnlimitedLogins Permission = "unlimited_logins"
+)
+
+var moderatorPermissions = []Permission{
+	PermVerifyActor,
+	PermBanActor,
+	PermApproveChat,
+	PermListUsers,
+	PermWhois,
+	PermViewAudit,
+}
+
+var adminPermissions = append(slices.Clone(moderatorPermissions),
+	PermSetLimits,
+	PermRunCommands,
+	PermMigratePlayers,
+	PermAssignRoles,
+	PermManageAccessPasswords,
+	PermOperator,
+	PermReceiveAlerts,
+	PermUnlimitedLogins,
+)
+
+var DefaultRolePermissions = map[Role][]Permission{
+	RoleMember:    {},
+	RoleTrusted:   {},
+	RoleModerator: moderatorPermissions,
+	RoleAdmin:     adminPermissions,
+	RoleOwner:     adminPermissions,
+}
+
+type ErrorPermissionDenied struct {
+	Permission Permission
+}
+
+func (e ErrorPermissionDenied) Error() string {
+	return fmt.Sprintf("permission %s denied", e.Permission)
+}
+
+func (e ErrorPermissionDenied) Is(target error) bool {
+	_, ok := target.(ErrorPermissionDenied)
+	return ok
+}
+
+// Admins from the config are owners, roles of others are assigned in the bot
+func ActorRole(actor *authdb.Actor) Role {
+	if actor.IsAdmin {
+		return RoleOwner
+	}
+	role, err := ParseRole(actor.Role)
+	if err != nil {
+		return RoleMember
+	}
+	return role
+}
+
+// the role and all roles below it, overseer maps them to luckperms groups
+func inheritedRoles(actor *authdb.Actor) []string {
+	roles := []string{}
+	for _, role := range rolesByRank[:roleRank(ActorRole(actor))+1] {
+		roles = append(roles, string(role))
+	}
+	return roles
+}
+
+func (engine *ServerPermsEngine) HasPermission(actor *authdb.Actor, permission Permission) bool {
+	return slices.Contains(engine.config.RolePermissions[ActorRole(actor)], permission)
+}
+
+// The single check of permissions of engine operations
+func (engine *ServerPermsEngine) Authorize(actorId authdb.ActorId, permission Permission) error {
+	actor := authdb.Actor{ID: actorId}
+	err := engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor to authorize")
+	}
+	if !engine.HasPermission(&actor, permission) {
+		return ErrorPermissionDenied{Permission: permission}
+	}
+	return nil
+}
+
+type ErrorRoleTooHigh struct {
+	Role Role
+}
+
+func (e ErrorRoleTooHigh) Error() string {
+	return fmt.Sprintf("role %s can't be managed by the requestor", e.Role)
+}
+
+func (e ErrorRoleTooHigh) Is(target error) bool {
+	_, ok := target.(ErrorRoleTooHigh)
+	return ok
+}
+
+// Both the current and the new role of the actor must be lower than the role of requestor
+func (engine *ServerPermsEngine) AdminSetRole(requestor authdb.ActorId, actorId authdb.ActorId, role Role) error {
+	err := engine.Authorize(requestor, PermAssignRoles)
+	if err != nil {
+		return err
+	}
+	if roleRank(role) < 0 {
+		return ErrorUnknownRole{Role: string(role)}
+	}
+	requestorActor := authdb.Actor{ID: requestor}
+	err = engine.dbExecutor.GetActor(&requestorActor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get requestor")
+	}
+	actor := authdb.Actor{ID: actorId}
+	err = engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
+	requestorRank := roleRank(ActorRole(&requestorActor))
+	if roleRank(ActorRole(&actor)) >= requestorRank {
+		return ErrorRoleTooHigh{Role: ActorRole(&actor)}
+	}
+	if roleRank(role) >= requestorRank {
+		return ErrorRoleTooHigh{Role: role}
+	}
+	stored := string(role)
+	if role == RoleMember {
+		stored = ""
+	}
+	err = engine.dbExecutor.SetRole(actorId, stored)
+	if err != nil {
+		return errors.Wrap(err, "failed to set role")
+	}
+	engine.auditActor(&requestor, AuditSetRole, actorId, snapshotActor(&actor))
+	return nil
+}
+
+// nil limit resets to the default one
+func (engine *ServerPermsEngine) AdminSetMinecraftLoginLimit(requestor authdb.ActorId, actorId authdb.ActorId, limit *int) error {
+	err := engine.Authorize(requestor, PermSetLimits)
+	if err != nil {
+		return err
+	}
+	actor := authdb.Actor{ID: actorId}
+	err = engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		return errors.Wrap(err, "failed to get actor")
+	}
+	err = engine.dbExecutor.SetMinecraftLoginLimit(actorId, limit)
+	if err != nil {
+		return errors.Wrap(err, "failed to set minecraft login limit")
+	}
+	before := map[string]*int{"MinecraftLoginLimit": actor.CustomMinecraftLoginLimit}
+	after := map[string]*int{"MinecraftLoginLimit": limit}
+	engine.audit(&requestor, AuditSetLoginLimit, authdb.AuditTargetActor, actorTarget(actorId), before, after)
+	return nil
+}
+
+// runs a console command on the minecraft server
+func (engine *ServerPermsEngine) AdminRunCommand(requestor authdb.ActorId, command string) error {
+	err := engine.Authorize(requestor, PermRunCommands)
+	if err != nil {
+		return err
+	}
+	err = engine.dbExecutor.RunServerCommand(command)
+	if err != nil {
+		return errors.Wrap(err, "failed to run command")
+	}
+	after := map[string]string{"Command": command}
+	engine.audit(&requestor, AuditRunCommand, authdb.AuditTargetServer, "", nil, after)
+	return nil
+}
+
+type AdminStatusChange struct {
+	// with the new admin status
+	Actor authdb.Actor
+}
+
+// The listener is called after the change is saved, it must not block for long
+func (engine *ServerPermsEngine) SetAdminStatusListener(listener func(change AdminStatusChange)) {
+	engine.adminStatusListener = listener
+}
+
+// Admins which are kept only by the deprecated admin tags, their tg ids should be moved to the config
+func (engine *ServerPermsEngine) GetLegacyAdmins() ([]authdb.Actor, error) {
+	actors, err := engine.dbExecutor.GetAllActors()
+	if err != nil {
+		return nil, err
+	}
+	legacy := []authdb.Actor{}
+	for _, actor := range actors {
+		if !actor.IsAdmin {
+			continue
+		}
+		byId := slices.ContainsFunc(actor.TgAccounts, func(tgAccount authdb.TgUser) bool {
+			_, ok := engine.adminTgIds[tgAccount.ID]
+			return ok
+		})
+		if !byId {
+			legacy = append(legacy, actor)
+		}
+	}
+	return legacy, nil
+}
@@ -0,0 +1,112 @@
+package permsengine
+
+import (
+	"slices"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+func getActor(t *testing.T, engine *ServerPermsEngine, actorId authdb.ActorId) *authdb.Actor {
+	actor := authdb.Actor{ID: actorId}
+	err := engine.dbExecutor.GetActor(&actor)
+	if err != nil {
+		t.Fatalf("Failed to get actor: %v", err)
+	}
+	return &actor
+}
+
+func TestRoles(t *testing.T) {
+	engine := initEngine(t)
+	ownerId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "admin"})
+	moderatorId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "moderator"})
+	userId := registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "user"})
+
+	err := engine.AdminSetRole(ownerId, moderatorId, RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := getActor(t, engine, moderatorId)
+	if ActorRole(moderator) != RoleModerator || !engine.HasPermission(moderator, PermBanActor) || engine.HasPermission(moderator, PermRunCommands) {
+		t.Fatalf("Unexpected moderator permissions, role %s", moderator.Role)
+	}
+	if !slices.Equal(inheritedRoles(moderator), []string{"member", "trusted", "moderator"}) {
+		t.Fatalf("Unexpected inherited roles %v", inheritedRoles(moderator))
+	}
+	err = engine.AdminSetRole(moderatorId, userId, RoleTrusted)
+	if !errors.Is(err, ErrorPermissionDenied{}) {
+		t.Fatalf("Moderators can't assign roles, got %v", err)
+	}
+	// verification by a moderator makes the user accepted
+	err = engine.AdminVerifyActor(moderatorId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(userId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !getActor(t, engine, userId).Accepted {
+		t.Fatal("User verified by a moderator is not accepted")
+	}
+
+	err = engine.AdminSetRole(ownerId, moderatorId, RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admins can't manage their equals
+	err = engine.AdminSetRole(moderatorId, userId, RoleAdmin)
+	if !errors.Is(err, ErrorRoleTooHigh{}) {
+		t.Fatalf("Expected too high role, got %v", err)
+	}
+	err = engine.AdminSetRole(moderatorId, ownerId, RoleMember)
+	if !errors.Is(err, ErrorRoleTooHigh{}) {
+		t.Fatalf("Expected too high role, got %v", err)
+	}
+	err = engine.AdminSetRole(moderatorId, userId, RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getActor(t, engine, userId).Role != "" {
+		t.Fatal("Member role is stored as the default one")
+	}
+}
+
+func TestAdminIdentity(t *testing.T) {
+	engine := initEngine(t)
+	engine.adminTags = map[string]struct{}{"legacy": {}}
+	changes := []AdminStatusChange{}
+	engine.SetAdminStatusListener(func(change AdminStatusChange) {
+		changes = append(changes, change)
+	})
+	adminId := registerTgUser(t, engine, tgbotapi.User{ID: 1, UserName: "whatever"})
+	if !getActor(t, engine, adminId).IsAdmin || len(changes) != 1 || !changes[0].Actor.IsAdmin {
+		t.Fatalf("Admin by tg id is not granted, changes %v", changes)
+	}
+	// a claimed username does not grant admin
+	impostorId := registerTgUser(t, engine, tgbotapi.User{ID: 2, UserName: "legacy"})
+	if getActor(t, engine, impostorId).IsAdmin {
+		t.Fatal("Admin granted by username")
+	}
+
+	legacyId := registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "legacy"})
+	err := engine.dbExecutor.SetAdmin(legacyId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.UpdateActorStatus(legacyId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyAdmins, err := engine.GetLegacyAdmins()
+	if err != nil || len(legacyAdmins) != 1 || legacyAdmins[0].ID != legacyId {
+		t.Fatalf("Existing admin is not kept by the tag, got %v %v", legacyAdmins, err)
+	}
+	changes = nil
+	registerTgUser(t, engine, tgbotapi.User{ID: 3, UserName: "renamed"})
+	if getActor(t, engine, legacyId).IsAdmin || len(changes) != 1 || changes[0].Actor.IsAdmin {
+		t.Fatalf("Admin status is not revoked after rename, changes %v", changes)
+	}
+}
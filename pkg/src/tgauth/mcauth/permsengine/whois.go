@@ -0,0 +1,48 @@
+package permsengine
+
+import (
+	"github.com/google/uuid"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/pkg/errors"
+)
+
+type PlayerWhois struct {
+	Login    mojang.MinecraftLogin
+	PlayerId uuid.UUID
+	// nil if the login is not registered
+	Owner *authdb.Actor
+	Info  *mcserver.PlayerInfo
+}
+
+// Returns mcserver.ErrorNoPlayerData if the player has never joined
+func (engine *ServerPermsEngine) AdminWhois(requestor authdb.ActorId, ref string) (*PlayerWhois, error) {
+	err := engine.Authorize(requestor, PermWhois)
+	if err != nil {
+		return nil, err
+	}
+	login, playerId, err := engine.resolvePlayerRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	whois := &PlayerWhois{Login: login, PlayerId: playerId}
+	if login != "" {
+		account, err := engine.dbExecutor.OptionalGetMinecraftAccount(login)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get account")
+		}
+		if account != nil && account.ActorID != nil {
+			whois.Owner = &authdb.Actor{ID: *account.ActorID}
+			err = engine.dbExecutor.GetActor(whois.Owner)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get owner")
+			}
+		}
+	}
+	whois.Info, err = engine.dbExecutor.GetPlayerInfo(playerId)
+	if err != nil {
+		return whois, err
+	}
+	return whois, nil
+}
@@ -0,0 +1,282 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+// wrong access passwords of an actor, kept in memory
+type accessAttempts struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+func (bot *TgBot) accessLockedUntil(actorId authdb.ActorId) (time.Time, bool) {
+	bot.accessAttemptsMx.Lock()
+	defer bot.accessAttemptsMx.Unlock()
+	attempts, ok := bot.accessAttempts[actorId]
+	if !ok || attempts.lockedUntil.IsZero() {
+		return time.Time{}, false
+	}
+	if time.Now().After(attempts.lockedUntil) {
+		delete(bot.accessAttempts, actorId)
+		return time.Time{}, false
+	}
+	return attempts.lockedUntil, true
+}
+
+// Returns the end of the lockout if the failure locks the actor out
+func (bot *TgBot) recordAccessFailure(actorId authdb.ActorId) (time.Time, bool) {
+	bot.accessAttemptsMx.Lock()
+	defer bot.accessAttemptsMx.Unlock()
+	attempts, ok := bot.accessAttempts[actorId]
+	if !ok {
+		attempts = &accessAttempts{}
+		bot.accessAttempts[actorId] = attempts
+	}
+	attempts.failures++
+	if attempts.failures < bot.config.AccessMaxAttempts {
+		return time.Time{}, false
+	}
+	attempts.lockedUntil = time.Now().Add(bot.config.AccessLockoutDuration)
+	return attempts.lockedUntil, true
+}
+
+func (bot *TgBot) resetAccessAttempts(actorId authdb.ActorId) {
+	bot.accessAttemptsMx.Lock()
+	defer bot.accessAttemptsMx.Unlock()
+	delete(bot.accessAttempts, actorId)
+}
+
+func describeAccessLockout(until time.Time) string {
+	return "Слишком много неверных паролей, попробуйте снова после " + until.Format(banTimeFormat)
+}
+
+// HTML formatted
+func describeAccessPassword(password *authdb.AccessPassword) string {
+	uses := fmt.Sprintf("%d", password.Uses)
+	if password.MaxUses != nil {
+		uses = fmt.Sprintf("%d/%d", password.Uses, *password.MaxUses)
+	}
+	expires := "бессрочный"
+	if password.ExpiresAt != nil {
+		expires = "до " + password.ExpiresAt.Format(banTimeFormat)
+	}
+	return fmt.Sprintf(
+		"<code>%d</code> %s: использований %s, %s",
+		password.ID, tgbotapi.EscapeText(tgbotapi.ModeHTML, password.Label), uses, expires,
+	)
+}
+
+type AccessPasswordsHandler struct {
+	h *CommonAdminHandler
+}
+
+func (handler *AccessPasswordsHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	passwords, err := handler.h.bot.permsEngine.AdminGetAccessPasswords(actor.ID)
+	if err != nil {
+		return nil, err
+	}
+	text := "Нет активных паролей доступа"
+	if len(passwords) > 0 {
+		lines := []string{"Активные пароли доступа:"}
+		for i := range passwords {
+			lines = append(lines, describeAccessPassword(&passwords[i]))
+		}
+		text = strings.Join(lines, "\n")
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text+"\n\n/new_access_password /revoke_access_password"))
+	return nil, nil
+}
+
+func (handler *AccessPasswordsHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	return nil, nil
+}
+
+func (handler *AccessPasswordsHandler) GetCommands() []tgtypes.BotCommand {
+	return nil
+}
+func (handler *AccessPasswordsHandler) GetHelpDescription() string {
+	return "Пароли доступа"
+}
+func (handler *AccessPasswordsHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+type NewAccessPasswordHandler struct {
+	h         *CommonAdminHandler
+	label     string
+	expiresIn *time.Duration
+	// nil until entered, -1 for unlimited uses
+	maxUses *int
+}
+
+func (handler *NewAccessPasswordHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	var text string
+	switch {
+	case handler.label == "":
+		text = "Введите название пароля, например для кого он"
+	case handler.expiresIn == nil:
+		text = "Введите срок действия, например <code>12h</code>, <code>7d</code> или <code>forever</code>"
+	case handler.maxUses == nil:
+		text = "Введите максимальное число использований или <code>unlimited</code>"
+	default:
+		uses := "без ограничений"
+		if *handler.maxUses >= 0 {
+			uses = strconv.Itoa(*handler.maxUses)
+		}
+		expires := "бессрочно"
+		if *handler.expiresIn > 0 {
+			expires = "до " + time.Now().Add(*handler.expiresIn).Format(banTimeFormat)
+		}
+		text = fmt.Sprintf(
+			"Создать пароль %s? Действует %s, использований %s\n/confirm /abort",
+			tgbotapi.EscapeText(tgbotapi.ModeHTML, handler.label), expires, uses,
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *NewAccessPasswordHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	text := strings.TrimSpace(update.Message.Text)
+	switch {
+	case handler.label == "":
+		handler.label = text
+		return handler, nil
+	case handler.expiresIn == nil:
+		duration, err := parseBanDuration(text)
+		if err != nil {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный срок"))
+			return handler, nil
+		}
+		handler.expiresIn = &duration
+		return handler, nil
+	case handler.maxUses == nil:
+		maxUses := -1
+		if strings.ToLower(text) != "unlimited" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n <= 0 {
+				handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неверное число использований"))
+				return handler, nil
+			}
+			maxUses = n
+		}
+		handler.maxUses = &maxUses
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	var maxUses *int
+	if *handler.maxUses >= 0 {
+		maxUses = handler.maxUses
+	}
+	pass, password, err := handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminCreateAccessPassword(
+		actor.ID, handler.label, *handler.expiresIn, maxUses,
+	)
+	if err != nil {
+		return nil, err
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+		"Пароль создан, он показывается только один раз: <code>%s</code>\n%s",
+		pass, describeAccessPassword(password),
+	)))
+	return nil, nil
+}
+
+func (handler *NewAccessPasswordHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.maxUses == nil {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Создать пароль"},
+		// abort command handler upstream
+	}
+}
+func (handler *NewAccessPasswordHandler) GetHelpDescription() string {
+	return "Сейчас вы создаёте пароль доступа"
+}
+func (handler *NewAccessPasswordHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+type RevokeAccessPasswordHandler struct {
+	h          *CommonAdminHandler
+	passwordId uint
+}
+
+func (handler *RevokeAccessPasswordHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	text := "Введите номер пароля из /access_passwords"
+	if handler.passwordId != 0 {
+		text = fmt.Sprintf(
+			"Отозвать пароль <code>%d</code>? Получившие доступ по нему его сохранят.\n/confirm /abort",
+			handler.passwordId,
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *RevokeAccessPasswordHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.passwordId == 0 {
+		id, err := strconv.ParseUint(strings.TrimSpace(update.Message.Text), 10, 64)
+		if err != nil || id == 0 {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный номер пароля"))
+			return handler, nil
+		}
+		handler.passwordId = uint(id)
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminRevokeAccessPassword(actor.ID, handler.passwordId)
+	text := "Пароль отозван"
+	if err != nil {
+		if !errors.Is(err, permsengine.ErrorUnknownAccessPassword{}) {
+			return nil, err
+		}
+		text = "Нет активного пароля с таким номером"
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return nil, nil
+}
+
+func (handler *RevokeAccessPasswordHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.passwordId == 0 {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Отозвать пароль"},
+		// abort command handler upstream
+	}
+}
+func (handler *RevokeAccessPasswordHandler) GetHelpDescription() string {
+	return "Сейчас вы отзываете пароль доступа"
+}
+func (handler *RevokeAccessPasswordHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
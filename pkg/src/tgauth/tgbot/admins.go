@@ -0,0 +1,40 @@
+package tgbot
+
+import (
+	"fmt"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"go.uber.org/zap"
+)
+
+// Alerts admins about every grant or revocation of admin status, the revoked admin is alerted too
+func (bot *TgBot) notifyAdminStatusChange(change permsengine.AdminStatusChange) {
+	bot.logger.Warn("Admin status changed", zap.Uint("actor_id", uint(change.Actor.ID)), zap.Bool("is admin", change.Actor.IsAdmin))
+	var text string
+	if change.Actor.IsAdmin {
+		text = fmt.Sprintf("Пользователь получил права администратора.\n%s", getUserDescriptionForAdmin(&change.Actor))
+	} else {
+		text = fmt.Sprintf("Пользователь лишён прав администратора.\n%s", getUserDescriptionForAdmin(&change.Actor))
+		bot.NotifyActor(change.Actor.ID, "Вы лишены прав администратора")
+	}
+	bot.NotifyAdmins(text)
+}
+
+// Admins kept by the deprecated admin_tags are logged with the ids to move to admin_tg_ids
+func (bot *TgBot) reportLegacyAdmins() {
+	admins, err := bot.permsEngine.GetLegacyAdmins()
+	if err != nil {
+		bot.logger.Error("Failed to get legacy admins", zap.Error(err))
+		return
+	}
+	for _, admin := range admins {
+		tgIds := []int64{}
+		for _, tgAcc := range admin.TgAccounts {
+			tgIds = append(tgIds, int64(tgAcc.ID))
+		}
+		bot.logger.Warn(
+			"Admin is kept only by deprecated admin_tags, add the tg ids to admin_tg_ids",
+			zap.Uint("actor_id", uint(admin.ID)), zap.Int64s("tg ids", tgIds),
+		)
+	}
+}
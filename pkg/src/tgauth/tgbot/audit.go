@@ -0,0 +1,192 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+const (
+	auditEventsLimit = 20
+	// snapshots are cut to fit the events into one message
+	auditSnapshotLimit = 150
+	auditMessageLimit  = 3500
+)
+
+const auditFilterHelp = `Введите фильтр из пар ключ-значение через пробел или <code>all</code>:
+<code>by 123</code> или <code>by @user</code> — кто сделал
+<code>user 123</code> или <code>user @user</code> — с кем сделали
+<code>login Steve</code> — аккаунт майнкрафта
+<code>target tg_chat -100123</code> — любой объект
+<code>since 24h</code>, <code>until 1h</code> — сколько времени назад`
+
+type AuditHandler struct {
+	h *CommonAdminHandler
+}
+
+func (handler *AuditHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, auditFilterHelp)
+	handler.h.bot.SendLog(msg)
+	return handler, nil
+}
+
+type ErrorInvalidAuditFilter struct {
+	Msg string
+}
+
+func (e ErrorInvalidAuditFilter) Error() string {
+	return "invalid audit filter, " + e.Msg
+}
+
+func (e ErrorInvalidAuditFilter) Is(target error) bool {
+	_, ok := target.(ErrorInvalidAuditFilter)
+	return ok
+}
+
+func (handler *AuditHandler) parseUserRef(text string) (*authdb.Actor, error) {
+	user, err := handler.h.parseUserRefFromText(text)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrorInvalidAuditFilter{fmt.Sprintf("пользователь %s не найден", text)}
+	}
+	return user, nil
+}
+
+func (handler *AuditHandler) parseFilter(text string) (authdb.AuditEventFilter, error) {
+	filter := authdb.AuditEventFilter{Limit: auditEventsLimit}
+	fields := strings.Fields(text)
+	if len(fields) == 1 && fields[0] == "all" {
+		return filter, nil
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			return filter, ErrorInvalidAuditFilter{fmt.Sprintf("нет значения для %s", fields[i])}
+		}
+		key, value := fields[i], fields[i+1]
+		switch key {
+		case "by":
+			user, err := handler.parseUserRef(value)
+			if err != nil {
+				return filter, err
+			}
+			filter.ActorID = &user.ID
+		case "user":
+			user, err := handler.parseUserRef(value)
+			if err != nil {
+				return filter, err
+			}
+			filter.TargetType = authdb.AuditTargetActor
+			filter.TargetID = strconv.FormatUint(uint64(user.ID), 10)
+		case "login":
+			filter.TargetType = authdb.AuditTargetMinecraftAccount
+			filter.TargetID = value
+		case "target":
+			if i+2 >= len(fields) {
+				return filter, ErrorInvalidAuditFilter{"нужны тип и id объекта"}
+			}
+			filter.TargetType = value
+			filter.TargetID = fields[i+2]
+			i++
+		case "since", "until":
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return filter, ErrorInvalidAuditFilter{fmt.Sprintf("неверная длительность %s", value)}
+			}
+			moment := time.Now().Add(-duration)
+			if key == "since" {
+				filter.Since = &moment
+			} else {
+				filter.Until = &moment
+			}
+		default:
+			return filter, ErrorInvalidAuditFilter{fmt.Sprintf("неизвестный ключ %s", key)}
+		}
+	}
+	return filter, nil
+}
+
+func (handler *AuditHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	filter, err := handler.parseFilter(update.Message.Text)
+	if err != nil {
+		var filterErr ErrorInvalidAuditFilter
+		if errors.As(err, &filterErr) {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный фильтр: "+tgbotapi.EscapeText(tgbotapi.ModeHTML, filterErr.Msg))
+			handler.h.bot.SendLog(msg)
+			return handler, nil
+		}
+		if errors.Is(err, ErrorInvalidActorTextRepr{}) {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный формат пользователя")
+			handler.h.bot.SendLog(msg)
+			return handler, nil
+		}
+		return nil, err
+	}
+	events, err := handler.h.bot.permsEngine.AdminGetAuditEvents(actor.ID, filter)
+	if err != nil {
+		return nil, err
+	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, describeAuditEvents(events))
+	handler.h.bot.SendLog(msg)
+	return nil, nil
+}
+
+func shortenSnapshot(snapshot string) string {
+	runes := []rune(snapshot)
+	if len(runes) > auditSnapshotLimit {
+		snapshot = string(runes[:auditSnapshotLimit]) + "…"
+	}
+	return tgbotapi.EscapeText(tgbotapi.ModeHTML, snapshot)
+}
+
+// HTML formatted, newest events first
+func describeAuditEvents(events []authdb.AuditEvent) string {
+	if len(events) == 0 {
+		return "Событий не найдено"
+	}
+	b := strings.Builder{}
+	for i, event := range events {
+		line := strings.Builder{}
+		line.WriteString(fmt.Sprintf("%s <b>%s</b> %s <code>%s</code>",
+			event.CreatedAt.Format("2006-01-02 15:04:05"), event.Action,
+			event.TargetType, tgbotapi.EscapeText(tgbotapi.ModeHTML, event.TargetID)))
+		if event.ActorID != nil {
+			line.WriteString(fmt.Sprintf(", сделал <code>%d</code>", *event.ActorID))
+		} else {
+			line.WriteString(", автоматически")
+		}
+		if event.TgUpdateID != nil {
+			line.WriteString(fmt.Sprintf(", апдейт %d", *event.TgUpdateID))
+		}
+		if event.Before != "" {
+			line.WriteString(fmt.Sprintf("\nДо: <code>%s</code>", shortenSnapshot(event.Before)))
+		}
+		if event.After != "" {
+			line.WriteString(fmt.Sprintf("\nПосле: <code>%s</code>", shortenSnapshot(event.After)))
+		}
+		line.WriteString("\n\n")
+		if b.Len()+line.Len() > auditMessageLimit {
+			b.WriteString(fmt.Sprintf("и ещё %d, уточните фильтр", len(events)-i))
+			break
+		}
+		b.WriteString(line.String())
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (handler *AuditHandler) GetCommands() []tgtypes.BotCommand {
+	return nil
+}
+func (handler *AuditHandler) GetHelpDescription() string {
+	return "Сейчас вы смотрите журнал действий"
+}
+func (handler *AuditHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
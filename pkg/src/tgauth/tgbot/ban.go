@@ -0,0 +1,229 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+const banTimeFormat = "02.01.2006 15:04 MST"
+
+type ErrorInvalidBanDuration struct {
+	Text string
+}
+
+func (e ErrorInvalidBanDuration) Error() string {
+	return fmt.Sprintf("invalid ban duration %s", e.Text)
+}
+
+func (e ErrorInvalidBanDuration) Is(target error) bool {
+	_, ok := target.(ErrorInvalidBanDuration)
+	return ok
+}
+
+// Go durations with days and weeks, 0 means permanent ban
+func parseBanDuration(text string) (time.Duration, error) {
+	text = strings.TrimSpace(strings.ToLower(text))
+	if text == "forever" || text == "навсегда" {
+		return 0, nil
+	}
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		number, found := strings.CutSuffix(text, suffix)
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil || n <= 0 {
+			return 0, ErrorInvalidBanDuration{Text: text}
+		}
+		return time.Duration(n) * unit, nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil || duration <= 0 {
+		return 0, ErrorInvalidBanDuration{Text: text}
+	}
+	return duration, nil
+}
+
+// the reason goes to the minecraft ban list through a console command
+const maxBanReasonLength = 200
+
+type ErrorInvalidBanReason struct {
+	Text string
+}
+
+func (e ErrorInvalidBanReason) Error() string {
+	return fmt.Sprintf("invalid ban reason %q", e.Text)
+}
+
+func (e ErrorInvalidBanReason) Is(target error) bool {
+	_, ok := target.(ErrorInvalidBanReason)
+	return ok
+}
+
+// One line without control characters, a line break would start another console command
+func parseBanReason(text string) (string, error) {
+	reason := strings.TrimSpace(text)
+	if strings.ContainsFunc(reason, unicode.IsControl) || strings.ContainsAny(reason, "\u2028\u2029") {
+		return "", ErrorInvalidBanReason{Text: text}
+	}
+	if utf8.RuneCountInString(reason) > maxBanReasonLength {
+		return "", ErrorInvalidBanReason{Text: text}
+	}
+	return reason, nil
+}
+
+func describeBanEnd(ban *authdb.Ban) string {
+	expires := ban.ExpiresAt()
+	if expires == nil {
+		return "навсегда"
+	}
+	return "до " + expires.Format(banTimeFormat)
+}
+
+// HTML formatted, sent to the banned user
+func describeBanForUser(ban *authdb.Ban) string {
+	return fmt.Sprintf(
+		"Вы забанены на сервере %s.\nПричина: %s",
+		describeBanEnd(ban), tgbotapi.EscapeText(tgbotapi.ModeHTML, ban.Reason),
+	)
+}
+
+type BanUserHandler struct {
+	h            *CommonAdminHandler
+	selectedUser *authdb.Actor
+	duration     *time.Duration
+	reason       string
+}
+
+func (handler *BanUserHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	var text string
+	switch {
+	case handler.selectedUser == nil:
+		err := handler.h.promptForUser(update)
+		return handler, err
+	case handler.duration == nil:
+		text = "Введите срок бана, например <code>12h</code>, <code>7d</code>, <code>2w</code> или <code>forever</code>"
+	case handler.reason == "":
+		text = "Введите причину бана, её увидит пользователь"
+	default:
+		end := "навсегда"
+		if *handler.duration > 0 {
+			end = "до " + time.Now().Add(*handler.duration).Format(banTimeFormat)
+		}
+		text = fmt.Sprintf(
+			"%s\nЗабанить %s? Причина: %s\n/confirm /confirm_invitees /abort",
+			getUserDescriptionForAdmin(handler.selectedUser), end,
+			tgbotapi.EscapeText(tgbotapi.ModeHTML, handler.reason),
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *BanUserHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	switch {
+	case handler.selectedUser == nil:
+		user, err := handler.h.parseUserFromUpdateInteractive(update)
+		if err != nil {
+			return nil, err
+		}
+		handler.selectedUser = user
+		return handler, nil
+	case handler.duration == nil:
+		duration, err := parseBanDuration(update.Message.Text)
+		if err != nil {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный срок бана"))
+			return handler, nil
+		}
+		handler.duration = &duration
+		return handler, nil
+	case handler.reason == "":
+		reason, err := parseBanReason(update.Message.Text)
+		if err != nil {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(
+				update.Message.Chat.ID,
+				fmt.Sprintf("Причина должна быть одной строкой не длиннее %d символов", maxBanReasonLength),
+			))
+			return handler, nil
+		}
+		handler.reason = reason
+		return handler, nil
+	}
+	reviewInvitees := update.Message.Command() == "confirm_invitees"
+	if !reviewInvitees {
+		resp, err := handler.h.processConfirmationInteractive(update)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			return handler, nil
+		}
+		if !*resp {
+			return nil, nil
+		}
+	}
+	ban, err := handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminBanActor(
+		actor.ID, handler.selectedUser.ID, *handler.duration, handler.reason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	handler.h.bot.NotifyActor(handler.selectedUser.ID, describeBanForUser(ban))
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь забанен "+describeBanEnd(ban))
+	handler.h.bot.SendLog(msg)
+	if !reviewInvitees {
+		return nil, nil
+	}
+	revoked, err := handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminReviewInvitees(actor.ID, handler.selectedUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, invitee := range revoked {
+		handler.h.bot.NotifyActor(invitee.ID, "Пригласивший вас пользователь забанен, доступ к серверу закрыт. "+handler.h.bot.needToVerifyDisclaimer())
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, describeRevokedInvitees(revoked)))
+	return nil, nil
+}
+
+func (handler *BanUserHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.reason == "" {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Забанить"},
+		{Command: "confirm_invitees", Description: "Забанить и пересмотреть доступ приглашённых"},
+		// abort command handler upstream
+	}
+}
+func (handler *BanUserHandler) GetHelpDescription() string {
+	return "Сейчас вы баните пользователя"
+}
+func (handler *BanUserHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+// the admin is told if the user is not banned
+func (handler *UserActionHandler) unban(update *tgbotapi.Update, actor *authdb.Actor) error {
+	err := handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminUnbanActor(actor.ID, handler.selectedUser.ID)
+	if err != nil {
+		if errors.Is(err, permsengine.ErrorNotBanned{}) {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не забанен"))
+			return nil
+		}
+		return err
+	}
+	handler.h.bot.NotifyActor(handler.selectedUser.ID, "Бан на сервере снят")
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь разбанен"))
+	return nil
+}
@@ -0,0 +1,24 @@
+package tgbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBanReason(t *testing.T) {
+	reason, err := parseBanReason("  griefing the spawn \n")
+	if err != nil || reason != "griefing the spawn" {
+		t.Fatalf("Valid reason is rejected: %q %v", reason, err)
+	}
+	for _, text := range []string{
+		"griefing\nop attacker",
+		"griefing\r/stop",
+		"griefing\u2028/stop",
+		strings.Repeat("a", maxBanReasonLength+1),
+	} {
+		_, err = parseBanReason(text)
+		if err == nil {
+			t.Fatalf("Invalid reason %q is accepted", text)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func isChatMember(member tgbotapi.ChatMember) bool {
+	switch member.Status {
+	case "creator", "administrator", "member":
+		return true
+	case "restricted":
+		return member.IsMember
+	default:
+		return false
+	}
+}
+
+// chat_member updates are only sent to the bot if it is an admin of the chat
+func (bot *TgBot) handleChatMemberUpdate(update *tgbotapi.Update) {
+	memberUpdate := update.ChatMember
+	if memberUpdate.Chat.Type != tgtypes.GroupChatType && memberUpdate.Chat.Type != tgtypes.SupergroupChatType {
+		return
+	}
+	user := memberUpdate.NewChatMember.User
+	if user == nil || user.IsBot {
+		return
+	}
+	engine := bot.permsEngine.ForTgUpdate(update.UpdateID)
+	chatId := authdb.TgChatId(memberUpdate.Chat.ID)
+	if isChatMember(memberUpdate.NewChatMember) {
+		err := engine.UpdateTgUserInfo(*user)
+		if err != nil {
+			bot.logger.Error("Failed to update tg user of chat member", zap.Error(err))
+			return
+		}
+		actor := authdb.Actor{}
+		err = engine.GetActorByTgUser(authdb.TgUserId(user.ID), &actor)
+		if err != nil {
+			bot.logger.Error("Failed to get actor of chat member", zap.Error(err))
+			return
+		}
+		err = engine.SeenInChat(actor.ID, chatId)
+		if err != nil {
+			bot.logger.Error("Failed to mark actor as seen in chat", zap.Error(err))
+			return
+		}
+		err = engine.UpdateActorStatus(actor.ID, false)
+		if err != nil {
+			bot.logger.Error("Failed to update actor status", zap.Error(err))
+		}
+		return
+	}
+	actor := authdb.Actor{}
+	err := engine.GetActorByTgUser(authdb.TgUserId(user.ID), &actor)
+	if err != nil {
+		// never talked to the bot
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			bot.logger.Error("Failed to get actor of left chat member", zap.Error(err))
+		}
+		return
+	}
+	lossAt, err := engine.LeftChat(actor.ID, chatId)
+	if err != nil {
+		bot.logger.Error("Failed to mark actor as left chat", zap.Error(err))
+		return
+	}
+	bot.warnAboutAccessLoss(actor.ID, lossAt)
+}
+
+func (bot *TgBot) warnAboutAccessLoss(actorId authdb.ActorId, lossAt *time.Time) {
+	if lossAt != nil {
+		bot.NotifyActor(actorId, "Вы вышли из чата сервера. Если не вернётесь, доступ к серверу будет закрыт "+lossAt.Format(banTimeFormat))
+	}
+}
+
+func (bot *TgBot) runChatLeavesCheck() {
+	if bot.config.CheckChatLeavesFrequency == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(bot.config.CheckChatLeavesFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bot.ctx.Done():
+				return
+			case <-ticker.C:
+				bot.removeActorsAfterChatLeaves()
+			}
+		}
+	}()
+}
+
+func (bot *TgBot) removeActorsAfterChatLeaves() {
+	removed, err := bot.permsEngine.RemoveActorsAfterChatLeaves()
+	if err != nil {
+		bot.logger.Error("Failed to remove actors after chat leaves", zap.Error(err))
+	}
+	for _, actorId := range removed {
+		bot.logger.Info("Actor lost access after leaving chats", zap.Uint("actor_id", uint(actorId)))
+		bot.NotifyActor(actorId, "Доступ к серверу закрыт, так как вы вышли из чата. "+bot.needToVerifyDisclaimer())
+	}
+}
+
+func (bot *TgBot) runChatsReconciliation() {
+	if bot.config.ReconcileChatsFrequency == 0 {
+		return
+	}
+	go func() {
+		// already reported actors, to not repeat them every check
+		reported := map[authdb.ActorId]struct{}{}
+		ticker := time.NewTicker(bot.config.ReconcileChatsFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bot.ctx.Done():
+				return
+			case <-ticker.C:
+				bot.reconcileChats(reported)
+			}
+		}
+	}()
+}
+
+// Membership is otherwise learned only from messages and chat_member updates, which are not sent
+// to the bot if it is not an admin. Checks every known member of approved chats and reports to admins
+// accepted actors which are no longer in any approved chat. Returns all such actors
+func (bot *TgBot) reconcileChats(reported map[authdb.ActorId]struct{}) []authdb.Actor {
+	memberships, err := bot.permsEngine.GetApprovedChatMemberships()
+	if err != nil {
+		bot.logger.Error("Failed to get memberships of approved chats", zap.Error(err))
+		return nil
+	}
+	// chats which can't be checked in this run, e.g. the bot was removed from them
+	skippedChats := map[authdb.TgChatId]struct{}{}
+	for _, membership := range memberships {
+		if bot.ctx.Err() != nil {
+			return nil
+		}
+		if _, ok := skippedChats[membership.TgChatID]; ok {
+			continue
+		}
+		actor := authdb.Actor{ID: authdb.ActorId(membership.ActorID)}
+		err = bot.permsEngine.GetActor(&actor)
+		if err != nil {
+			bot.logger.Error("Failed to get chat member", zap.Uint("actor_id", membership.ActorID), zap.Error(err))
+			continue
+		}
+		if len(actor.TgAccounts) == 0 {
+			continue
+		}
+		inChat, err := bot.isActorInChat(&actor, membership.TgChatID)
+		if err != nil {
+			bot.logger.Warn("Failed to check chat members", zap.Int64("chat_id", int64(membership.TgChatID)), zap.Error(err))
+			skippedChats[membership.TgChatID] = struct{}{}
+			continue
+		}
+		bot.reconcileMembership(actor.ID, membership.TgChatID, membership.LeftAt != nil, inChat)
+	}
+
+	outside, err := bot.permsEngine.GetAcceptedActorsOutsideApprovedChats()
+	if err != nil {
+		bot.logger.Error("Failed to get actors outside approved chats", zap.Error(err))
+		return nil
+	}
+	current := map[authdb.ActorId]struct{}{}
+	toReport := []authdb.Actor{}
+	for _, actor := range outside {
+		current[actor.ID] = struct{}{}
+		if _, ok := reported[actor.ID]; !ok {
+			toReport = append(toReport, actor)
+		}
+	}
+	// actors which came back are reported again if they leave later
+	for actorId := range reported {
+		if _, ok := current[actorId]; !ok {
+			delete(reported, actorId)
+		}
+	}
+	for actorId := range current {
+		reported[actorId] = struct{}{}
+	}
+	if len(toReport) > 0 {
+		bot.logger.Info("Accepted actors are outside approved chats", zap.Int("count", len(toReport)))
+		bot.NotifyAdmins(describeActorsOutsideApprovedChats(toReport))
+	}
+	return outside
+}
+
+// The actor is in the chat if any of their tg accounts is
+func (bot *TgBot) isActorInChat(actor *authdb.Actor, chatId authdb.TgChatId) (bool, error) {
+	for _, tgAcc := range actor.TgAccounts {
+		member, err := bot.getChatMember(chatId, tgAcc.ID)
+		if err != nil {
+			if isUnknownChatMemberError(err) {
+				continue
+			}
+			return false, err
+		}
+		if isChatMember(member) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// retries of a getChatMember request answered with 429 Too Many Requests
+const maxChatMemberRetries = 3
+
+// Requests are spaced by ReconcileChatsRequestInterval, on 429 the request is
+// repeated after the retry_after given by telegram
+func (bot *TgBot) getChatMember(chatId authdb.TgChatId, userId authdb.TgUserId) (tgbotapi.ChatMember, error) {
+	config := tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: int64(chatId), UserID: int64(userId)},
+	}
+	wait := bot.config.ReconcileChatsRequestInterval
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(wait):
+		case <-bot.ctx.Done():
+			return tgbotapi.ChatMember{}, bot.ctx.Err()
+		}
+		member, err := bot.api.GetChatMember(config)
+		var tgErr *tgbotapi.Error
+		if err == nil || attempt >= maxChatMemberRetries || !errors.As(err, &tgErr) || tgErr.Code != 429 {
+			return member, err
+		}
+		wait = time.Duration(tgErr.RetryAfter) * time.Second
+		bot.logger.Warn("Too many getChatMember requests", zap.Duration("retry_after", wait))
+	}
+}
+
+// telegram answers so for users which were never in the chat or deleted their account
+func isUnknownChatMemberError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) || tgErr.Code != 400 {
+		return false
+	}
+	return strings.Contains(tgErr.Message, "user not found") || strings.Contains(tgErr.Message, "PARTICIPANT_ID_INVALID")
+}
+
+func (bot *TgBot) reconcileMembership(actorId authdb.ActorId, chatId authdb.TgChatId, left bool, inChat bool) {
+	switch {
+	case inChat && left:
+		bot.logger.Info("Actor is back in chat", zap.Uint("actor_id", uint(actorId)), zap.Int64("chat_id", int64(chatId)))
+		err := bot.permsEngine.SeenInChat(actorId, chatId)
+		if err != nil {
+			bot.logger.Error("Failed to mark actor as seen in chat", zap.Error(err))
+			return
+		}
+		err = bot.permsEngine.UpdateActorStatus(actorId, false)
+		if err != nil {
+			bot.logger.Error("Failed to update actor status", zap.Error(err))
+		}
+	case !inChat && !left:
+		bot.logger.Info("Actor is not in chat anymore", zap.Uint("actor_id", uint(actorId)), zap.Int64("chat_id", int64(chatId)))
+		lossAt, err := bot.permsEngine.LeftChat(actorId, chatId)
+		if err != nil {
+			bot.logger.Error("Failed to mark actor as left chat", zap.Error(err))
+			return
+		}
+		bot.warnAboutAccessLoss(actorId, lossAt)
+	}
+}
+
+// HTML formatted
+func describeActorsOutsideApprovedChats(actors []authdb.Actor) string {
+	descBuilder := strings.Builder{}
+	descBuilder.WriteString(fmt.Sprintf("Пользователи с доступом, которых больше нет ни в одном одобренном чате: %d", len(actors)))
+	for _, actor := range actors {
+		descBuilder.WriteString("\n\n")
+		descBuilder.WriteString(getUserDescriptionForAdmin(&actor))
+	}
+	return descBuilder.String()
+}
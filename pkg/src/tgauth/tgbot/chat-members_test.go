@@ -0,0 +1,248 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type chatMemberKey struct {
+	chatId int64
+	userId int64
+}
+
+// fake Telegram Bot API, members which are not set are unknown to telegram
+type fakeTgApi struct {
+	mx sync.Mutex
+	// status of the user in the chat
+	members map[chatMemberKey]string
+	// chats from which the bot was removed
+	kickedFrom map[int64]bool
+	// getChatMember requests answered with 429 before the next one succeeds, by chat
+	throttled map[int64]int
+	// texts of sent messages by chat
+	sent map[int64][]string
+}
+
+func newFakeTgApi() *fakeTgApi {
+	return &fakeTgApi{
+		members:    map[chatMemberKey]string{},
+		kickedFrom: map[int64]bool{},
+		throttled:  map[int64]int{},
+		sent:       map[int64][]string{},
+	}
+}
+
+func (api *fakeTgApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mx.Lock()
+	defer api.mx.Unlock()
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chatId, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	userId, _ := strconv.ParseInt(r.Form.Get("user_id"), 10, 64)
+	switch path.Base(r.URL.Path) {
+	case "getMe":
+		writeTgResult(w, tgbotapi.User{ID: 100, IsBot: true, UserName: "test_bot"})
+	case "getChatMember":
+		if api.kickedFrom[chatId] {
+			writeTgError(w, 403, "Forbidden: bot was kicked from the supergroup chat")
+			return
+		}
+		if api.throttled[chatId] > 0 {
+			api.throttled[chatId]--
+			json.NewEncoder(w).Encode(tgbotapi.APIResponse{
+				Ok:          false,
+				ErrorCode:   429,
+				Description: "Too Many Requests: retry after 1",
+				Parameters:  &tgbotapi.ResponseParameters{RetryAfter: 1},
+			})
+			return
+		}
+		status, ok := api.members[chatMemberKey{chatId, userId}]
+		if !ok {
+			writeTgError(w, 400, "Bad Request: user not found")
+			return
+		}
+		writeTgResult(w, tgbotapi.ChatMember{User: &tgbotapi.User{ID: userId}, Status: status})
+	case "sendMessage":
+		api.sent[chatId] = append(api.sent[chatId], r.Form.Get("text"))
+		writeTgResult(w, tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: chatId}})
+	default:
+		writeTgError(w, 404, "Not Found")
+	}
+}
+
+func (api *fakeTgApi) sentTo(chatId int64) []string {
+	api.mx.Lock()
+	defer api.mx.Unlock()
+	return append([]string{}, api.sent[chatId]...)
+}
+
+func writeTgResult(w http.ResponseWriter, result interface{}) {
+	data, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+func writeTgError(w http.ResponseWriter, code int, description string) {
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
+
+func initTestBot(t *testing.T, fakeApi *fakeTgApi) *TgBot {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	executor, err := authdb.NewAuthDbExecutor(db, authdb.DefaultAuthDbExecutorConfig, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	config := permsengine.DefaultServerPermsEngineConfig
+	config.AdminTgIds = []authdb.TgUserId{1}
+	engine, err := permsengine.NewServerPermsEngine(config, executor, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(fakeApi)
+	t.Cleanup(server.Close)
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("Failed to connect to fake api: %v", err)
+	}
+	botConfig := DefaultTgBotConfig
+	botConfig.ReconcileChatsRequestInterval = time.Millisecond
+	return newTgBotWithApi(botConfig, TgBotSecret{}, api, engine, nil, zap.NewNop(), context.Background())
+}
+
+// registers the tg user, marks them as seen in the chats and updates their status
+func registerChatMember(t *testing.T, bot *TgBot, user tgbotapi.User, chatIds ...authdb.TgChatId) authdb.ActorId {
+	err := bot.permsEngine.UpdateTgUserInfo(user)
+	if err != nil {
+		t.Fatalf("Failed to update tg user: %v", err)
+	}
+	actor := authdb.Actor{}
+	err = bot.permsEngine.GetActorByTgUser(authdb.TgUserId(user.ID), &actor)
+	if err != nil {
+		t.Fatalf("Failed to get actor: %v", err)
+	}
+	for _, chatId := range chatIds {
+		err = bot.permsEngine.SeenInChat(actor.ID, chatId)
+		if err != nil {
+			t.Fatalf("Failed to mark actor as seen in chat: %v", err)
+		}
+	}
+	err = bot.permsEngine.UpdateActorStatus(actor.ID, false)
+	if err != nil {
+		t.Fatalf("Failed to update actor status: %v", err)
+	}
+	return actor.ID
+}
+
+func getTestActor(t *testing.T, bot *TgBot, actorId authdb.ActorId) *authdb.Actor {
+	actor := authdb.Actor{ID: actorId}
+	err := bot.permsEngine.GetActor(&actor)
+	if err != nil {
+		t.Fatalf("Failed to get actor: %v", err)
+	}
+	return &actor
+}
+
+func actorIds(actors []authdb.Actor) map[authdb.ActorId]bool {
+	ids := map[authdb.ActorId]bool{}
+	for _, actor := range actors {
+		ids[actor.ID] = true
+	}
+	return ids
+}
+
+func TestReconcileChats(t *testing.T) {
+	fakeApi := newFakeTgApi()
+	bot := initTestBot(t, fakeApi)
+	chatId := authdb.TgChatId(-100)
+	kickedChatId := authdb.TgChatId(-200)
+	unapprovedChatId := authdb.TgChatId(-300)
+
+	adminId := registerChatMember(t, bot, tgbotapi.User{ID: 1, UserName: "admin"}, chatId, kickedChatId)
+	for _, id := range []authdb.TgChatId{chatId, kickedChatId} {
+		err := bot.permsEngine.ApproveChat(adminId, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stayedId := registerChatMember(t, bot, tgbotapi.User{ID: 2, UserName: "stayed"}, chatId)
+	leftId := registerChatMember(t, bot, tgbotapi.User{ID: 3, UserName: "left"}, chatId)
+	returnedId := registerChatMember(t, bot, tgbotapi.User{ID: 4, UserName: "returned"}, chatId)
+	deletedId := registerChatMember(t, bot, tgbotapi.User{ID: 5, UserName: "deleted"}, chatId)
+	// the bot can't check the chat, the actor is kept as is
+	uncheckedId := registerChatMember(t, bot, tgbotapi.User{ID: 6, UserName: "unchecked"}, kickedChatId)
+	outsiderId := registerChatMember(t, bot, tgbotapi.User{ID: 7, UserName: "outsider"}, unapprovedChatId)
+	_, err := bot.permsEngine.LeftChat(returnedId, chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakeApi.members[chatMemberKey{-100, 1}] = "creator"
+	fakeApi.members[chatMemberKey{-100, 2}] = "member"
+	fakeApi.members[chatMemberKey{-100, 3}] = "left"
+	fakeApi.members[chatMemberKey{-100, 4}] = "member"
+	fakeApi.kickedFrom[-200] = true
+	// the chat is checked after the rate limit instead of being skipped
+	fakeApi.throttled[-100] = 1
+
+	reported := map[authdb.ActorId]struct{}{}
+	outside := bot.reconcileChats(reported)
+	outsideIds := actorIds(outside)
+	if len(outside) != 2 || !outsideIds[leftId] || !outsideIds[deletedId] {
+		t.Fatalf("Expected the left and the deleted users outside approved chats, got %v", outsideIds)
+	}
+	for _, id := range []authdb.ActorId{leftId, deletedId, returnedId, stayedId, uncheckedId} {
+		if !getTestActor(t, bot, id).Accepted {
+			t.Fatalf("Actor %d lost access during the grace period", id)
+		}
+	}
+	if getTestActor(t, bot, outsiderId).Accepted {
+		t.Fatal("Member of unapproved chat is accepted")
+	}
+	for _, membership := range getTestActor(t, bot, returnedId).ChatMemberships {
+		if membership.LeftAt != nil {
+			t.Fatal("Returned user is still marked as left")
+		}
+	}
+	if len(fakeApi.sentTo(3)) != 1 || len(fakeApi.sentTo(2)) != 0 {
+		t.Fatalf("Expected a warning only to the left user, got %v %v", fakeApi.sentTo(3), fakeApi.sentTo(2))
+	}
+	if len(fakeApi.sentTo(1)) != 1 {
+		t.Fatalf("Expected one report to the admin, got %v", fakeApi.sentTo(1))
+	}
+	if fakeApi.throttled[-100] != 0 {
+		t.Fatal("Throttled chat was not checked")
+	}
+
+	// nothing changed, the report is not repeated
+	outside = bot.reconcileChats(reported)
+	if len(outside) != 2 || len(fakeApi.sentTo(1)) != 1 || len(fakeApi.sentTo(3)) != 1 {
+		t.Fatalf("Repeated report %v %v", outside, fakeApi.sentTo(1))
+	}
+
+	fakeApi.members[chatMemberKey{-100, 3}] = "member"
+	outside = bot.reconcileChats(reported)
+	if len(outside) != 1 || !actorIds(outside)[deletedId] {
+		t.Fatalf("Expected only the deleted user outside approved chats, got %v", actorIds(outside))
+	}
+}
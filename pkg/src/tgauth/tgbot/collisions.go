@@ -0,0 +1,167 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+	"go.uber.org/zap"
+)
+
+func (bot *TgBot) runCollisionCheck() {
+	if bot.config.CheckCollisionsFrequency == 0 {
+		return
+	}
+	go func() {
+		// already reported collisions, to not repeat them every check
+		reported := map[permsengine.UuidCollision]struct{}{}
+		ticker := time.NewTicker(bot.config.CheckCollisionsFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bot.ctx.Done():
+				return
+			case <-ticker.C:
+				bot.checkCollisions(reported)
+			}
+		}
+	}()
+}
+
+func (bot *TgBot) checkCollisions(reported map[permsengine.UuidCollision]struct{}) {
+	collisions, err := bot.permsEngine.DetectOfflineCollisions(bot.ctx, bot.mojangClient)
+	if err != nil {
+		bot.logger.Error("Failed to detect uuid collisions", zap.Error(err))
+		return
+	}
+	for _, collision := range collisions {
+		if _, ok := reported[collision]; ok {
+			continue
+		}
+		reported[collision] = struct{}{}
+		bot.logger.Info("Uuid collision", zap.Any("collision", collision))
+		bot.NotifyActor(collision.ActorId, fmt.Sprintf(
+			"Ник <code>%s</code> принадлежит официальному аккаунту. "+
+				"Если владелец зайдёт на сервер, вам придётся сменить ник, обратитесь к администратору чтобы перенести прогресс.",
+			collision.Login,
+		))
+		bot.NotifyAdmins(describeCollisionForAdmin(collision))
+	}
+}
+
+// HTML formatted
+func describeCollisionForAdmin(collision permsengine.UuidCollision) string {
+	text := fmt.Sprintf(
+		"Пиратский аккаунт <code>%s</code> пользователя <code>%d</code> совпадает с официальным",
+		collision.Login, collision.ActorId,
+	)
+	if collision.SeenOnServer {
+		text += ", официальный владелец уже заходил на сервер"
+	}
+	return text + ". Перенести аккаунт: /migrate_account"
+}
+
+// sends HTML formatted message to all admins
+func (bot *TgBot) NotifyAdmins(text string) {
+	admins, err := bot.permsEngine.GetAdmins()
+	if err != nil {
+		bot.logger.Error("Failed to get admins to notify", zap.Error(err))
+		return
+	}
+	for _, admin := range admins {
+		for _, tgAcc := range admin.TgAccounts {
+			bot.SendLog(tgbotapi.NewMessage(int64(tgAcc.ID), text))
+		}
+	}
+}
+
+type MigrateAccountHandler struct {
+	h        *CommonAdminHandler
+	oldLogin mojang.MinecraftLogin
+	newLogin mojang.MinecraftLogin
+}
+
+func (handler *MigrateAccountHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	var text string
+	switch {
+	case handler.oldLogin == "":
+		text = "Введите пиратский аккаунт который нужно перенести"
+	case handler.newLogin == "":
+		text = "Введите новый ник"
+	default:
+		text = fmt.Sprintf(
+			"Перенести <code>%s</code> и его прогресс на <code>%s</code>? Сервер будет перезапущен на время переноса.\n/confirm /abort",
+			handler.oldLogin, handler.newLogin,
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *MigrateAccountHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.oldLogin == "" || handler.newLogin == "" {
+		login, err := mojang.MakeMinecraftLogin(update.Message.Text)
+		if err != nil {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Аккаунт содержит недопустимые символы или слишком короткий или длинный, введите другой")
+			handler.h.bot.SendLog(msg)
+			return handler, nil
+		}
+		if handler.oldLogin == "" {
+			handler.oldLogin = login
+		} else {
+			handler.newLogin = login
+		}
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminMigrateMinecraftAccount(actor.ID, handler.oldLogin, handler.newLogin)
+	var notRolledBack mcserver.ErrorMigrationNotRolledBack
+	var text string
+	switch {
+	case err == nil:
+		text = "Аккаунт перенесён"
+	case errors.Is(err, permsengine.ErrorNotCrackedAccount{}):
+		text = fmt.Sprintf("<code>%s</code> не зарегистрирован как пиратский аккаунт", handler.oldLogin)
+	case errors.Is(err, authdb.ErrorLoginTaken{}):
+		text = fmt.Sprintf("Ник <code>%s</code> уже занят", handler.newLogin)
+	case errors.Is(err, mcserver.ErrorPlayerDataExists{}):
+		text = fmt.Sprintf("У <code>%s</code> уже есть прогресс на сервере, перенос отменён", handler.newLogin)
+	case errors.As(err, &notRolledBack):
+		text = fmt.Sprintf("Аккаунт перенесён на <code>%s</code>, но с прогрессом возникла ошибка.\n", handler.newLogin) +
+			describeFailedMigration(notRolledBack.Record)
+	default:
+		return nil, err
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return nil, nil
+}
+
+func (handler *MigrateAccountHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.newLogin == "" {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Перенести"},
+	}
+}
+func (handler *MigrateAccountHandler) GetHelpDescription() string {
+	return "Сейчас вы переносите пиратский аккаунт"
+}
+func (handler *MigrateAccountHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
@@ -47,7 +47,7 @@ func (handler *CommonHandleWrapper) InitialHandle(update *tgbotapi.Update, actor
 func (handler *CommonHandleWrapper) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
 	switch update.Message.Command() {
 	case "start":
-		handler.HandleStart(update)
+		handler.HandleStart(update, actor)
 		return handler, nil
 	case "help":
 		handler.HandleHelp(update)
@@ -84,7 +84,13 @@ func (handler *CommonHandleWrapper) GetHelpDescription() string {
 	return handler.Handler.GetHelpDescription()
 }
 
-func (handler *CommonHandleWrapper) HandleStart(update *tgbotapi.Update) {
+// /start with an argument is a deep link with an invite code
+func (handler *CommonHandleWrapper) HandleStart(update *tgbotapi.Update, actor *authdb.Actor) {
+	code := strings.TrimSpace(update.Message.CommandArguments())
+	if code != "" {
+		handler.GetBot().redeemInvite(update, actor, code)
+		return
+	}
 	handler.HandleHelp(update)
 }
 
@@ -0,0 +1,121 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+func (bot *TgBot) inviteLink(code *authdb.InviteCode) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", bot.api.Self.UserName, code.Code)
+}
+
+type InviteHandler struct {
+	bot *TgBot
+}
+
+func (handler *InviteHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if !actor.Accepted {
+		handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, handler.bot.needToVerifyDisclaimer()))
+		return nil, nil
+	}
+	msgBuilder := strings.Builder{}
+	code, err := handler.bot.permsEngine.ForTgUpdate(update.UpdateID).CreateInviteCode(actor.ID)
+	switch {
+	case err == nil:
+		msgBuilder.WriteString(fmt.Sprintf(
+			"Отправьте другу ссылку, она одноразовая и действует до %s:\n%s\n",
+			code.ExpiresAt.Format(banTimeFormat), handler.bot.inviteLink(code),
+		))
+	case errors.Is(err, permsengine.ErrorInviteQuotaExceeded{}):
+		msgBuilder.WriteString("Вы больше не можете приглашать.\n")
+	case errors.Is(err, permsengine.ErrorActorBanned{}):
+		handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Забаненные пользователи не могут приглашать"))
+		return nil, nil
+	default:
+		return nil, err
+	}
+	codes, remaining, err := handler.bot.permsEngine.GetInviteCodes(actor.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, other := range codes {
+		if other.IsActive(now) && (code == nil || other.ID != code.ID) {
+			msgBuilder.WriteString(fmt.Sprintf("Неиспользованная ссылка: %s\n", handler.bot.inviteLink(&other)))
+		}
+	}
+	msgBuilder.WriteString(fmt.Sprintf("Осталось приглашений: %d", remaining))
+	handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, msgBuilder.String()))
+	return nil, nil
+}
+
+func (handler *InviteHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	return nil, nil
+}
+
+func (handler *InviteHandler) GetCommands() []tgtypes.BotCommand {
+	return nil
+}
+
+func (handler *InviteHandler) GetHelpDescription() string {
+	return "Приглашение на сервер"
+}
+
+func (handler *InviteHandler) GetBot() *TgBot {
+	return handler.bot
+}
+
+// code comes from the /start deep link
+func (bot *TgBot) redeemInvite(update *tgbotapi.Update, actor *authdb.Actor, code string) {
+	inviteCode, err := bot.permsEngine.ForTgUpdate(update.UpdateID).RedeemInviteCode(actor.ID, code)
+	var text string
+	switch {
+	case err == nil:
+		text = "Приглашение принято."
+		bot.NotifyActor(inviteCode.InviterID, fmt.Sprintf("Ваше приглашение принял пользователь <code>%d</code>", actor.ID))
+	case errors.Is(err, authdb.ErrorInvalidInviteCode{}):
+		text = "Приглашение недействительно: оно истекло или уже использовано."
+	case errors.Is(err, permsengine.ErrorAlreadyInvited{}):
+		text = "Вы уже приняли приглашение."
+	case errors.Is(err, permsengine.ErrorAlreadyAccepted{}):
+		text = "У вас уже есть доступ, приглашение не нужно."
+	case errors.Is(err, authdb.ErrorInviteCycle{}):
+		text = "Нельзя принять приглашение от пользователя, которого пригласили вы."
+	default:
+		bot.HandleUnexpectedError(update, err)
+		return
+	}
+	updated := authdb.Actor{}
+	err = bot.permsEngine.GetActorByTgUser(authdb.TgUserId(update.Message.From.ID), &updated)
+	if err != nil {
+		bot.HandleUnexpectedError(update, err)
+		return
+	}
+	if updated.Accepted {
+		text += " У вас есть доступ к серверу, добавьте аккаунт: /add_minecraft_login"
+	} else {
+		text += " " + bot.needToVerifyDisclaimer()
+	}
+	bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+}
+
+// HTML formatted, for the admin who banned the inviter
+func describeRevokedInvitees(invitees []authdb.Actor) string {
+	if len(invitees) == 0 {
+		return "Все приглашённые сохранили доступ"
+	}
+	descBuilder := strings.Builder{}
+	descBuilder.WriteString(fmt.Sprintf("Приглашённые без доступа: %d", len(invitees)))
+	for _, invitee := range invitees {
+		descBuilder.WriteString("\n\n")
+		descBuilder.WriteString(getUserDescriptionForAdmin(&invitee))
+	}
+	return descBuilder.String()
+}
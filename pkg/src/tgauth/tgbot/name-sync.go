@@ -0,0 +1,85 @@
+package tgbot
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"go.uber.org/zap"
+)
+
+func (bot *TgBot) runNameSync() {
+	if bot.config.CheckNamesFrequency == 0 {
+		return
+	}
+	go func() {
+		// already reported conflicts, to not repeat them every check
+		reported := map[permsengine.NameChange]struct{}{}
+		ticker := time.NewTicker(bot.config.CheckNamesFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bot.ctx.Done():
+				return
+			case <-ticker.C:
+				bot.syncNames(reported)
+			}
+		}
+	}()
+}
+
+func (bot *TgBot) syncNames(reported map[permsengine.NameChange]struct{}) {
+	changes, err := bot.permsEngine.SyncOnlineAccountNames(bot.ctx, bot.mojangClient)
+	if err != nil {
+		bot.logger.Error("Failed to sync minecraft names", zap.Error(err))
+	}
+	for _, change := range changes {
+		if _, ok := reported[change]; ok {
+			continue
+		}
+		reported[change] = struct{}{}
+		bot.logger.Info("Minecraft name change", zap.Any("change", change))
+		bot.NotifyActor(change.ActorId, describeNameChange(change))
+	}
+}
+
+// HTML formatted
+func describeNameChange(change permsengine.NameChange) string {
+	text := ""
+	switch change.Kind {
+	case permsengine.NameChangeRenamed:
+		text = fmt.Sprintf(
+			"Официальный аккаунт <code>%s</code> переименован в <code>%s</code>, ник на сервере обновлён.",
+			change.OldName, change.NewName,
+		)
+	case permsengine.NameChangeConflict:
+		text = fmt.Sprintf(
+			"Официальный аккаунт <code>%s</code> переименован в <code>%s</code>, но этот ник уже занят другим пользователем. Обратитесь к администратору.",
+			change.OldName, change.NewName,
+		)
+	case permsengine.NameChangeProfileGone:
+		text = fmt.Sprintf(
+			"Официальный аккаунт <code>%s</code> больше не существует. Обратитесь к администратору.",
+			change.OldName,
+		)
+	}
+	if change.OldNameTaken {
+		text += fmt.Sprintf("\nНик <code>%s</code> теперь принадлежит другому игроку.", change.OldName)
+	}
+	return text
+}
+
+// sends HTML formatted message to all tg accounts of the actor
+func (bot *TgBot) NotifyActor(actorId authdb.ActorId, text string) {
+	actor := authdb.Actor{ID: actorId}
+	err := bot.permsEngine.GetActor(&actor)
+	if err != nil {
+		bot.logger.Error("Failed to get actor to notify", zap.Uint("actor_id", uint(actorId)), zap.Error(err))
+		return
+	}
+	for _, tgAcc := range actor.TgAccounts {
+		bot.SendLog(tgbotapi.NewMessage(int64(tgAcc.ID), text))
+	}
+}
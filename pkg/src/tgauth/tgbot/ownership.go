@@ -0,0 +1,45 @@
+package tgbot
+
+import (
+	"fmt"
+
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// pushes pending challenges to the overseer and assigns accounts confirmed on the server
+func (bot *TgBot) updateOwnershipVerifications() {
+	err := bot.permsEngine.UpdateOwnershipChallenges()
+	if err != nil {
+		bot.logger.Error("Failed to update ownership challenges", zap.Error(err))
+	}
+	results, err := bot.permsEngine.CompleteOwnershipVerifications()
+	if err != nil {
+		bot.logger.Error("Failed to complete ownership verifications", zap.Error(err))
+	}
+	for _, result := range results {
+		bot.logger.Info("Ownership verification completed",
+			zap.String("login", string(result.Challenge.Login)),
+			zap.Uint("actor_id", uint(result.Challenge.ActorID)),
+			zap.Error(result.Err),
+		)
+		bot.NotifyActor(result.Challenge.ActorID, describeOwnershipResult(result))
+	}
+}
+
+// HTML formatted
+func describeOwnershipResult(result permsengine.OwnershipVerificationResult) string {
+	login := result.Challenge.Login
+	switch {
+	case result.Err == nil:
+		return fmt.Sprintf("Аккаунт <code>%s</code> подтверждён и добавлен, теперь с ним можно зайти на сервер", login)
+	case errors.Is(result.Err, authdb.ErrorLoginTaken{}):
+		return fmt.Sprintf("Аккаунт <code>%s</code> подтверждён, но уже занят другим пользователем. Обратитесь к администратору.", login)
+	case errors.Is(result.Err, permsengine.ErrorExceededMaxMinecraftLogins{}):
+		return fmt.Sprintf("Аккаунт <code>%s</code> подтверждён, но превышен лимит зарегистрированных аккаунтов", login)
+	default:
+		return fmt.Sprintf("Аккаунт <code>%s</code> подтверждён, но добавить его сейчас нельзя. Обратитесь к администратору.", login)
+	}
+}
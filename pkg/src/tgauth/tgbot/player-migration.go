@@ -0,0 +1,104 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+type MigratePlayerDataHandler struct {
+	h       *CommonAdminHandler
+	fromRef string
+	toRef   string
+}
+
+func (handler *MigratePlayerDataHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	var text string
+	switch {
+	case handler.fromRef == "":
+		text = "Введите ник или UUID игрока, чей прогресс нужно перенести"
+	case handler.toRef == "":
+		text = "Введите ник или UUID игрока, которому перенести прогресс"
+	default:
+		text = fmt.Sprintf(
+			"Перенести прогресс <code>%s</code> на <code>%s</code>? Сервер будет перезапущен на время переноса.\n/confirm /abort",
+			tgbotapi.EscapeText(tgbotapi.ModeHTML, handler.fromRef), tgbotapi.EscapeText(tgbotapi.ModeHTML, handler.toRef),
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *MigratePlayerDataHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.fromRef == "" {
+		handler.fromRef = update.Message.Text
+		return handler, nil
+	}
+	if handler.toRef == "" {
+		handler.toRef = update.Message.Text
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminMigratePlayerData(actor.ID, handler.fromRef, handler.toRef)
+	var notRolledBack mcserver.ErrorMigrationNotRolledBack
+	var text string
+	switch {
+	case err == nil:
+		text = "Прогресс перенесён"
+	case errors.Is(err, permsengine.ErrorInvalidPlayerRef{}):
+		text = "Это не ник и не UUID, начните заново"
+	case errors.Is(err, mcserver.ErrorPlayerDataExists{}):
+		text = "У второго игрока уже есть прогресс на сервере, перенос отменён"
+	case errors.As(err, &notRolledBack):
+		text = describeFailedMigration(notRolledBack.Record)
+	default:
+		return nil, err
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return nil, nil
+}
+
+func (handler *MigratePlayerDataHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.toRef == "" {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Перенести"},
+	}
+}
+func (handler *MigratePlayerDataHandler) GetHelpDescription() string {
+	return "Сейчас вы переносите прогресс игрока"
+}
+func (handler *MigratePlayerDataHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+// HTML formatted, the admin has to fix the files by hand
+func describeFailedMigration(record mcserver.PlayerMigrationRecord) string {
+	descBuilder := strings.Builder{}
+	descBuilder.WriteString("Перенос прогресса не удался, и его не получилось откатить. Файлы нужно поправить вручную.\n")
+	descBuilder.WriteString(fmt.Sprintf("Ошибка: %s\n", tgbotapi.EscapeText(tgbotapi.ModeHTML, record.Error)))
+	descBuilder.WriteString(fmt.Sprintf("Ошибка отката: %s", tgbotapi.EscapeText(tgbotapi.ModeHTML, record.RollbackError)))
+	for _, path := range record.MovedFiles {
+		descBuilder.WriteString(fmt.Sprintf("\nПеренесён: <code>%s</code>", tgbotapi.EscapeText(tgbotapi.ModeHTML, path)))
+	}
+	for _, path := range record.RewrittenLists {
+		descBuilder.WriteString(fmt.Sprintf("\nИзменён: <code>%s</code>", tgbotapi.EscapeText(tgbotapi.ModeHTML, path)))
+	}
+	return descBuilder.String()
+}
@@ -154,7 +154,7 @@ type UserActionType string
 const (
 	UserActionTypeApprove UserActionType = "approve"
 	UserActionTypeReject  UserActionType = "reject"
-	UserActionTypeBan     UserActionType = "ban"
+	UserActionTypeUnban   UserActionType = "unban"
 )
 
 type UserActionHandler struct {
@@ -168,7 +168,7 @@ func (handler *UserActionHandler) InitialHandle(update *tgbotapi.Update, actor *
 		err := handler.h.promptForUser(update)
 		return handler, err
 	}
-	err := handler.h.promptForConfirmation(update, actor)
+	err := handler.h.promptForConfirmation(update, handler.selectedUser)
 	return handler, err
 }
 
@@ -199,17 +199,17 @@ func (handler *UserActionHandler) processConfirmation(update *tgbotapi.Update, a
 	}
 	switch handler.actionType {
 	case UserActionTypeApprove:
-		err = handler.h.bot.permsEngine.AdminVerifyActor(actor.ID, handler.selectedUser.ID)
+		err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminVerifyActor(actor.ID, handler.selectedUser.ID)
 		if err != nil {
 			return nil, err
 		}
 	case UserActionTypeReject:
-		err = handler.h.bot.permsEngine.AdminRejectActor(actor.ID, handler.selectedUser.ID)
+		err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminRejectActor(actor.ID, handler.selectedUser.ID)
 		if err != nil {
 			return nil, err
 		}
-	case UserActionTypeBan:
-		err = handler.h.bot.permsEngine.AdminBanActor(actor.ID, handler.selectedUser.ID)
+	case UserActionTypeUnban:
+		err = handler.unban(update, actor)
 		if err != nil {
 			return nil, err
 		}
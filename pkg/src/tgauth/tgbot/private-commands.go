@@ -26,8 +26,99 @@ func NewPrivateChatHandler(bot *TgBot, initialActor *authdb.Actor) *PrivateChatH
 	}
 }
 
-func (handler *PrivateChatHandler) IsLastAdmin() bool {
-	return handler.lastActor != nil && handler.lastActor.IsAdmin
+func (handler *PrivateChatHandler) lastActorCan(permission permsengine.Permission) bool {
+	return handler.lastActor != nil && handler.bot.permsEngine.HasPermission(handler.lastActor, permission)
+}
+
+type adminCommand struct {
+	command     tgtypes.BotCommand
+	permission  permsengine.Permission
+	makeHandler func(h *CommonAdminHandler) InteractiveHandler
+}
+
+// shown only to actors with the permission
+var adminCommands = []adminCommand{
+	{
+		command:    tgtypes.BotCommand{Command: "approve_user", Description: "Одобрить пользователя"},
+		permission: permsengine.PermVerifyActor,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler {
+			return &UserActionHandler{h: h, actionType: UserActionTypeApprove}
+		},
+	},
+	{
+		command:    tgtypes.BotCommand{Command: "reject_user", Description: "Отклонить пользователя"},
+		permission: permsengine.PermVerifyActor,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler {
+			return &UserActionHandler{h: h, actionType: UserActionTypeReject}
+		},
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "ban_user", Description: "Забанить пользователя на время или навсегда"},
+		permission:  permsengine.PermBanActor,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &BanUserHandler{h: h} },
+	},
+	{
+		command:    tgtypes.BotCommand{Command: "unban_user", Description: "Снять бан с пользователя"},
+		permission: permsengine.PermBanActor,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler {
+			return &UserActionHandler{h: h, actionType: UserActionTypeUnban}
+		},
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "list_users", Description: "Список пользователей"},
+		permission:  permsengine.PermListUsers,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &ListUsersHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "set_role", Description: "Назначить роль пользователю"},
+		permission:  permsengine.PermAssignRoles,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &SetRoleHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "set_login_limit", Description: "Изменить лимит аккаунтов пользователя"},
+		permission:  permsengine.PermSetLimits,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &SetLoginLimitHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "run_command", Description: "Выполнить команду на сервере"},
+		permission:  permsengine.PermRunCommands,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &RunCommandHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "access_passwords", Description: "Активные пароли доступа"},
+		permission:  permsengine.PermManageAccessPasswords,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &AccessPasswordsHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "new_access_password", Description: "Создать пароль доступа"},
+		permission:  permsengine.PermManageAccessPasswords,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &NewAccessPasswordHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "revoke_access_password", Description: "Отозвать пароль доступа"},
+		permission:  permsengine.PermManageAccessPasswords,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &RevokeAccessPasswordHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "migrate_account", Description: "Перенести пиратский аккаунт на другой ник"},
+		permission:  permsengine.PermMigratePlayers,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &MigrateAccountHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "migrate_player_data", Description: "Перенести прогресс игрока на другой UUID"},
+		permission:  permsengine.PermMigratePlayers,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &MigratePlayerDataHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "whois", Description: "Где игрок и что у него есть"},
+		permission:  permsengine.PermWhois,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &WhoisHandler{h: h} },
+	},
+	{
+		command:     tgtypes.BotCommand{Command: "audit", Description: "Журнал действий пользователей и админов"},
+		permission:  permsengine.PermViewAudit,
+		makeHandler: func(h *CommonAdminHandler) InteractiveHandler { return &AuditHandler{h: h} },
+	},
 }
 
 func (handler *PrivateChatHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
@@ -48,17 +139,12 @@ func (handler *PrivateChatHandler) HandleUpdate(update *tgbotapi.Update, actor *
 		return &NewPasswordHandler{bot: handler.bot}, nil
 	case "access":
 		return &AccessHandler{bot: handler.bot}, nil
+	case "invite":
+		return &InviteHandler{bot: handler.bot}, nil
 	default:
-		if handler.IsLastAdmin() {
-			switch command {
-			case "approve_user":
-				return &UserActionHandler{h: NewCommonAdminHandler(handler.bot), actionType: UserActionTypeApprove}, nil
-			case "reject_user":
-				return &UserActionHandler{h: NewCommonAdminHandler(handler.bot), actionType: UserActionTypeReject}, nil
-			case "ban_user":
-				return &UserActionHandler{h: NewCommonAdminHandler(handler.bot), actionType: UserActionTypeBan}, nil
-			case "list_users":
-				return &ListUsersHandler{h: NewCommonAdminHandler(handler.bot)}, nil
+		for _, adminCommand := range adminCommands {
+			if adminCommand.command.Command == command && handler.lastActorCan(adminCommand.permission) {
+				return adminCommand.makeHandler(NewCommonAdminHandler(handler.bot)), nil
 			}
 		}
 		return nil, ErrUnknownCommand{Update: update, Command: command}
@@ -72,14 +158,12 @@ func (handler *PrivateChatHandler) GetCommands() []tgtypes.BotCommand {
 		{Command: "remove_minecraft_login", Description: "Удалить аккаунт с сервера"},
 		{Command: "newpassword", Description: "Сгенерировать новый пароль для аккаунта"},
 		{Command: "access", Description: "Получить доступ к серверу"},
+		{Command: "invite", Description: "Пригласить друга на сервер"},
 	}
-	if handler.IsLastAdmin() {
-		commands = append(commands, []tgtypes.BotCommand{
-			{Command: "approve_user", Description: "Одобрить пользователя"},
-			{Command: "reject_user", Description: "Отклонить пользователя"},
-			{Command: "ban_user", Description: "Забанить пользователя"},
-			{Command: "list_users", Description: "Список пользователей"},
-		}...)
+	for _, adminCommand := range adminCommands {
+		if handler.lastActorCan(adminCommand.permission) {
+			commands = append(commands, adminCommand.command)
+		}
 	}
 	return commands
 }
@@ -219,7 +303,7 @@ func (handler *AddMinecraftLoginHandler) processLogin(update *tgbotapi.Update, a
 	handler.enteredLogin = login
 	needOnlineRequest := true
 	disclaimer := "\n\nОбратите внимание, что если владелец официального аккаунта с этим именем присоединится к серверу, вам придётся сменить ник."
-	playerId, err := mojang.QueryOnlineUuid(login, handler.bot.ctx)
+	playerId, err := handler.bot.mojangClient.QueryOnlineUuid(login, handler.bot.ctx)
 	if err != nil {
 		if errors.Is(err, mojang.NoSuchPlayerErr{}) {
 			needOnlineRequest = false
@@ -271,11 +355,10 @@ func (handler *AddMinecraftLoginHandler) finishAdding(update *tgbotapi.Update, a
 			handler.bot.SendLog(msg)
 			return nil, nil
 		}
-		playerId = handler.onlineId
-	} else {
-		playerId = mojang.GetOfflineUuid(handler.enteredLogin)
+		return handler.startOwnershipVerification(update, actor)
 	}
-	err := handler.bot.permsEngine.AssignMinecraftLogin(actor.ID, handler.enteredLogin, handler.isOnline, playerId)
+	playerId = mojang.GetOfflineUuid(handler.enteredLogin)
+	err := handler.bot.permsEngine.ForTgUpdate(update.UpdateID).AssignMinecraftLogin(actor.ID, handler.enteredLogin, handler.isOnline, playerId)
 	if err != nil {
 		if errors.Is(err, authdb.ErrorLoginTaken{}) {
 			handler.handleLoginExists(update, handler.enteredLogin)
@@ -298,20 +381,43 @@ func (handler *AddMinecraftLoginHandler) finishAdding(update *tgbotapi.Update, a
 	}
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Аккаунт добавлен, теперь с ним можно зайти на сервер")
 	handler.bot.SendLog(msg)
-	if !handler.isOnline {
-		newPassword := handler.bot.permsEngine.GeneratePassword()
-		err = handler.bot.permsEngine.SetPassword(actor.ID, handler.enteredLogin, newPassword)
-		if err != nil {
-			return nil, err
+	newPassword := handler.bot.permsEngine.GeneratePassword()
+	err = handler.bot.permsEngine.ForTgUpdate(update.UpdateID).SetPassword(actor.ID, handler.enteredLogin, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+		"Пароль для аккаунта <code>%s</code>: <code>%s</code>\n"+
+			"С маленькой вероятностью он мог не установиться на сервере. В этом случае используйте /newpassword",
+		handler.enteredLogin, newPassword,
+	))
+	msg.ParseMode = tgbotapi.ModeHTML
+	handler.bot.SendLog(msg)
+	return nil, nil
+}
+
+// official account is added once the player confirms ownership on the server
+func (handler *AddMinecraftLoginHandler) startOwnershipVerification(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	challenge, err := handler.bot.permsEngine.ForTgUpdate(update.UpdateID).StartOwnershipVerification(actor.ID, handler.enteredLogin, handler.onlineId)
+	if err != nil {
+		if errors.Is(err, permsengine.ErrorExceededMaxMinecraftLogins{}) {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Превышен лимит зарегистрированных аккаунтов")
+			handler.bot.SendLog(msg)
+			return nil, nil
 		}
-		msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
-			"Пароль для аккаунта <code>%s</code>: <code>%s</code>\n"+
-				"С маленькой вероятностью он мог не установиться на сервере. В этом случае используйте /newpassword",
-			handler.enteredLogin, newPassword,
-		))
-		msg.ParseMode = tgbotapi.ModeHTML
-		handler.bot.SendLog(msg)
+		if errors.Is(err, permsengine.ErrorNotAccepted{}) {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, handler.bot.needToVerifyDisclaimer())
+			handler.bot.SendLog(msg)
+			return nil, nil
+		}
+		return nil, err
 	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+		"Чтобы подтвердить, что аккаунт <code>%s</code> ваш, зайдите с ним на сервер и напишите в чат код <code>%s</code>.\n"+
+			"Код действует до %s.",
+		handler.enteredLogin, challenge.Code, challenge.ExpiresAt.Format("15:04 02.01.2006"),
+	))
+	handler.bot.SendLog(msg)
 	return nil, nil
 }
 
@@ -377,7 +483,7 @@ func (handler *RevokeMinecraftLoginHandler) HandleUpdate(update *tgbotapi.Update
 		handler.bot.SendLog(msg)
 		return nil, nil
 	}
-	err = handler.bot.permsEngine.RevokeMinecraftLogin(actor.ID, login)
+	err = handler.bot.permsEngine.ForTgUpdate(update.UpdateID).RevokeMinecraftLogin(actor.ID, login)
 	if err != nil {
 		if errors.Is(err, permsengine.ErrorNotYourLogin{}) {
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Этот аккаунт не принадлежит вам")
@@ -446,7 +552,7 @@ func (handler *NewPasswordHandler) HandleUpdate(update *tgbotapi.Update, actor *
 		return nil, nil
 	}
 	newPassword := handler.bot.permsEngine.GeneratePassword()
-	err = handler.bot.permsEngine.SetPassword(actor.ID, login, newPassword)
+	err = handler.bot.permsEngine.ForTgUpdate(update.UpdateID).SetPassword(actor.ID, login, newPassword)
 	if err != nil {
 		if errors.Is(err, permsengine.ErrorNotYourLogin{}) {
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Этот аккаунт не принадлежит вам")
@@ -483,21 +589,34 @@ func (handler *AccessHandler) InitialHandle(update *tgbotapi.Update, actor *auth
 		handler.bot.SendLog(msg)
 		return nil, nil
 	}
+	if until, locked := handler.bot.accessLockedUntil(actor.ID); locked {
+		handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, describeAccessLockout(until)))
+		return nil, nil
+	}
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Введите пароль для доступа. Если не знаете пароль спросите его в сабчате или у @imobulus")
 	handler.bot.SendLog(msg)
 	return handler, nil
 }
 
 func (handler *AccessHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
-	err := handler.bot.permsEngine.HandleAccessPassword(actor.ID, update.Message.Text)
+	if until, locked := handler.bot.accessLockedUntil(actor.ID); locked {
+		handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, describeAccessLockout(until)))
+		return nil, nil
+	}
+	err := handler.bot.permsEngine.ForTgUpdate(update.UpdateID).HandleAccessPassword(actor.ID, update.Message.Text)
 	if err != nil {
 		if errors.Is(err, permsengine.ErrorWrongAccessPassword{}) {
+			if until, locked := handler.bot.recordAccessFailure(actor.ID); locked {
+				handler.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, describeAccessLockout(until)))
+				return nil, nil
+			}
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный пароль, попробуйте еще раз или используйте /abort")
 			handler.bot.SendLog(msg)
 			return handler, nil
 		}
 		return handler, err
 	}
+	handler.bot.resetAccessAttempts(actor.ID)
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Доступ получен")
 	handler.bot.SendLog(msg)
 	return nil, nil
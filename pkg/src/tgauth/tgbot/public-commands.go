@@ -46,13 +46,13 @@ func (handler *PublicChatHandler) GetBot() *TgBot {
 }
 
 func (handler *PublicChatHandler) handleApproveCommand(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
-	if !actor.IsAdmin {
+	if !handler.bot.permsEngine.HasPermission(actor, permsengine.PermApproveChat) {
 		handler.bot.aux.SetReaction(tgtypes.UpdateChat(update), tgtypes.UpdateMessageId(update), "🗿")
 		return nil, nil
 	}
-	err := handler.bot.permsEngine.ApproveChat(actor.ID, tgtypes.UpdateChat(update))
+	err := handler.bot.permsEngine.ForTgUpdate(update.UpdateID).ApproveChat(actor.ID, tgtypes.UpdateChat(update))
 	if err != nil {
-		if errors.Is(err, permsengine.ErrorAdminPermissionDenied{}) {
+		if errors.Is(err, permsengine.ErrorPermissionDenied{}) {
 			handler.bot.aux.SetReaction(tgtypes.UpdateChat(update), tgtypes.UpdateMessageId(update), "🗿")
 			return nil, nil
 		}
@@ -0,0 +1,216 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+type SetRoleHandler struct {
+	h            *CommonAdminHandler
+	selectedUser *authdb.Actor
+	role         permsengine.Role
+}
+
+func (handler *SetRoleHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	var text string
+	switch {
+	case handler.selectedUser == nil:
+		err := handler.h.promptForUser(update)
+		return handler, err
+	case handler.role == "":
+		roles := []string{}
+		for _, role := range permsengine.AllRoles() {
+			roles = append(roles, fmt.Sprintf("<code>%s</code>", role))
+		}
+		text = fmt.Sprintf(
+			"Текущая роль: <code>%s</code>\nВведите новую роль: %s",
+			permsengine.ActorRole(handler.selectedUser), strings.Join(roles, ", "),
+		)
+	default:
+		text = fmt.Sprintf(
+			"%s\nНазначить роль <code>%s</code>? /confirm /abort",
+			getUserDescriptionForAdmin(handler.selectedUser), handler.role,
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *SetRoleHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	switch {
+	case handler.selectedUser == nil:
+		user, err := handler.h.parseUserFromUpdateInteractive(update)
+		if err != nil {
+			return nil, err
+		}
+		handler.selectedUser = user
+		return handler, nil
+	case handler.role == "":
+		role, err := permsengine.ParseRole(strings.TrimSpace(strings.ToLower(update.Message.Text)))
+		if err != nil {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неизвестная роль"))
+			return handler, nil
+		}
+		handler.role = role
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminSetRole(actor.ID, handler.selectedUser.ID, handler.role)
+	if err != nil {
+		if errors.Is(err, permsengine.ErrorRoleTooHigh{}) {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Можно управлять только ролями ниже своей"))
+			return nil, nil
+		}
+		return nil, err
+	}
+	handler.h.bot.NotifyActor(handler.selectedUser.ID, fmt.Sprintf("Ваша роль на сервере: <code>%s</code>", handler.role))
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Роль назначена"))
+	return nil, nil
+}
+
+func (handler *SetRoleHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.role == "" {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Назначить роль"},
+		// abort command handler upstream
+	}
+}
+func (handler *SetRoleHandler) GetHelpDescription() string {
+	return "Сейчас вы назначаете роль пользователю"
+}
+func (handler *SetRoleHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+type SetLoginLimitHandler struct {
+	h            *CommonAdminHandler
+	selectedUser *authdb.Actor
+}
+
+func (handler *SetLoginLimitHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.selectedUser == nil {
+		err := handler.h.promptForUser(update)
+		return handler, err
+	}
+	current := "по умолчанию"
+	if handler.selectedUser.CustomMinecraftLoginLimit != nil {
+		current = strconv.Itoa(*handler.selectedUser.CustomMinecraftLoginLimit)
+	}
+	text := fmt.Sprintf(
+		"%s\nТекущий лимит аккаунтов: %s\nВведите новый лимит числом или <code>default</code>",
+		getUserDescriptionForAdmin(handler.selectedUser), current,
+	)
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *SetLoginLimitHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.selectedUser == nil {
+		user, err := handler.h.parseUserFromUpdateInteractive(update)
+		if err != nil {
+			return nil, err
+		}
+		handler.selectedUser = user
+		return handler, nil
+	}
+	text := strings.TrimSpace(strings.ToLower(update.Message.Text))
+	var limit *int
+	if text != "default" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный лимит"))
+			return handler, nil
+		}
+		limit = &n
+	}
+	err := handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminSetMinecraftLoginLimit(actor.ID, handler.selectedUser.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Лимит изменён"))
+	return nil, nil
+}
+
+func (handler *SetLoginLimitHandler) GetCommands() []tgtypes.BotCommand {
+	return nil
+}
+func (handler *SetLoginLimitHandler) GetHelpDescription() string {
+	return "Сейчас вы меняете лимит аккаунтов пользователя"
+}
+func (handler *SetLoginLimitHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
+
+type RunCommandHandler struct {
+	h       *CommonAdminHandler
+	command string
+}
+
+func (handler *RunCommandHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	text := "Введите команду сервера без /"
+	if handler.command != "" {
+		text = fmt.Sprintf(
+			"Выполнить <code>%s</code>? /confirm /abort",
+			tgbotapi.EscapeText(tgbotapi.ModeHTML, handler.command),
+		)
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+	return handler, nil
+}
+
+func (handler *RunCommandHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	if handler.command == "" {
+		handler.command = strings.TrimSpace(update.Message.Text)
+		return handler, nil
+	}
+	resp, err := handler.h.processConfirmationInteractive(update)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return handler, nil
+	}
+	if !*resp {
+		return nil, nil
+	}
+	err = handler.h.bot.permsEngine.ForTgUpdate(update.UpdateID).AdminRunCommand(actor.ID, handler.command)
+	if err != nil {
+		return nil, err
+	}
+	handler.h.bot.SendLog(tgbotapi.NewMessage(update.Message.Chat.ID, "Команда отправлена на сервер"))
+	return nil, nil
+}
+
+func (handler *RunCommandHandler) GetCommands() []tgtypes.BotCommand {
+	if handler.command == "" {
+		return nil
+	}
+	return []tgtypes.BotCommand{
+		{Command: "confirm", Description: "Выполнить"},
+		// abort command handler upstream
+	}
+}
+func (handler *RunCommandHandler) GetHelpDescription() string {
+	return "Сейчас вы выполняете команду на сервере"
+}
+func (handler *RunCommandHandler) GetBot() *TgBot {
+	return handler.h.bot
+}
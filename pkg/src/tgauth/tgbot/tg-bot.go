@@ -8,6 +8,7 @@ import (
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mojang"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
 	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
@@ -52,11 +53,31 @@ func (handler *ChatHandler) GetScope() *tgtypes.BotCommandScope {
 type TgBotConfig struct {
 	Debug                 bool          `yaml:"debug"`
 	SetWhitelistFrequency time.Duration `yaml:"set whitelist frequency"`
+	// 0 disables the check
+	CheckNamesFrequency time.Duration `yaml:"check names frequency"`
+	// 0 disables the check
+	CheckCollisionsFrequency time.Duration `yaml:"check collisions frequency"`
+	// removal of actors after the grace period of leaving a chat, 0 disables it
+	CheckChatLeavesFrequency time.Duration `yaml:"check chat leaves frequency"`
+	// check of every member of approved chats with getChatMember, 0 disables it
+	ReconcileChatsFrequency time.Duration `yaml:"reconcile chats frequency"`
+	// pause between getChatMember requests of the check, telegram limits the request rate
+	ReconcileChatsRequestInterval time.Duration `yaml:"reconcile chats request interval"`
+	// wrong access passwords in a row before the actor is locked out
+	AccessMaxAttempts     int           `yaml:"access max attempts"`
+	AccessLockoutDuration time.Duration `yaml:"access lockout duration"`
 }
 
 var DefaultTgBotConfig = TgBotConfig{
-	Debug:                 false,
-	SetWhitelistFrequency: time.Second,
+	Debug:                         false,
+	SetWhitelistFrequency:         time.Second,
+	CheckNamesFrequency:           6 * time.Hour,
+	CheckCollisionsFrequency:      24 * time.Hour,
+	CheckChatLeavesFrequency:      time.Minute,
+	ReconcileChatsFrequency:       24 * time.Hour,
+	ReconcileChatsRequestInterval: 100 * time.Millisecond,
+	AccessMaxAttempts:             5,
+	AccessLockoutDuration:         time.Hour,
 }
 
 type TgBotSecret struct {
@@ -65,6 +86,7 @@ type TgBotSecret struct {
 }
 
 type TgBot struct {
+	config TgBotConfig
 	api    *tgbotapi.BotAPI
 	aux    *tgtypes.AuxTgApi
 	secret TgBotSecret
@@ -72,6 +94,10 @@ type TgBot struct {
 	chatHandlersMap map[InteractiveSessionId]*ChatHandler
 	chatHandlersMx  *sync.Mutex
 	permsEngine     *permsengine.ServerPermsEngine
+	mojangClient    *mojang.Client
+
+	accessAttempts   map[authdb.ActorId]*accessAttempts
+	accessAttemptsMx *sync.Mutex
 
 	doneC chan struct{}
 	wg    *sync.WaitGroup
@@ -84,6 +110,7 @@ type TgBot struct {
 func NewTgBot(
 	config TgBotConfig, secret TgBotSecret,
 	permsEngine *permsengine.ServerPermsEngine,
+	mojangClient *mojang.Client,
 	logger *zap.Logger, ctx context.Context,
 ) (*TgBot, error) {
 	api, err := tgbotapi.NewBotAPI(secret.Token)
@@ -92,21 +119,35 @@ func NewTgBot(
 	}
 	log.Printf("Authorized bot %s", api.Self.UserName)
 	api.Debug = config.Debug
+	return newTgBotWithApi(config, secret, api, permsEngine, mojangClient, logger, ctx), nil
+}
+
+func newTgBotWithApi(
+	config TgBotConfig, secret TgBotSecret,
+	api *tgbotapi.BotAPI,
+	permsEngine *permsengine.ServerPermsEngine,
+	mojangClient *mojang.Client,
+	logger *zap.Logger, ctx context.Context,
+) *TgBot {
 	ctx, cancel := context.WithCancel(ctx)
 	tgBot := TgBot{
-		api:             api,
-		aux:             tgtypes.NewAuxTgApi(api, logger),
-		secret:          secret,
-		chatHandlersMap: make(map[InteractiveSessionId]*ChatHandler),
-		chatHandlersMx:  &sync.Mutex{},
-		permsEngine:     permsEngine,
-		doneC:           make(chan struct{}),
-		wg:              &sync.WaitGroup{},
-		logger:          logger,
-		ctx:             ctx,
-		cancel:          cancel,
-	}
-	return &tgBot, nil
+		config:           config,
+		api:              api,
+		aux:              tgtypes.NewAuxTgApi(api, logger),
+		secret:           secret,
+		chatHandlersMap:  make(map[InteractiveSessionId]*ChatHandler),
+		chatHandlersMx:   &sync.Mutex{},
+		permsEngine:      permsEngine,
+		mojangClient:     mojangClient,
+		accessAttempts:   make(map[authdb.ActorId]*accessAttempts),
+		accessAttemptsMx: &sync.Mutex{},
+		doneC:            make(chan struct{}),
+		wg:               &sync.WaitGroup{},
+		logger:           logger,
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+	return &tgBot
 }
 
 func (bot *TgBot) Run() error {
@@ -114,7 +155,13 @@ func (bot *TgBot) Run() error {
 	if err != nil {
 		return err
 	}
+	bot.permsEngine.SetAdminStatusListener(bot.notifyAdminStatusChange)
+	bot.reportLegacyAdmins()
 	bot.runWhitelistSetter()
+	bot.runNameSync()
+	bot.runCollisionCheck()
+	bot.runChatLeavesCheck()
+	bot.runChatsReconciliation()
 	bot.runUpdatesLoop()
 	return nil
 }
@@ -138,7 +185,7 @@ func (bot *TgBot) initCommands() error {
 
 func (bot *TgBot) runWhitelistSetter() {
 	go func() {
-		ticker := time.NewTicker(DefaultTgBotConfig.SetWhitelistFrequency)
+		ticker := time.NewTicker(bot.config.SetWhitelistFrequency)
 		defer ticker.Stop()
 		for {
 			select {
@@ -156,11 +203,26 @@ func (bot *TgBot) setWhitelist() {
 	if err != nil {
 		bot.logger.Error("Failed to update whitelist", zap.Error(err))
 	}
+	err = bot.permsEngine.UpdateOps()
+	if err != nil {
+		bot.logger.Error("Failed to update ops", zap.Error(err))
+	}
+	err = bot.permsEngine.UpdateBans()
+	if err != nil {
+		bot.logger.Error("Failed to update bans", zap.Error(err))
+	}
+	err = bot.permsEngine.UpdateRoles()
+	if err != nil {
+		bot.logger.Error("Failed to update roles", zap.Error(err))
+	}
+	bot.updateOwnershipVerifications()
 }
 
 func (bot *TgBot) runUpdatesLoop() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
+	// chat_member is not sent unless it is requested
+	u.AllowedUpdates = []string{"message", "chat_member"}
 
 	updates := bot.api.GetUpdatesChan(u)
 
@@ -194,6 +256,10 @@ func (bot *TgBot) runUpdatesLoop() {
 }
 
 func (bot *TgBot) handleUpdate(update tgbotapi.Update) {
+	if update.ChatMember != nil {
+		bot.handleChatMemberUpdate(&update)
+		return
+	}
 	if update.Message == nil {
 		// bot.logger.Error("update.Message is nil")
 		return
@@ -306,7 +372,7 @@ func (bot *TgBot) handleChatMessageUpdate(chatHandler *ChatHandler, update *tgbo
 		TgAccounts:        []authdb.TgUser{},
 		MinecraftAccounts: []authdb.MinecraftAccount{},
 	}
-	err := bot.permsEngine.UpdateTgUserInfo(*update.Message.From)
+	err := bot.permsEngine.ForTgUpdate(update.UpdateID).UpdateTgUserInfo(*update.Message.From)
 	if err != nil {
 		bot.HandleUpdateError(update, err)
 		return
@@ -318,18 +384,18 @@ func (bot *TgBot) handleChatMessageUpdate(chatHandler *ChatHandler, update *tgbo
 	}
 	chatHandler.lastActor = actor
 	if update.Message.Chat.Type == tgtypes.GroupChatType || update.Message.Chat.Type == tgtypes.SupergroupChatType {
-		err := bot.permsEngine.SeenInChat(actor.ID, authdb.TgChatId(update.Message.Chat.ID))
+		err := bot.permsEngine.ForTgUpdate(update.UpdateID).SeenInChat(actor.ID, authdb.TgChatId(update.Message.Chat.ID))
 		if err != nil {
 			bot.HandleUpdateError(update, err)
 			return
 		}
 	}
-	err = bot.permsEngine.UpdateActorStatus(actor.ID, false)
+	err = bot.permsEngine.ForTgUpdate(update.UpdateID).UpdateActorStatus(actor.ID, false)
 	if err != nil {
 		bot.HandleUpdateError(update, err)
 	}
 	defer func() {
-		err := bot.permsEngine.UpdateActorStatus(actor.ID, false)
+		err := bot.permsEngine.ForTgUpdate(update.UpdateID).UpdateActorStatus(actor.ID, false)
 		if err != nil {
 			bot.HandleUpdateError(update, err)
 		}
@@ -0,0 +1,95 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/imobulus/subchat-mc-server/src/mcserver"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/authdb"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/mcauth/permsengine"
+	"github.com/imobulus/subchat-mc-server/src/tgauth/tgbot/tgtypes"
+)
+
+// items shown in the inventory summary
+const whoisItemsLimit = 15
+
+type WhoisHandler struct {
+	h *CommonAdminHandler
+}
+
+func (handler *WhoisHandler) InitialHandle(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Введите ник или UUID игрока")
+	handler.h.bot.SendLog(msg)
+	return handler, nil
+}
+
+func (handler *WhoisHandler) HandleUpdate(update *tgbotapi.Update, actor *authdb.Actor) (InteractiveHandler, error) {
+	whois, err := handler.h.bot.permsEngine.AdminWhois(actor.ID, update.Message.Text)
+	if err != nil {
+		if errors.Is(err, permsengine.ErrorInvalidPlayerRef{}) {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Это не ник и не UUID, введите другой")
+			handler.h.bot.SendLog(msg)
+			return handler, nil
+		}
+		if !errors.Is(err, mcserver.ErrorNoPlayerData{}) {
+			return nil, err
+		}
+	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, describeWhois(whois))
+	handler.h.bot.SendLog(msg)
+	return nil, nil
+}
+
+// HTML formatted
+func describeWhois(whois *permsengine.PlayerWhois) string {
+	b := strings.Builder{}
+	if whois.Login != "" {
+		b.WriteString(fmt.Sprintf("<code>%s</code> ", whois.Login))
+	}
+	b.WriteString(fmt.Sprintf("<code>%s</code>\n", whois.PlayerId))
+	if whois.Owner != nil {
+		b.WriteString(getUserDescriptionForAdmin(whois.Owner))
+		b.WriteString("\n")
+	} else if whois.Login != "" {
+		b.WriteString("Ник не зарегистрирован\n")
+	}
+	info := whois.Info
+	if info == nil {
+		b.WriteString("Игрок ещё не заходил на сервер")
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf(
+		"%s %.0f %.0f %.0f\nРежим: %s, здоровье: %.1f, уровень: %d\n",
+		info.Dimension, info.Position[0], info.Position[1], info.Position[2],
+		info.GameMode, info.Health, info.XpLevel,
+	))
+	writeItems := func(title string, items []mcserver.InventoryItem) {
+		if len(items) == 0 {
+			return
+		}
+		b.WriteString(title)
+		for i, item := range items {
+			if i >= whoisItemsLimit {
+				b.WriteString(fmt.Sprintf("\nи ещё %d", len(items)-whoisItemsLimit))
+				break
+			}
+			b.WriteString(fmt.Sprintf("\n%s ×%d", strings.TrimPrefix(item.Id, "minecraft:"), item.Count))
+		}
+		b.WriteString("\n")
+	}
+	writeItems("Инвентарь:", info.Inventory)
+	writeItems("Эндер-сундук:", info.EnderChest)
+	return b.String()
+}
+
+func (handler *WhoisHandler) GetCommands() []tgtypes.BotCommand {
+	return nil
+}
+func (handler *WhoisHandler) GetHelpDescription() string {
+	return "Сейчас вы смотрите информацию об игроке"
+}
+func (handler *WhoisHandler) GetBot() *TgBot {
+	return handler.h.bot
+}